@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var catcmd = &cobra.Command{
+	Use:   "cat",
+	Short: "Write an archive member to stdout",
+	Run:   cat,
+}
+
+var ErrEmptyMember = errors.New("member path is not specified")
+
+// cat runs archivist cat ARCHIVE MEMBER.
+func cat(cmd *cobra.Command, args []string) {
+	if len(args) == 0 || args[0] == "" {
+		handleErr(ErrEmptyArchivePath)
+	}
+	if len(args) == 1 || args[1] == "" {
+		handleErr(ErrEmptyMember)
+	}
+
+	archivePath, member := args[0], args[1]
+
+	if _, err := os.Stat(archivePath); os.IsNotExist(err) {
+		handleErr(fmt.Errorf("archive %s does not exist: %w", archivePath, err))
+	}
+
+	catter, err := openArchive(cmd, archivePath, nil)
+	if err != nil {
+		handleErr(err)
+	}
+
+	if err := catter.Cat(member, os.Stdout); err != nil {
+		handleErr(fmt.Errorf("%s: %w", archivePath, err))
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(catcmd)
+
+	catcmd.Flags().StringP("method", "m", "", "decompression method: vlc")
+	catcmd.Flags().StringArrayP("identity", "i", nil, "age identity file used to decrypt the archive (repeatable)")
+	catcmd.Flags().String("passphrase-file", "", "decrypt the archive with the passphrase stored in a file")
+}
@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"archivist/lib/compression/convert"
+	"archivist/lib/compression/parallel"
+	"archivist/lib/encryption"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"strings"
+)
+
+var convertcmd = &cobra.Command{
+	Use:   "convert",
+	Short: "Convert an archive to another format",
+	Run:   convertArchive,
+}
+
+var ErrEmptyOutputPath = errors.New("output archive path is not specified")
+
+var ErrAgeWithoutRecipients = errors.New("output ends in .age but no --recipient or --recipients-file is given")
+
+// convertArchive runs archivist convert IN OUT. The output format follows
+// the extension of OUT.
+func convertArchive(cmd *cobra.Command, args []string) {
+	if len(args) == 0 || args[0] == "" {
+		handleErr(ErrEmptyArchivePath)
+	}
+	if len(args) == 1 || args[1] == "" {
+		handleErr(ErrEmptyOutputPath)
+	}
+
+	inPath, outPath := args[0], args[1]
+
+	if _, err := os.Stat(inPath); os.IsNotExist(err) {
+		handleErr(fmt.Errorf("archive %s does not exist: %w", inPath, err))
+	}
+
+	identities, err := identitiesFlag(cmd)
+	if err != nil {
+		handleErr(err)
+	}
+
+	inMethod, err := archiveMethod(cmd, inPath, identities)
+	if err != nil {
+		handleErr(err)
+	}
+
+	outMethod := methodFromName(strings.TrimSuffix(outPath, ".age"))
+	if outMethod == "" {
+		handleErr(fmt.Errorf("cannot determine compression method of %s from its extension", outPath))
+	}
+
+	keys, err := cmd.Flags().GetStringArray("recipient")
+	if err != nil {
+		handleErr(err)
+	}
+	files, err := cmd.Flags().GetStringArray("recipients-file")
+	if err != nil {
+		handleErr(err)
+	}
+	recipients, err := encryption.ParseRecipients(keys, files, "")
+	if err != nil {
+		handleErr(err)
+	}
+	// The extension promises an encrypted archive.
+	if strings.HasSuffix(outPath, ".age") && len(recipients) == 0 {
+		handleErr(ErrAgeWithoutRecipients)
+	}
+
+	threads, err := cmd.Flags().GetInt("threads")
+	if err != nil {
+		handleErr(err)
+	}
+
+	logger, err := loggerFlag(cmd)
+	if err != nil {
+		handleErr(err)
+	}
+
+	opts := convert.Options{Identities: identities, Recipients: recipients, Threads: parallel.Threads(threads), Logger: logger}
+	if err := convert.Convert(inPath, inMethod, outPath, outMethod, opts); err != nil {
+		handleErr(fmt.Errorf("failed to convert %s to %s: %w", inPath, outPath, err))
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(convertcmd)
+
+	convertcmd.Flags().StringP("method", "m", "", "decompression method of the input archive: vlc")
+	convertcmd.Flags().StringArrayP("identity", "i", nil, "age identity file used to decrypt the input archive (repeatable)")
+	convertcmd.Flags().String("passphrase-file", "", "decrypt the input archive with the passphrase stored in a file")
+	convertcmd.Flags().StringArrayP("recipient", "r", nil, "encrypt the output archive to an age X25519 public key (repeatable)")
+	convertcmd.Flags().StringArrayP("recipients-file", "R", nil, "encrypt the output archive to the age recipients listed in a file (repeatable)")
+	convertcmd.Flags().IntP("threads", "T", 0, "compression threads for tar.gz and tar.xz output (0 uses all cores)")
+}
@@ -0,0 +1,146 @@
+package cmd
+
+import (
+	"archivist/lib/compression/diff"
+	"encoding/json"
+	"errors"
+	"filippo.io/age"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"strings"
+)
+
+var diffcmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Compare two archives, or an archive and a directory",
+	Run:   diffArchives,
+}
+
+var ErrDiffArgs = errors.New("diff needs two archives or directories")
+
+// Exit codes of diff, following diff(1).
+const (
+	diffSame    = 0
+	diffChanged = 1
+	diffTrouble = 2
+)
+
+// diffArchives runs archivist diff OLD NEW.
+func diffArchives(cmd *cobra.Command, args []string) {
+	if len(args) != 2 || args[0] == "" || args[1] == "" {
+		diffErr(ErrDiffArgs)
+	}
+
+	format, err := cmd.Flags().GetString("format")
+	if err != nil {
+		diffErr(err)
+	}
+	if format != "text" && format != "json" {
+		diffErr(fmt.Errorf("unknown output format %q: use text or json", format))
+	}
+
+	ignoreModTime, err := cmd.Flags().GetBool("ignore-mtime")
+	if err != nil {
+		diffErr(err)
+	}
+
+	identities, err := identitiesFlag(cmd)
+	if err != nil {
+		diffErr(err)
+	}
+
+	old, err := snapshot(cmd, args[0], identities)
+	if err != nil {
+		diffErr(err)
+	}
+	new, err := snapshot(cmd, args[1], identities)
+	if err != nil {
+		diffErr(err)
+	}
+
+	changes := diff.Compare(old, new, diff.Options{IgnoreModTime: ignoreModTime})
+
+	if format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(changes); err != nil {
+			diffErr(err)
+		}
+	} else {
+		for _, change := range changes {
+			fmt.Println(formatChange(change))
+		}
+	}
+
+	if len(changes) > 0 {
+		os.Exit(diffChanged)
+	}
+	os.Exit(diffSame)
+}
+
+// snapshot reads path as a directory or, failing that, as an archive.
+func snapshot(cmd *cobra.Command, path string, identities []age.Identity) (diff.Snapshot, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return diff.ReadDir(path)
+	}
+
+	method, err := archiveMethod(cmd, path, identities)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot, err := diff.ReadArchive(path, method, identities)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	return snapshot, nil
+}
+
+// formatChange renders a change as "+ path", "- path" or
+// "~ path: field old -> new, ...".
+func formatChange(change diff.Change) string {
+	switch change.Kind {
+	case diff.Added:
+		return "+ " + change.Path
+	case diff.Removed:
+		return "- " + change.Path
+	}
+
+	fields := make([]string, len(change.Fields))
+	for i, field := range change.Fields {
+		old, new := field.Old, field.New
+		if field.Name == "content" {
+			old, new = shortDigest(old), shortDigest(new)
+		}
+		fields[i] = fmt.Sprintf("%s %s -> %s", field.Name, old, new)
+	}
+
+	return "~ " + change.Path + ": " + strings.Join(fields, ", ")
+}
+
+func shortDigest(digest string) string {
+	if len(digest) > 12 {
+		return digest[:12]
+	}
+	return digest
+}
+
+func diffErr(err error) {
+	_, _ = fmt.Fprintln(os.Stderr, err)
+	os.Exit(diffTrouble)
+}
+
+func init() {
+	rootCmd.AddCommand(diffcmd)
+
+	diffcmd.Flags().String("format", "text", "output format: text or json")
+	diffcmd.Flags().Bool("ignore-mtime", false, "don't compare modification times")
+	diffcmd.Flags().StringArrayP("identity", "i", nil, "age identity file used to decrypt encrypted archives (repeatable)")
+	diffcmd.Flags().String("passphrase-file", "", "decrypt encrypted archives with the passphrase stored in a file")
+}
@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"archivist/lib/compression/diff"
+	tarformat "archivist/lib/compression/tar"
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// argsEnv carries the arguments of a command the test binary runs as
+// archivist, one per line.
+const argsEnv = "ARCHIVIST_TEST_ARGS"
+
+func TestMain(m *testing.M) {
+	if args, ok := os.LookupEnv(argsEnv); ok {
+		rootCmd.SetArgs(strings.Split(args, "\n"))
+		Execute()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// run runs archivist with args and returns its output and exit code.
+func run(t *testing.T, args ...string) (string, string, int) {
+	t.Helper()
+
+	command := exec.Command(os.Args[0])
+	command.Env = append(os.Environ(), argsEnv+"="+strings.Join(args, "\n"))
+	var stdout, stderr bytes.Buffer
+	command.Stdout, command.Stderr = &stdout, &stderr
+
+	err := command.Run()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		t.Fatal(err)
+	}
+	return stdout.String(), stderr.String(), command.ProcessState.ExitCode()
+}
+
+func TestDiffExitCodes(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.MkdirAll(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string]string{"a": "alpha", "b": "bravo"} {
+		if err := os.WriteFile(filepath.Join(src, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	archive := filepath.Join(dir, "src.tar")
+	if err := tarformat.New(archive).Encode([]string{src}); err != nil {
+		t.Fatal(err)
+	}
+
+	if stdout, stderr, code := run(t, "diff", archive, src); code != diffSame || stdout != "" {
+		t.Errorf("diff of an archive and its tree = %d, %q, %q; want %d and no output", code, stdout, stderr, diffSame)
+	}
+
+	if err := os.Remove(filepath.Join(src, "b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "c"), []byte("charlie"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr, code := run(t, "diff", "--ignore-mtime", archive, src)
+	if want := "- src/b\n+ src/c\n"; code != diffChanged || stdout != want {
+		t.Errorf("diff after changes = %d, %q, %q; want %d and %q", code, stdout, stderr, diffChanged, want)
+	}
+
+	stdout, stderr, code = run(t, "diff", "--format", "json", archive, src)
+	if code != diffChanged || !strings.Contains(stdout, `"change": "removed"`) {
+		t.Errorf("diff --format json = %d, %q, %q; want %d and the removal", code, stdout, stderr, diffChanged)
+	}
+
+	if _, stderr, code := run(t, "diff", archive, filepath.Join(dir, "missing")); code != diffTrouble || stderr == "" {
+		t.Errorf("diff with a missing tree = %d, %q; want %d and an error", code, stderr, diffTrouble)
+	}
+	if _, _, code := run(t, "diff", archive); code != diffTrouble {
+		t.Errorf("diff with one argument = %d, want %d", code, diffTrouble)
+	}
+}
+
+func TestFormatChange(t *testing.T) {
+	changes := []struct {
+		change diff.Change
+		want   string
+	}{
+		{diff.Change{Path: "src/a", Kind: diff.Added}, "+ src/a"},
+		{diff.Change{Path: "src/a", Kind: diff.Removed}, "- src/a"},
+		{
+			diff.Change{Path: "src/a", Kind: diff.Modified, Fields: []diff.Field{
+				{Name: "size", Old: "5", New: "7"},
+				{Name: "content", Old: "0123456789abcdef", New: "fedcba9876543210"},
+			}},
+			"~ src/a: size 5 -> 7, content 0123456789ab -> fedcba987654",
+		},
+	}
+
+	for _, c := range changes {
+		if got := formatChange(c.change); got != c.want {
+			t.Errorf("formatChange(%+v) = %q, want %q", c.change, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"archivist/lib/compression/grep"
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"regexp"
+)
+
+var grepcmd = &cobra.Command{
+	Use:   "grep",
+	Short: "Search archive members for a regular expression",
+	Run:   grepArchives,
+}
+
+var ErrEmptyPattern = errors.New("pattern is not specified")
+
+// Exit codes of grep, following grep(1).
+const (
+	grepMatched = 0
+	grepNone    = 1
+	grepTrouble = 2
+)
+
+// grepArchives runs archivist grep PATTERN ARCHIVE... An archive that can't
+// be read is reported and the search goes on with the next one.
+func grepArchives(cmd *cobra.Command, args []string) {
+	if len(args) == 0 || args[0] == "" {
+		grepErr(ErrEmptyPattern)
+	}
+	if len(args) == 1 {
+		grepErr(ErrEmptyArchivePath)
+	}
+
+	expr := args[0]
+	ignoreCase, err := cmd.Flags().GetBool("ignore-case")
+	if err != nil {
+		grepErr(err)
+	}
+	if ignoreCase {
+		expr = "(?i)" + expr
+	}
+	pattern, err := regexp.Compile(expr)
+	if err != nil {
+		grepErr(fmt.Errorf("invalid pattern: %w", err))
+	}
+
+	var opts grep.Options
+	if opts.Include, err = cmd.Flags().GetStringArray("include"); err != nil {
+		grepErr(err)
+	}
+	if opts.Exclude, err = cmd.Flags().GetStringArray("exclude"); err != nil {
+		grepErr(err)
+	}
+	if opts.Options, err = nestedFlags(cmd); err != nil {
+		grepErr(err)
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	matched, failed := false, false
+
+	print := func(match grep.Match) error {
+		matched = true
+		if match.Binary {
+			_, err := fmt.Fprintf(out, "%s:%s: binary file matches\n", match.Archive, match.Member)
+			return err
+		}
+		_, err := fmt.Fprintf(out, "%s:%s:%d:%s\n", match.Archive, match.Member, match.Line, match.Text)
+		return err
+	}
+
+	for _, archivePath := range args[1:] {
+		method, err := archiveMethod(cmd, archivePath, opts.Identities)
+		if err == nil {
+			err = grep.Grep(archivePath, method, pattern, opts, print)
+		}
+		if err != nil {
+			out.Flush()
+			_, _ = fmt.Fprintf(os.Stderr, "%s: %v\n", archivePath, err)
+			failed = true
+		}
+	}
+
+	if err := out.Flush(); err != nil {
+		grepErr(err)
+	}
+
+	switch {
+	case failed:
+		os.Exit(grepTrouble)
+	case !matched:
+		os.Exit(grepNone)
+	}
+	os.Exit(grepMatched)
+}
+
+func grepErr(err error) {
+	_, _ = fmt.Fprintln(os.Stderr, err)
+	os.Exit(grepTrouble)
+}
+
+func init() {
+	rootCmd.AddCommand(grepcmd)
+
+	grepcmd.Flags().StringP("method", "m", "", "decompression method: vlc")
+	grepcmd.Flags().Bool("ignore-case", false, "match without regard to case")
+	grepcmd.Flags().StringArray("include", nil, "only search members whose name or base name matches the glob (repeatable)")
+	grepcmd.Flags().StringArray("exclude", nil, "skip members whose name or base name matches the glob (repeatable)")
+	grepcmd.Flags().StringArrayP("identity", "i", nil, "age identity file used to decrypt the archives (repeatable)")
+	grepcmd.Flags().String("passphrase-file", "", "decrypt the archives with the passphrase stored in a file")
+	addNestedFlags(grepcmd)
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"archive/tar"
+	"archivist/lib/compression/walk"
+	"bufio"
+	"fmt"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+)
+
+var listcmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the entries of an archive",
+	Run:   list,
+}
+
+// list runs archivist list ARCHIVE, printing one entry per line.
+func list(cmd *cobra.Command, args []string) {
+	if len(args) == 0 || args[0] == "" {
+		handleErr(ErrEmptyArchivePath)
+	}
+
+	archivePath := args[0]
+
+	if _, err := os.Stat(archivePath); os.IsNotExist(err) {
+		handleErr(fmt.Errorf("archive %s does not exist: %w", archivePath, err))
+	}
+
+	long, err := cmd.Flags().GetBool("long")
+	if err != nil {
+		handleErr(err)
+	}
+
+	nested, err := nestedFlags(cmd)
+	if err != nil {
+		handleErr(err)
+	}
+
+	method, err := archiveMethod(cmd, archivePath, nested.Identities)
+	if err != nil {
+		handleErr(err)
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	err = walk.Walk(archivePath, method, nested, func(entry walk.Entry, _ io.Reader) error {
+		if !long {
+			_, err := fmt.Fprintln(out, entry.Path)
+			return err
+		}
+
+		size := entry.Size
+		if entry.Typeflag != tar.TypeReg {
+			size = 0
+		}
+		line := fmt.Sprintf("%s %12d %s %s", entry.FileInfo().Mode(), size, entry.ModTime.Format("2006-01-02 15:04"), entry.Path)
+		if entry.Linkname != "" {
+			line += " -> " + entry.Linkname
+		}
+		_, err := fmt.Fprintln(out, line)
+		return err
+	})
+	if flushErr := out.Flush(); err == nil {
+		err = flushErr
+	}
+	if err != nil {
+		handleErr(fmt.Errorf("%s: %w", archivePath, err))
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(listcmd)
+
+	listcmd.Flags().StringP("method", "m", "", "decompression method: vlc")
+	listcmd.Flags().BoolP("long", "l", false, "show mode, size and modification time")
+	listcmd.Flags().StringArrayP("identity", "i", nil, "age identity file used to decrypt the archive (repeatable)")
+	listcmd.Flags().String("passphrase-file", "", "decrypt the archive with the passphrase stored in a file")
+	addNestedFlags(listcmd)
+}
@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"archivist/lib/compression"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+var ErrQuietVerbose = errors.New("--quiet and --verbose cannot be combined")
+
+// loggerFlag builds the logger handed to encoders and decoders from -q, -v
+// and --log-format. By default only warnings are shown; -v adds one record
+// per entry and -q keeps errors only.
+func loggerFlag(cmd *cobra.Command) (*slog.Logger, error) {
+	quiet, verbose, err := verbosityFlags(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	level := slog.LevelWarn
+	switch {
+	case quiet:
+		level = slog.LevelError
+	case verbose:
+		level = slog.LevelInfo
+	}
+
+	format, err := cmd.Flags().GetString("log-format")
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case "text":
+		return slog.New(&listHandler{level: level, out: os.Stdout, warn: os.Stderr, mu: &sync.Mutex{}}), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level})), nil
+	}
+
+	return nil, fmt.Errorf("unknown log format %q: use text or json", format)
+}
+
+func verbosityFlags(cmd *cobra.Command) (quiet bool, verbose bool, err error) {
+	if quiet, err = cmd.Flags().GetBool("quiet"); err != nil {
+		return false, false, err
+	}
+	if verbose, err = cmd.Flags().GetBool("verbose"); err != nil {
+		return false, false, err
+	}
+	if quiet && verbose {
+		return false, false, ErrQuietVerbose
+	}
+
+	return quiet, verbose, nil
+}
+
+// listHandler prints the added and extracted entries as bare member names on
+// stdout, like tar -v, and every other record as "message: entry" on stderr.
+type listHandler struct {
+	level slog.Level
+	out   io.Writer
+	warn  io.Writer
+	mu    *sync.Mutex
+}
+
+func (h *listHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *listHandler) Handle(_ context.Context, record slog.Record) error {
+	entry := ""
+	record.Attrs(func(attr slog.Attr) bool {
+		if attr.Key == compression.LogEntryKey {
+			entry = attr.Value.String()
+			return false
+		}
+		return true
+	})
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if record.Message == compression.LogAdding || record.Message == compression.LogExtracting {
+		_, err := fmt.Fprintln(h.out, entry)
+		return err
+	}
+
+	line := record.Message
+	if entry != "" {
+		line += ": " + entry
+	}
+	_, err := fmt.Fprintln(h.warn, line)
+	return err
+}
+
+func (h *listHandler) WithAttrs([]slog.Attr) slog.Handler {
+	return h
+}
+
+func (h *listHandler) WithGroup(string) slog.Handler {
+	return h
+}
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"archivist/lib/compression"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var addcmd = &cobra.Command{
+	Use:   "add",
+	Short: "Add files to an existing archive",
+	Run:   modify,
+}
+
+var updatecmd = &cobra.Command{
+	Use:   "update",
+	Short: "Add files that are newer than their copies in an existing archive",
+	Run:   modify,
+}
+
+var deletecmd = &cobra.Command{
+	Use:   "delete",
+	Short: "Remove members from an existing archive",
+	Run:   modify,
+}
+
+var ErrNothingToModify = errors.New("no files or members given")
+
+// modify runs add, update and delete: archivist <command> ARCHIVE PATH...
+func modify(cmd *cobra.Command, args []string) {
+	if len(args) == 0 || args[0] == "" {
+		handleErr(ErrEmptyArchivePath)
+	}
+	if len(args) == 1 {
+		handleErr(ErrNothingToModify)
+	}
+
+	archivePath := args[0]
+
+	// Split archives are reported as such, even when named by the unsplit
+	// name that doesn't exist on disk.
+	if err := compression.CheckModifiable(archivePath); err != nil {
+		handleErr(fmt.Errorf("failed to modify %s: %w", archivePath, err))
+	}
+
+	if _, err := os.Stat(archivePath); os.IsNotExist(err) {
+		handleErr(fmt.Errorf("archive %s does not exist: %w", archivePath, err))
+	}
+
+	var edit compression.Edit
+	if cmd.Name() == "delete" {
+		edit.Delete = args[1:]
+	} else {
+		sources, err := compression.CollectSources(args[1:])
+		if err != nil {
+			handleErr(err)
+		}
+		edit.Sources = sources
+		edit.OnlyNewer = cmd.Name() == "update"
+	}
+
+	modifier, err := openArchive(cmd, archivePath, nil)
+	if err != nil {
+		handleErr(err)
+	}
+
+	if err := modifier.Modify(edit); err != nil {
+		handleErr(fmt.Errorf("failed to modify %s: %w", archivePath, err))
+	}
+}
+
+func init() {
+	for _, cmd := range []*cobra.Command{addcmd, updatecmd, deletecmd} {
+		rootCmd.AddCommand(cmd)
+
+		cmd.Flags().StringP("method", "m", "", "compression method: vlc")
+		cmd.Flags().IntP("threads", "T", 0, "compression threads when tar.gz or tar.xz is rewritten (0 uses all cores)")
+	}
+}
@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"archivist/lib/compression/walk"
+	"github.com/spf13/cobra"
+)
+
+// addNestedFlags adds --recursive and the limits on nested archives.
+func addNestedFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("recursive", false, "descend into zip and tar archives found inside the archive")
+	cmd.Flags().Int("max-depth", walk.DefaultMaxDepth, "how deep archives may be nested with --recursive")
+	cmd.Flags().Int64("max-nested-size", walk.DefaultMaxSize>>20, "MiB that nested archives may expand to with --recursive")
+	cmd.Flags().Int("max-nested-entries", walk.DefaultMaxEntries, "entries that nested archives may hold with --recursive")
+}
+
+// nestedFlags reads the flags added by addNestedFlags.
+func nestedFlags(cmd *cobra.Command) (walk.Options, error) {
+	var opts walk.Options
+	var err error
+
+	if opts.Recursive, err = cmd.Flags().GetBool("recursive"); err != nil {
+		return opts, err
+	}
+	if opts.MaxDepth, err = cmd.Flags().GetInt("max-depth"); err != nil {
+		return opts, err
+	}
+	maxSize, err := cmd.Flags().GetInt64("max-nested-size")
+	if err != nil {
+		return opts, err
+	}
+	opts.MaxSize = maxSize << 20
+	if opts.MaxEntries, err = cmd.Flags().GetInt("max-nested-entries"); err != nil {
+		return opts, err
+	}
+
+	opts.Identities, err = identitiesFlag(cmd)
+	return opts, err
+}
+
+// nestedDecoder extracts an archive with walk.Extract, unpacking the
+// archives nested in it as well.
+type nestedDecoder struct {
+	archivePath string
+	method      string
+	opts        walk.ExtractOptions
+}
+
+func (d *nestedDecoder) Decode(outputDir string) error {
+	return walk.Extract(d.archivePath, d.method, outputDir, d.opts)
+}
+
+// openNested builds the nestedDecoder for unpack --recursive.
+func openNested(cmd *cobra.Command, archivePath string, nested walk.Options) (*nestedDecoder, error) {
+	method, err := archiveMethod(cmd, archivePath, nested.Identities)
+	if err != nil {
+		return nil, err
+	}
+
+	logger, err := loggerFlag(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	extractor, err := extractorFlags(cmd, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := walk.ExtractOptions{Options: nested, Extractor: extractor, VerifyManifest: optionalBool(cmd, "verify-manifest"), Logger: logger}
+	return &nestedDecoder{archivePath: archivePath, method: method, opts: opts}, nil
+}
@@ -2,14 +2,20 @@ package cmd
 
 import (
 	"archivist/lib/compression"
+	"archivist/lib/compression/parallel"
 	tar2 "archivist/lib/compression/tar"
 	"archivist/lib/compression/tar_bz2"
 	"archivist/lib/compression/tar_gz"
 	"archivist/lib/compression/tar_xz"
 	"archivist/lib/compression/zip"
+	"archivist/lib/encryption"
 	"errors"
+	"filippo.io/age"
+	"fmt"
 	"github.com/spf13/cobra"
+	"math"
 	"path/filepath"
+	"strconv"
 	"strings"
 )
 
@@ -21,41 +27,158 @@ var packcmd = &cobra.Command{
 
 var ErrEmptyPath = errors.New("path to file is not specified")
 
-func pack(cmd *cobra.Command, args []string) {
-	filePath := args[0]
+var ErrZipEncryption = errors.New("encryption is only supported for tar formats")
 
+func pack(cmd *cobra.Command, args []string) {
 	if len(args) == 0 || args[0] == "" {
 		handleErr(ErrEmptyPath)
 	}
 
+	filePath := args[0]
+
 	var encode compression.Encoder
 
 	method := cmd.Flag("method").Value.String()
 
+	recipients, err := packRecipients(cmd)
+	if err != nil {
+		handleErr(err)
+	}
+
+	manifest, err := manifestFlag(cmd)
+	if err != nil {
+		handleErr(err)
+	}
+
+	reproducible, err := cmd.Flags().GetBool("reproducible")
+	if err != nil {
+		handleErr(err)
+	}
+
+	threads, err := cmd.Flags().GetInt("threads")
+	if err != nil {
+		handleErr(err)
+	}
+	threads = parallel.Threads(threads)
+
+	memoryBudget, err := cmd.Flags().GetInt64("memory-budget")
+	if err != nil {
+		handleErr(err)
+	}
+
+	splitFlag, err := cmd.Flags().GetString("split-size")
+	if err != nil {
+		handleErr(err)
+	}
+	splitSize, err := parseSize(splitFlag)
+	if err != nil {
+		handleErr(err)
+	}
+
+	meter, err := progressFlag(cmd)
+	if err != nil {
+		handleErr(err)
+	}
+	progress := meter.callback()
+
+	logger, err := loggerFlag(cmd)
+	if err != nil {
+		handleErr(err)
+	}
+
 	packedName := packedFileName(filePath, method)
+	if len(recipients) > 0 {
+		packedName += ".age"
+	}
 
 	switch method {
 	case "zip":
-		encode = zip.New(packedName)
+		if len(recipients) > 0 {
+			handleErr(ErrZipEncryption)
+		}
+		encode = &zip.EncodeDecoder{OutputPath: packedName, Manifest: manifest, Reproducible: reproducible, SplitSize: splitSize, Threads: threads, MemoryBudget: memoryBudget << 20, Progress: progress, Logger: logger}
 	case "tar":
-		encode = tar2.New(packedName)
+		encode = &tar2.EncodeDecoder{OutputPath: packedName, Recipients: recipients, Manifest: manifest, Reproducible: reproducible, SplitSize: splitSize, Progress: progress, Logger: logger}
 	case "tar.gz":
-		encode = tar_gz.New(packedName)
+		encode = &tar_gz.EncodeDecoder{OutputPath: packedName, Recipients: recipients, Manifest: manifest, Reproducible: reproducible, SplitSize: splitSize, Threads: threads, Progress: progress, Logger: logger}
 	case "tar.xz":
-		encode = tar_xz.New(packedName)
+		encode = &tar_xz.EncodeDecoder{OutputPath: packedName, Recipients: recipients, Manifest: manifest, Reproducible: reproducible, SplitSize: splitSize, Threads: threads, Progress: progress, Logger: logger}
 	case "tar.bz":
-		encode = tar_bz2.New(packedName)
+		encode = &tar_bz2.EncodeDecoder{OutputPath: packedName, Recipients: recipients, Manifest: manifest, Reproducible: reproducible, SplitSize: splitSize, Progress: progress, Logger: logger}
 
 	default:
-		cmd.PrintErr("unknown method")
+		handleErr(fmt.Errorf("unknown method: %s", method))
 	}
 
-	err := encode.Encode([]string{filePath})
+	err = encode.Encode([]string{filePath})
+	meter.finish(err)
 	if err != nil {
 		handleErr(err)
 	}
 }
 
+// manifestFlag reports whether --manifest asks for per-file digests.
+func manifestFlag(cmd *cobra.Command) (bool, error) {
+	algorithm, err := cmd.Flags().GetString("manifest")
+	if err != nil || algorithm == "" {
+		return false, err
+	}
+
+	if algorithm != compression.ManifestSHA256 {
+		return false, fmt.Errorf("unsupported manifest digest: %s", algorithm)
+	}
+
+	return true, nil
+}
+
+func packRecipients(cmd *cobra.Command) ([]age.Recipient, error) {
+	keys, err := cmd.Flags().GetStringArray("recipient")
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := cmd.Flags().GetStringArray("recipients-file")
+	if err != nil {
+		return nil, err
+	}
+
+	passphrase, err := passphraseFlag(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	return encryption.ParseRecipients(keys, files, passphrase)
+}
+
+func passphraseFlag(cmd *cobra.Command) (string, error) {
+	path, err := cmd.Flags().GetString("passphrase-file")
+	if err != nil || path == "" {
+		return "", err
+	}
+
+	return encryption.ReadPassphrase(path)
+}
+
+// parseSize parses a byte count such as 700M or 2G. K, M, G and T are
+// binary units. An empty string is zero.
+func parseSize(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	number, shift := s, 0
+	if i := strings.Index("KMGT", strings.ToUpper(s[len(s)-1:])); i >= 0 {
+		number, shift = s[:len(s)-1], 10*(i+1)
+	}
+
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64>>shift {
+		return 0, fmt.Errorf("invalid size: %s", s)
+	}
+
+	return n << shift, nil
+}
+
 func packedFileName(path string, packedExtension string) string {
 	fileName := filepath.Base(path)
 
@@ -69,4 +192,14 @@ func init() {
 	if err := packcmd.MarkFlagRequired("method"); err != nil {
 		panic(err)
 	}
+
+	packcmd.Flags().StringArrayP("recipient", "r", nil, "encrypt the archive to an age X25519 public key (repeatable)")
+	packcmd.Flags().StringArrayP("recipients-file", "R", nil, "encrypt the archive to the age recipients listed in a file (repeatable)")
+	packcmd.Flags().String("passphrase-file", "", "encrypt the archive with the passphrase stored in a file")
+	packcmd.Flags().Bool("reproducible", false, "produce byte-identical archives for identical inputs (honours SOURCE_DATE_EPOCH)")
+	packcmd.Flags().IntP("threads", "T", 0, "compression threads for tar.gz, tar.xz and zip (0 uses all cores)")
+	packcmd.Flags().Int64("memory-budget", zip.DefaultMemoryBudget>>20, "MiB of memory for zip entries compressed ahead of writing; entries over a quarter of it spill to temp files")
+	packcmd.Flags().String("progress", progressAuto, "progress output: auto, bar, json or none")
+	packcmd.Flags().String("manifest", "", "record a digest of every file in the archive: sha256")
+	packcmd.Flags().String("split-size", "", "split the archive into volumes of at most this size, e.g. 700M or 2G (zip spans .z01 segments)")
 }
@@ -0,0 +1,191 @@
+package cmd
+
+import (
+	"archivist/lib/compression"
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	progressAuto = "auto"
+	progressBar  = "bar"
+	progressJSON = "json"
+	progressNone = "none"
+)
+
+const (
+	barWidth     = 30
+	barEntryRoom = 24
+)
+
+// progressMeter renders compression.Progress updates as a terminal bar or
+// as line-delimited JSON, at most once per interval.
+type progressMeter struct {
+	mode     string
+	out      io.Writer
+	interval time.Duration
+	start    time.Time
+	shown    time.Time
+	latest   compression.Progress
+}
+
+// progressEvent is one line of --progress=json output.
+type progressEvent struct {
+	State          string  `json:"state"`
+	Entry          string  `json:"entry,omitempty"`
+	Done           int64   `json:"done"`
+	Total          int64   `json:"total"`
+	Percent        float64 `json:"percent"`
+	BytesPerSecond float64 `json:"bytes_per_second"`
+	ETASeconds     float64 `json:"eta_seconds"`
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+}
+
+// progressFlag builds the meter selected by --progress, or nil when progress
+// is off. auto shows the bar only when stderr is a terminal and neither -q
+// nor -v is set, so it never interleaves with the entry list.
+func progressFlag(cmd *cobra.Command) (*progressMeter, error) {
+	mode, err := cmd.Flags().GetString("progress")
+	if err != nil {
+		return nil, err
+	}
+
+	quiet, verbose, err := verbosityFlags(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	meter := &progressMeter{mode: mode, out: os.Stderr, start: time.Now()}
+
+	switch mode {
+	case progressAuto:
+		if quiet || verbose || !isTerminal(os.Stderr) {
+			return nil, nil
+		}
+		meter.mode = progressBar
+		meter.interval = 100 * time.Millisecond
+	case progressBar:
+		meter.interval = 100 * time.Millisecond
+	case progressJSON:
+		meter.interval = time.Second
+	case progressNone:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown progress mode %q: use auto, bar, json or none", mode)
+	}
+
+	return meter, nil
+}
+
+// callback returns the hook handed to the encoder or decoder.
+func (m *progressMeter) callback() compression.ProgressFunc {
+	if m == nil {
+		return nil
+	}
+	return m.report
+}
+
+func (m *progressMeter) report(progress compression.Progress) {
+	m.latest = progress
+
+	now := time.Now()
+	if now.Sub(m.shown) < m.interval {
+		return
+	}
+	m.shown = now
+
+	m.render("running")
+}
+
+// finish draws the final state. A successful run is shown as complete, since
+// decoders may stop reading before the archive's trailing padding.
+func (m *progressMeter) finish(err error) {
+	if m == nil {
+		return
+	}
+
+	state := "failed"
+	if err == nil {
+		state = "finished"
+		if m.latest.Total > 0 {
+			m.latest.Done = m.latest.Total
+		}
+	}
+
+	m.render(state)
+	if m.mode == progressBar {
+		fmt.Fprintln(m.out)
+	}
+}
+
+func (m *progressMeter) render(state string) {
+	progress := m.latest
+	elapsed := time.Since(m.start).Seconds()
+
+	var rate, eta, percent float64
+	if elapsed > 0 {
+		rate = float64(progress.Done) / elapsed
+	}
+	if progress.Total > 0 {
+		percent = 100 * float64(progress.Done) / float64(progress.Total)
+		if rate > 0 {
+			eta = float64(progress.Total-progress.Done) / rate
+		}
+	}
+
+	if m.mode == progressJSON {
+		line, _ := json.Marshal(progressEvent{
+			State:          state,
+			Entry:          progress.Entry,
+			Done:           progress.Done,
+			Total:          progress.Total,
+			Percent:        percent,
+			BytesPerSecond: rate,
+			ETASeconds:     eta,
+			ElapsedSeconds: elapsed,
+		})
+		fmt.Fprintf(m.out, "%s\n", line)
+		return
+	}
+
+	filled := int(percent / 100 * barWidth)
+	filled = min(max(filled, 0), barWidth)
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", barWidth-filled)
+
+	fmt.Fprintf(m.out, "\r\033[K[%s] %3.0f%% %9s/s ETA %s %s",
+		bar, percent, formatBytes(rate), formatDuration(eta), shortenEntry(progress.Entry))
+}
+
+func formatBytes(n float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	i := 0
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f %s", n, units[i])
+}
+
+func formatDuration(seconds float64) string {
+	d := time.Duration(seconds) * time.Second
+	return fmt.Sprintf("%d:%02d:%02d", int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60)
+}
+
+// shortenEntry keeps the tail of long member names so the bar fits on one
+// line of a standard terminal.
+func shortenEntry(entry string) string {
+	if len(entry) <= barEntryRoom {
+		return entry
+	}
+	return "..." + entry[len(entry)-barEntryRoom+3:]
+}
+
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -20,3 +20,9 @@ func handleErr(err error) {
 	_, _ = fmt.Fprintln(os.Stderr, err)
 	os.Exit(1)
 }
+
+func init() {
+	rootCmd.PersistentFlags().BoolP("quiet", "q", false, "only report errors")
+	rootCmd.PersistentFlags().BoolP("verbose", "v", false, "list every entry as it is packed or unpacked")
+	rootCmd.PersistentFlags().String("log-format", "text", "log output: text or json")
+}
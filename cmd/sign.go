@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"archivist/lib/signature"
+	"github.com/spf13/cobra"
+)
+
+var signcmd = &cobra.Command{
+	Use:   "sign",
+	Short: "Create a detached minisign signature for an archive",
+	Run:   sign,
+}
+
+func sign(cmd *cobra.Command, args []string) {
+	if len(args) == 0 || args[0] == "" {
+		handleErr(ErrEmptyArchivePath)
+	}
+
+	archivePath := args[0]
+
+	keyPath, err := cmd.Flags().GetString("key")
+	if err != nil {
+		handleErr(err)
+	}
+
+	passphrase, err := passphraseFlag(cmd)
+	if err != nil {
+		handleErr(err)
+	}
+
+	sigPath, err := cmd.Flags().GetString("signature")
+	if err != nil {
+		handleErr(err)
+	}
+	if sigPath == "" {
+		sigPath = signature.Path(archivePath)
+	}
+
+	if err := signature.Sign(archivePath, sigPath, keyPath, passphrase); err != nil {
+		handleErr(err)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(signcmd)
+
+	signcmd.Flags().StringP("key", "k", "", "minisign secret key file")
+	if err := signcmd.MarkFlagRequired("key"); err != nil {
+		panic(err)
+	}
+
+	signcmd.Flags().String("passphrase-file", "", "file holding the passphrase of the secret key")
+	signcmd.Flags().StringP("signature", "x", "", "signature file (default <archive>.minisig)")
+}
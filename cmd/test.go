@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"archivist/lib/compression/walk"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var testcmd = &cobra.Command{
+	Use:   "test",
+	Short: "Check archive integrity without extracting it",
+	Run:   test,
+}
+
+func test(cmd *cobra.Command, args []string) {
+	if len(args) == 0 || args[0] == "" {
+		handleErr(ErrEmptyArchivePath)
+	}
+
+	archivePath := args[0]
+
+	if _, err := os.Stat(archivePath); os.IsNotExist(err) {
+		handleErr(fmt.Errorf("archive %s does not exist: %w", archivePath, err))
+	}
+
+	tester, err := openArchive(cmd, archivePath, nil)
+	if err != nil {
+		handleErr(err)
+	}
+
+	if err := tester.Test(); err != nil {
+		handleErr(fmt.Errorf("%s: %w", archivePath, err))
+	}
+
+	nested, err := nestedFlags(cmd)
+	if err != nil {
+		handleErr(err)
+	}
+	if nested.Recursive {
+		method, err := archiveMethod(cmd, archivePath, nested.Identities)
+		if err != nil {
+			handleErr(err)
+		}
+		if err := walk.Test(archivePath, method, nested); err != nil {
+			handleErr(fmt.Errorf("%s: %w", archivePath, err))
+		}
+	}
+
+	if quiet, _, _ := verbosityFlags(cmd); !quiet {
+		fmt.Printf("%s: OK\n", archivePath)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(testcmd)
+
+	testcmd.Flags().StringP("method", "m", "", "decompression method: vlc")
+	testcmd.Flags().StringArrayP("identity", "i", nil, "age identity file used to decrypt the archive (repeatable)")
+	testcmd.Flags().String("passphrase-file", "", "decrypt the archive with the passphrase stored in a file")
+	addNestedFlags(testcmd)
+}
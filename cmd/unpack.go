@@ -2,15 +2,23 @@ package cmd
 
 import (
 	"archivist/lib/compression"
+	"archivist/lib/compression/parallel"
 	"archivist/lib/compression/tar"
 	"archivist/lib/compression/tar_bz2"
 	"archivist/lib/compression/tar_gz"
 	"archivist/lib/compression/tar_xz"
 	"archivist/lib/compression/zip"
+	"archivist/lib/encryption"
+	"bufio"
+	"errors"
+	"filippo.io/age"
 	"fmt"
 	"github.com/spf13/cobra"
+	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 )
 
@@ -20,71 +28,328 @@ var unpackcmd = &cobra.Command{
 	Run:   unpack,
 }
 
+var ErrEmptyArchivePath = errors.New("archive path is not specified")
+
+var ErrKeepOldOverwrite = errors.New("--keep-old-files cannot be combined with --overwrite")
+
+var ErrStagingOverwrite = errors.New("--staging replaces the whole output directory and cannot be combined with --overwrite, --keep-old-files or --backup")
+
+var ErrResumeStaging = errors.New("--resume cannot be combined with --staging or --recursive")
+
 func unpack(cmd *cobra.Command, args []string) {
-	archivePath := args[0]
-	if archivePath == "" {
-		fmt.Errorf("archive path is not specified")
+	if len(args) == 0 || args[0] == "" {
+		handleErr(ErrEmptyArchivePath)
 	}
 
+	archivePath := args[0]
+
 	if _, err := os.Stat(archivePath); os.IsNotExist(err) {
-		fmt.Errorf("archive %s does not exist: %w", archivePath, err)
+		handleErr(fmt.Errorf("archive %s does not exist: %w", archivePath, err))
+	}
+
+	requireSignature, err := cmd.Flags().GetBool("require-signature")
+	if err != nil {
+		handleErr(err)
 	}
 
+	plainPath := strings.TrimSuffix(compression.TrimVolume(archivePath), ".age")
+
 	var outputDir string
 	if outputDir == "" {
-		base := strings.TrimSuffix(filepath.Base(archivePath), filepath.Ext(archivePath))
+		base := strings.TrimSuffix(filepath.Base(plainPath), filepath.Ext(plainPath))
 		base = strings.TrimSuffix(base, ".tar")
 		outputDir = filepath.Join(filepath.Dir(archivePath), base)
 	}
 
 	if info, err := os.Stat(outputDir); err == nil && !info.IsDir() {
-		fmt.Errorf("output path %s is a file, not a directory", outputDir)
+		handleErr(fmt.Errorf("output path %s is a file, not a directory", outputDir))
 	}
 
-	method, err := cmd.Flags().GetString("method")
+	meter, err := progressFlag(cmd)
 	if err != nil {
-		fmt.Errorf("failed to get method flag: %w", err)
+		handleErr(err)
 	}
 
-	if method == "" {
-		switch {
-		case strings.HasSuffix(archivePath, ".zip"):
-			method = "zip"
-		case strings.HasSuffix(archivePath, ".tar"):
-			method = "tar"
-		case strings.HasSuffix(archivePath, ".tar.gz"):
-			method = "tar.gz"
-		case strings.HasSuffix(archivePath, ".tar.bz"):
-			method = "tar.bz"
-		case strings.HasSuffix(archivePath, ".tar.xz"):
-			method = "tar.xz"
-		default:
-			fmt.Errorf("cannot determine compression method from file ext ension: %s", archivePath)
+	// A signed archive is extracted from the copy that was verified, so it
+	// can't be swapped out between the check and the extraction.
+	extractPath := archivePath
+	if requireSignature {
+		if extractPath, err = verifiedCopy(cmd, archivePath, outputDir); err != nil {
+			handleErr(fmt.Errorf("refusing to extract %s: %w", archivePath, err))
 		}
 	}
 
 	var decode compression.Decoder
+	decode, err = openArchive(cmd, extractPath, meter.callback())
+	if err != nil {
+		handleErr(err)
+	}
+
+	nested, err := nestedFlags(cmd)
+	if err != nil {
+		handleErr(err)
+	}
+	if nested.Recursive {
+		if decode, err = openNested(cmd, extractPath, nested); err != nil {
+			handleErr(err)
+		}
+	}
+
+	staging, err := cmd.Flags().GetBool("staging")
+	if err != nil {
+		handleErr(err)
+	}
+	if optionalBool(cmd, "resume") && (staging || nested.Recursive) {
+		handleErr(ErrResumeStaging)
+	}
+
+	if staging {
+		err = compression.DecodeStaged(decode, outputDir)
+	} else {
+		err = decode.Decode(outputDir)
+	}
+	if requireSignature {
+		os.RemoveAll(filepath.Dir(extractPath))
+	}
+	meter.finish(err)
+	if err != nil {
+		handleErr(fmt.Errorf("failed to decode %s: %w", archivePath, err))
+	}
+}
+
+// archive is implemented by every format package.
+type archive interface {
+	compression.Decoder
+	compression.Tester
+	compression.Modifier
+	compression.Catter
+}
+
+// openArchive picks the format package for archivePath with archiveMethod.
+// progress may be nil.
+func openArchive(cmd *cobra.Command, archivePath string, progress compression.ProgressFunc) (archive, error) {
+	identities, err := identitiesFlag(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	verifyManifest := optionalBool(cmd, "verify-manifest")
+	resume := optionalBool(cmd, "resume")
+
+	logger, err := loggerFlag(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	extractor, err := extractorFlags(cmd, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	threads, err := threadsFlag(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	method, err := archiveMethod(cmd, archivePath, identities)
+	if err != nil {
+		return nil, err
+	}
+
 	switch method {
 	case "zip":
-		decode = zip.New(archivePath)
+		return &zip.EncodeDecoder{OutputPath: archivePath, VerifyManifest: verifyManifest, Threads: threads, Progress: progress, Logger: logger, Resume: resume, Extractor: extractor}, nil
 	case "tar":
-		decode = tar.New(archivePath)
+		return &tar.EncodeDecoder{OutputPath: archivePath, Identities: identities, VerifyManifest: verifyManifest, Progress: progress, Logger: logger, Resume: resume, Extractor: extractor}, nil
 	case "tar.gz":
-		decode = tar_gz.New(archivePath)
-	case "tar.bz2":
-		decode = tar_bz2.New(archivePath)
+		return &tar_gz.EncodeDecoder{OutputPath: archivePath, Identities: identities, Threads: threads, VerifyManifest: verifyManifest, Progress: progress, Logger: logger, Resume: resume, Extractor: extractor}, nil
+	case "tar.bz2", "tar.bz":
+		return &tar_bz2.EncodeDecoder{OutputPath: archivePath, Identities: identities, VerifyManifest: verifyManifest, Progress: progress, Logger: logger, Resume: resume, Extractor: extractor}, nil
 	case "tar.xz":
-		decode = tar_xz.New(archivePath)
-	case "tar.bz":
-		decode = tar_bz2.New(archivePath)
-	default:
-		fmt.Errorf("unknown compression method: %s", method)
+		return &tar_xz.EncodeDecoder{OutputPath: archivePath, Identities: identities, Threads: threads, VerifyManifest: verifyManifest, Progress: progress, Logger: logger, Resume: resume, Extractor: extractor}, nil
+	}
+
+	return nil, fmt.Errorf("unknown compression method: %s", method)
+}
+
+// archiveMethod returns the --method flag or, when it is empty or missing,
+// the method guessed from the extension or the magic bytes of archivePath.
+func archiveMethod(cmd *cobra.Command, archivePath string, identities []age.Identity) (string, error) {
+	var method string
+	var err error
+	if cmd.Flags().Lookup("method") != nil {
+		if method, err = cmd.Flags().GetString("method"); err != nil {
+			return "", fmt.Errorf("failed to get method flag: %w", err)
+		}
+	}
+
+	if method == "" {
+		method = methodFromName(strings.TrimSuffix(compression.TrimVolume(archivePath), ".age"))
+	}
+
+	if method == "" {
+		method, err = detectMethod(archivePath, identities)
+		if err != nil {
+			return "", fmt.Errorf("cannot determine compression method of %s: %w", archivePath, err)
+		}
+	}
+
+	return method, nil
+}
+
+// zipSegment matches the segments of a spanned zip archive, as in x.z01.
+var zipSegment = regexp.MustCompile(`\.z\d{2,}$`)
+
+// methodFromName guesses the compression method from the archive extension.
+func methodFromName(archivePath string) string {
+	switch {
+	case strings.HasSuffix(archivePath, ".zip"), zipSegment.MatchString(archivePath):
+		return "zip"
+	case strings.HasSuffix(archivePath, ".tar"):
+		return "tar"
+	case strings.HasSuffix(archivePath, ".tar.gz"):
+		return "tar.gz"
+	case strings.HasSuffix(archivePath, ".tar.bz"):
+		return "tar.bz"
+	case strings.HasSuffix(archivePath, ".tar.bz2"):
+		return "tar.bz2"
+	case strings.HasSuffix(archivePath, ".tar.xz"):
+		return "tar.xz"
+	}
+
+	return ""
+}
+
+// detectMethod sniffs the compression method from the archive contents,
+// looking through the age envelope of encrypted archives.
+func detectMethod(archivePath string, identities []age.Identity) (string, error) {
+	format, err := compression.DetectFileFormat(archivePath)
+	if err != nil || format != compression.FormatAge {
+		return format, err
 	}
 
-	err = decode.Decode(outputDir)
+	file, err := compression.OpenArchive(archivePath)
 	if err != nil {
-		fmt.Errorf("failed to decode %s: %w", archivePath, err)
+		return "", err
 	}
+	defer file.Close()
+
+	src, err := encryption.Decrypt(file, identities)
+	if err != nil {
+		return "", err
+	}
+
+	return compression.DetectFormat(src)
+}
+
+// optionalBool reads a boolean flag that not every command defines.
+func optionalBool(cmd *cobra.Command, name string) bool {
+	value, err := cmd.Flags().GetBool(name)
+	return err == nil && value
+}
+
+// threadsFlag reads --threads where a command defines it, mapping 0 to all
+// cores. Commands without the flag get 0, which keeps decoders serial.
+func threadsFlag(cmd *cobra.Command) (int, error) {
+	if cmd.Flags().Lookup("threads") == nil {
+		return 0, nil
+	}
+
+	threads, err := cmd.Flags().GetInt("threads")
+	if err != nil {
+		return 0, err
+	}
+
+	return parallel.Threads(threads), nil
+}
+
+// extractorFlags builds the conflict policy from --overwrite, --keep-old-files
+// and --backup. Commands that don't extract get nil.
+func extractorFlags(cmd *cobra.Command, logger *slog.Logger) (*compression.Extractor, error) {
+	if cmd.Flags().Lookup("overwrite") == nil {
+		return nil, nil
+	}
+
+	if optionalBool(cmd, "staging") {
+		for _, name := range []string{"overwrite", "keep-old-files", "backup"} {
+			if cmd.Flags().Changed(name) {
+				return nil, ErrStagingOverwrite
+			}
+		}
+	}
+
+	name, err := cmd.Flags().GetString("overwrite")
+	if err != nil {
+		return nil, err
+	}
+	overwrite, err := compression.ParseOverwritePolicy(name)
+	if err != nil {
+		return nil, err
+	}
+
+	keepOld, err := cmd.Flags().GetBool("keep-old-files")
+	if err != nil {
+		return nil, err
+	}
+	if keepOld {
+		if cmd.Flags().Changed("overwrite") {
+			return nil, ErrKeepOldOverwrite
+		}
+		overwrite = compression.OverwriteKeepOld
+	}
+
+	control, err := cmd.Flags().GetString("backup")
+	if err != nil {
+		return nil, err
+	}
+	backup, err := compression.ParseBackupPolicy(control)
+	if err != nil {
+		return nil, err
+	}
+
+	return &compression.Extractor{
+		Overwrite: overwrite,
+		Backup:    backup,
+		Ask:       askOverwrite(bufio.NewReader(os.Stdin)),
+		Logger:    logger,
+	}, nil
+}
+
+// askOverwrite prompts on stderr and reads the answer from in. Anything but
+// y or yes, including end of input, keeps the existing file.
+func askOverwrite(in *bufio.Reader) func(path string) (bool, error) {
+	return func(path string) (bool, error) {
+		fmt.Fprintf(os.Stderr, "overwrite %s? [y/N] ", path)
+
+		answer, err := in.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return false, err
+		}
+
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "y", "yes":
+			return true, nil
+		}
+		return false, nil
+	}
+}
+
+func identitiesFlag(cmd *cobra.Command) ([]age.Identity, error) {
+	if cmd.Flags().Lookup("identity") == nil {
+		return nil, nil
+	}
+
+	files, err := cmd.Flags().GetStringArray("identity")
+	if err != nil {
+		return nil, err
+	}
+
+	passphrase, err := passphraseFlag(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	return encryption.ParseIdentities(files, passphrase)
 }
 
 func init() {
@@ -94,4 +359,20 @@ func init() {
 	if err := packcmd.MarkFlagRequired("method"); err != nil {
 		panic(err)
 	}
+
+	unpackcmd.Flags().StringArrayP("identity", "i", nil, "age identity file used to decrypt the archive (repeatable)")
+	unpackcmd.Flags().String("passphrase-file", "", "decrypt the archive with the passphrase stored in a file")
+	unpackcmd.Flags().IntP("threads", "T", 1, "extract zip entries concurrently on that many workers (0 uses all cores)")
+	unpackcmd.Flags().String("progress", progressAuto, "progress output: auto, bar, json or none")
+	unpackcmd.Flags().String("overwrite", string(compression.OverwriteAlways), "existing files: always, never, newer or ask")
+	unpackcmd.Flags().BoolP("keep-old-files", "k", false, "fail instead of replacing existing files")
+	unpackcmd.Flags().String("backup", "", "rename replaced files to name.~N~ (numbered)")
+	unpackcmd.Flags().Lookup("backup").NoOptDefVal = string(compression.BackupNumbered)
+	unpackcmd.Flags().Bool("staging", false, "extract into a staging directory and swap it in for the output directory on success")
+	unpackcmd.Flags().Bool("resume", false, "journal extracted files in the output directory and skip them when rerun after an interruption")
+	unpackcmd.Flags().Bool("verify-manifest", false, "check every extracted file against the digest recorded by pack --manifest")
+	unpackcmd.Flags().Bool("require-signature", false, "refuse to extract archives without a valid detached signature")
+	unpackcmd.Flags().StringP("pubkey", "p", "", "minisign public key file or base64 key used with --require-signature")
+	unpackcmd.Flags().StringP("signature", "x", "", "signature file (default <archive>.minisig)")
+	addNestedFlags(unpackcmd)
 }
@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"aead.dev/minisign"
+	"archivist/lib/compression"
+	"archivist/lib/compression/zip"
+	"archivist/lib/signature"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"path/filepath"
+)
+
+var verifycmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Verify the detached signature of an archive",
+	Run:   verify,
+}
+
+var ErrUnsigned = errors.New("archive is not signed")
+
+var ErrSplitSignature = errors.New("a signature covers a single file, so split and spanned archives cannot be verified")
+
+func verify(cmd *cobra.Command, args []string) {
+	if len(args) == 0 || args[0] == "" {
+		handleErr(ErrEmptyArchivePath)
+	}
+
+	if err := verifySignature(cmd, args[0]); err != nil {
+		handleErr(err)
+	}
+
+	if quiet, _, _ := verbosityFlags(cmd); !quiet {
+		fmt.Println("Signature and comment signature verified")
+	}
+}
+
+// verifySignature checks archivePath against the --pubkey and --signature flags.
+func verifySignature(cmd *cobra.Command, archivePath string) error {
+	publicKey, sigPath, err := signatureFlags(cmd, archivePath)
+	if err != nil {
+		return err
+	}
+
+	return signature.Verify(archivePath, sigPath, publicKey)
+}
+
+// verifiedCopy checks archivePath like verifySignature while copying it
+// into a private directory next to outputDir, and returns the path of the
+// copy. Extracting the copy reads the very bytes that were verified,
+// whatever happens to archivePath in the meantime. The copy goes to the
+// file system being extracted to, which has room for the archive's
+// contents, rather than to a temporary directory that may be too small.
+// The caller removes the directory.
+func verifiedCopy(cmd *cobra.Command, archivePath, outputDir string) (string, error) {
+	if compression.IsSplit(archivePath) || zip.IsSplit(archivePath) {
+		return "", ErrSplitSignature
+	}
+
+	publicKey, sigPath, err := signatureFlags(cmd, archivePath)
+	if err != nil {
+		return "", err
+	}
+
+	parent := filepath.Dir(filepath.Clean(outputDir))
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return "", fmt.Errorf("failed to create directory %s: %w", parent, err)
+	}
+	dir, err := os.MkdirTemp(parent, ".archivist-verified-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+
+	// The copy keeps the name, size and mtime of the archive, which is how
+	// --resume recognizes it.
+	copyPath := filepath.Join(dir, filepath.Base(archivePath))
+	if err := copyVerified(copyPath, archivePath, sigPath, publicKey); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+
+	return copyPath, nil
+}
+
+func copyVerified(copyPath, archivePath, sigPath string, publicKey minisign.PublicKey) error {
+	info, err := os.Stat(archivePath)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(copyPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", copyPath, err)
+	}
+	defer file.Close()
+
+	if err := signature.VerifyCopy(file, archivePath, sigPath, publicKey); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", copyPath, err)
+	}
+
+	return os.Chtimes(copyPath, info.ModTime(), info.ModTime())
+}
+
+// signatureFlags reads the public key and the signature path for
+// archivePath from the --pubkey and --signature flags.
+func signatureFlags(cmd *cobra.Command, archivePath string) (minisign.PublicKey, string, error) {
+	var publicKey minisign.PublicKey
+
+	pubkey, err := cmd.Flags().GetString("pubkey")
+	if err != nil {
+		return publicKey, "", err
+	}
+	if pubkey == "" {
+		return publicKey, "", fmt.Errorf("public key is not specified")
+	}
+
+	publicKey, err = signature.ParsePublicKey(pubkey)
+	if err != nil {
+		return publicKey, "", err
+	}
+
+	sigPath, err := cmd.Flags().GetString("signature")
+	if err != nil {
+		return publicKey, "", err
+	}
+	if sigPath == "" {
+		sigPath = signature.Path(archivePath)
+	}
+
+	if _, err := os.Stat(sigPath); os.IsNotExist(err) {
+		return publicKey, "", fmt.Errorf("%w: %s not found", ErrUnsigned, sigPath)
+	}
+
+	return publicKey, sigPath, nil
+}
+
+func init() {
+	rootCmd.AddCommand(verifycmd)
+
+	verifycmd.Flags().StringP("pubkey", "p", "", "minisign public key file or base64 key")
+	if err := verifycmd.MarkFlagRequired("pubkey"); err != nil {
+		panic(err)
+	}
+
+	verifycmd.Flags().StringP("signature", "x", "", "signature file (default <archive>.minisig)")
+}
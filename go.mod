@@ -3,9 +3,16 @@ module archivist
 go 1.24
 
 require (
-	github.com/dsnet/compress v0.0.1 // indirect
+	aead.dev/minisign v0.2.0
+	filippo.io/age v1.2.1
+	github.com/dsnet/compress v0.0.1
+	github.com/spf13/cobra v1.9.1
+	github.com/ulikunitz/xz v0.5.12
+	golang.org/x/sys v0.21.0
+)
+
+require (
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
-	github.com/spf13/cobra v1.9.1 // indirect
 	github.com/spf13/pflag v1.0.6 // indirect
-	github.com/ulikunitz/xz v0.5.12 // indirect
+	golang.org/x/crypto v0.24.0 // indirect
 )
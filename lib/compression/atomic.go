@@ -0,0 +1,157 @@
+package compression
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// AtomicFile is written under a temporary name next to its destination and
+// only appears at the destination once committed, so an interrupted write
+// never leaves a truncated file behind.
+type AtomicFile struct {
+	*os.File
+	// Durable makes Commit fsync the file and its directory, so that after a
+	// crash the destination holds either the old file or the complete new one.
+	Durable bool
+
+	path string
+	perm fs.FileMode
+	done bool
+	// beforeRename runs once the data is written, just before the file is
+	// moved into place.
+	beforeRename func() error
+}
+
+// CreateAtomic starts writing a file that Commit moves to path with perm.
+func CreateAtomic(path string, perm fs.FileMode) (*AtomicFile, error) {
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file for %s: %w", path, err)
+	}
+
+	return &AtomicFile{File: file, path: path, perm: perm}, nil
+}
+
+// removeTemps removes the temporary files of CreateAtomic(path) left by a
+// process killed before it could commit or discard them.
+func removeTemps(path string) error {
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return fmt.Errorf("failed to list %s: %w", filepath.Dir(path), err)
+	}
+
+	prefix, suffix := "."+filepath.Base(path)+".", ".tmp"
+	for _, entry := range entries {
+		name := entry.Name()
+		if len(name) <= len(prefix)+len(suffix) || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+			continue
+		}
+		if _, err := strconv.ParseUint(name[len(prefix):len(name)-len(suffix)], 10, 64); err != nil {
+			continue
+		}
+		if err := os.Remove(filepath.Join(filepath.Dir(path), name)); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// CreateArchive starts writing an archive at path. It is durable and
+// readable by everyone, like a file made by os.Create under a 022 umask.
+func CreateArchive(path string) (*AtomicFile, error) {
+	file, err := CreateAtomic(path, 0644)
+	if err != nil {
+		return nil, err
+	}
+	file.Durable = true
+
+	return file, nil
+}
+
+// Commit closes the file and renames it to its destination.
+func (f *AtomicFile) Commit() error {
+	if f.done {
+		return fmt.Errorf("file %s is already closed", f.path)
+	}
+	f.done = true
+
+	err := f.commit()
+	if err != nil {
+		os.Remove(f.File.Name())
+	}
+
+	return err
+}
+
+func (f *AtomicFile) commit() error {
+	if f.Durable {
+		if err := f.File.Sync(); err != nil {
+			f.File.Close()
+			return fmt.Errorf("failed to sync %s: %w", f.path, err)
+		}
+	}
+
+	if err := f.File.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %w", f.path, err)
+	}
+
+	if err := os.Chmod(f.File.Name(), f.perm); err != nil {
+		return fmt.Errorf("failed to set permissions of %s: %w", f.path, err)
+	}
+
+	if f.beforeRename != nil {
+		if err := f.beforeRename(); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(f.File.Name(), f.path); err != nil {
+		return fmt.Errorf("failed to move %s into place: %w", f.path, err)
+	}
+
+	if f.Durable {
+		return syncDir(filepath.Dir(f.path))
+	}
+
+	return nil
+}
+
+// Close discards the file unless it was committed. It is safe to defer
+// alongside Commit.
+func (f *AtomicFile) Close() error {
+	if f.done {
+		return nil
+	}
+	f.done = true
+
+	err := f.File.Close()
+	os.Remove(f.File.Name())
+
+	return err
+}
+
+// syncDir flushes a directory entry, making a rename inside it durable.
+// Windows can't sync directory handles and commits renames on its own.
+func syncDir(path string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	if err := dir.Sync(); err != nil {
+		return fmt.Errorf("failed to sync directory %s: %w", path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package compression
+
+import (
+	"archive/tar"
+	"archivist/lib/encryption"
+	"errors"
+	"filippo.io/age"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+var ErrNotRegular = errors.New("not a regular file")
+
+// MemberName normalizes an archive member name for lookups, so that
+// "./dir/file" and "dir/file" name the same member.
+func MemberName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// CountTarMember returns how many entries of the tar stream are named member.
+// Archives appended to by AppendTar may hold several copies of a member.
+func CountTarMember(tarReader *tar.Reader, member string) (int, error) {
+	member = MemberName(member)
+	count := 0
+
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to read tar header: %w", err)
+		}
+
+		if MemberName(header.Name) == member {
+			count++
+		}
+	}
+}
+
+// CatTar writes the data of the tar member named member to w, skipping the
+// first skip copies of it. The data is checked against its digest record,
+// if any, once written.
+func CatTar(tarReader *tar.Reader, member string, skip int, w io.Writer) error {
+	name := MemberName(member)
+
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return fmt.Errorf("%w: %s", ErrMemberNotFound, member)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar header: %w", err)
+		}
+
+		if MemberName(header.Name) != name {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			return fmt.Errorf("%w: %s", ErrNotRegular, member)
+		}
+
+		digest := NewDigest()
+		if _, err := io.Copy(io.MultiWriter(w, digest), tarReader); err != nil {
+			return fmt.Errorf("failed to read %s: %w", member, err)
+		}
+
+		if expected, ok := header.PAXRecords[PAXDigestKey]; ok {
+			if err := CheckDigest(digest, expected); err != nil {
+				return &EntryError{Name: header.Name, Err: err}
+			}
+		}
+
+		return nil
+	}
+}
+
+// CatTarFile writes member of the tar archive at path to w, decrypting the
+// archive with identities and decompressing it with decompress. The scan
+// stops at the first copy of member.
+func CatTarFile(path, member string, identities []age.Identity, decompress func(io.Reader) (io.Reader, error), w io.Writer) error {
+	file, err := OpenArchive(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	src, err := encryption.Decrypt(file, identities)
+	if err != nil {
+		return err
+	}
+
+	src, err = decompress(src)
+	if err != nil {
+		return err
+	}
+
+	return CatTar(tar.NewReader(src), member, 0, w)
+}
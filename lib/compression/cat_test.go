@@ -0,0 +1,52 @@
+package compression_test
+
+import (
+	"archivist/lib/compression"
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestCat(t *testing.T) {
+	for _, format := range editFormats {
+		t.Run(format.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTree(t, filepath.Join(dir, "v1"), map[string]string{"src/a": "first a", "src/sub/b": "b"})
+			archive := filepath.Join(dir, "src."+format.name)
+			if err := format.encode(archive, []string{filepath.Join(dir, "v1", "src")}); err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+
+			cat := func(member string) (string, error) {
+				var buf bytes.Buffer
+				err := format.cat(archive, member, &buf)
+				return buf.String(), err
+			}
+
+			for _, member := range []string{"src/a", "./src/a", "/src/a"} {
+				if got, err := cat(member); err != nil || got != "first a" {
+					t.Errorf("Cat(%q) = %q, %v; want %q", member, got, err, "first a")
+				}
+			}
+			if _, err := cat("src/missing"); !errors.Is(err, compression.ErrMemberNotFound) {
+				t.Errorf("Cat of a missing member = %v, want %v", err, compression.ErrMemberNotFound)
+			}
+			// Zip archives don't store directories the encoder walks through.
+			if _, err := cat("src/sub"); !errors.Is(err, compression.ErrNotRegular) && !errors.Is(err, compression.ErrMemberNotFound) {
+				t.Errorf("Cat of a directory = %v, want %v", err, compression.ErrNotRegular)
+			}
+
+			// An updated member reads back as its latest version, also where
+			// the old copy stays in the archive.
+			writeTree(t, filepath.Join(dir, "v2"), map[string]string{"src/a": "second a"})
+			edit := compression.Edit{Sources: collect(t, filepath.Join(dir, "v2", "src"))[1:]}
+			if err := format.modify(archive, edit); err != nil {
+				t.Fatalf("Modify: %v", err)
+			}
+			if got, err := cat("src/a"); err != nil || got != "second a" {
+				t.Errorf("Cat after an update = %q, %v; want %q", got, err, "second a")
+			}
+		})
+	}
+}
@@ -1,9 +1,84 @@
 package compression
 
+import (
+	"io"
+	"io/fs"
+	"time"
+)
+
 type Encoder interface {
 	Encode(sourcePaths []string) error
 }
 
+// FSEncoder packs the trees under roots of an fs.FS, naming members as
+// Encode does for OS paths. The root "." packs the whole file system.
+type FSEncoder interface {
+	EncodeFS(fsys fs.FS, roots []string) error
+}
+
+// ArchiveWriter builds an archive one entry at a time. Names use forward
+// slashes and are stored as given. The archive only appears at its path
+// once Close succeeds.
+type ArchiveWriter interface {
+	AddFile(name string, mode fs.FileMode, mtime time.Time, r io.Reader) error
+	AddDir(name string, mode fs.FileMode, mtime time.Time) error
+	AddSymlink(name, target string, mtime time.Time) error
+	Close() error
+}
+
+// Creator starts a new archive written through an ArchiveWriter.
+type Creator interface {
+	Create() (ArchiveWriter, error)
+}
+
+// ArchiveReader reads an archive one entry at a time, like tar.Reader:
+// Next moves to the next entry, returning io.EOF after the last one, and
+// Read reads the body of the current entry.
+type ArchiveReader interface {
+	Next() (*Entry, error)
+	io.Reader
+	io.Closer
+}
+
+// Opener opens an archive for reading through an ArchiveReader.
+type Opener interface {
+	Open() (ArchiveReader, error)
+}
+
 type Decoder interface {
 	Decode(outputDir string) error
 }
+
+// Tester verifies an archive without extracting it.
+type Tester interface {
+	Test() error
+}
+
+// Modifier changes the members of an existing archive in place.
+type Modifier interface {
+	Modify(edit Edit) error
+}
+
+// TarCodec is implemented by the tar-based formats and exposes their
+// compression layer, so tar streams can be read and written generically.
+type TarCodec interface {
+	Decompress(r io.Reader) (io.Reader, error)
+	Compress(w io.Writer) (io.WriteCloser, error)
+}
+
+// Catter writes the contents of a single archive member to w.
+type Catter interface {
+	Cat(member string, w io.Writer) error
+}
+
+// ArchiveFS is a read-only file system over the members of an archive.
+type ArchiveFS interface {
+	fs.ReadDirFS
+	fs.StatFS
+	io.Closer
+}
+
+// FSOpener opens an archive as an ArchiveFS.
+type FSOpener interface {
+	OpenFS() (ArchiveFS, error)
+}
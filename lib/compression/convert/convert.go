@@ -0,0 +1,338 @@
+// Package convert streams the entries of an archive into an archive of
+// another format without extracting them to disk. Entries travel as
+// tar headers, the richest model the supported formats share; whatever the
+// target format can't represent is dropped with a warning.
+package convert
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"archivist/lib/compression"
+	tar2 "archivist/lib/compression/tar"
+	"archivist/lib/compression/tar_bz2"
+	"archivist/lib/compression/tar_gz"
+	"archivist/lib/compression/tar_xz"
+	zip2 "archivist/lib/compression/zip"
+	"archivist/lib/encryption"
+	"bytes"
+	"errors"
+	"filippo.io/age"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+var ErrZipEncryption = errors.New("encryption is only supported for tar formats")
+
+// Warnings logged once per conversion, with the first entry affected.
+const (
+	LogOwnershipDropped = "ownership is not stored in zip archives; dropped"
+	LogTypeDropped      = "hard links, devices and FIFOs are not stored in zip archives; skipped"
+	LogRecordsDropped   = "extended PAX records are not stored in zip archives; dropped"
+)
+
+// Options configures a conversion.
+type Options struct {
+	// Identities decrypt an age-encrypted input.
+	Identities []age.Identity
+	// Recipients encrypt a tar output with age.
+	Recipients []age.Recipient
+	// Threads compresses tar.gz and tar.xz output in parallel.
+	Threads int
+	// Logger receives a record per entry and the lossy conversion warnings.
+	Logger *slog.Logger
+}
+
+// entryWriter stores converted entries in the output archive.
+type entryWriter interface {
+	write(header *tar.Header, body io.Reader) error
+	close() error
+}
+
+// Convert streams the archive at inPath, in inFormat, into a new archive at
+// outPath, in outFormat. Formats are the compression.Format* names. The
+// output only appears once the conversion has succeeded.
+func Convert(inPath, inFormat, outPath, outFormat string, opts Options) error {
+	logger := compression.Logger(opts.Logger)
+
+	if outFormat == compression.FormatZip && len(opts.Recipients) > 0 {
+		return ErrZipEncryption
+	}
+
+	out, err := compression.CreateArchive(outPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	var writer entryWriter
+	if outFormat == compression.FormatZip {
+		writer = newZipWriter(out, logger)
+	} else {
+		codec, err := tarCodec(outFormat, opts.Threads)
+		if err != nil {
+			return err
+		}
+		if writer, err = newTarWriter(out, codec, opts.Recipients); err != nil {
+			return err
+		}
+	}
+
+	write := func(header *tar.Header, body io.Reader) error {
+		logger.Info(compression.LogAdding, compression.LogEntryKey, header.Name)
+		if compression.IsSparse(header) {
+			logger.Warn(compression.LogSparse, compression.LogEntryKey, header.Name)
+		}
+		return writer.write(header, body)
+	}
+
+	if err := Read(inPath, inFormat, opts.Identities, write); err != nil {
+		return err
+	}
+
+	if err := writer.close(); err != nil {
+		return err
+	}
+
+	return out.Commit()
+}
+
+// Read passes every entry of the archive at path, in format, to fn as a tar
+// header and a reader for its data, decrypting tar formats with identities.
+func Read(path, format string, identities []age.Identity, fn func(*tar.Header, io.Reader) error) error {
+	if format == compression.FormatZip {
+		return readZip(path, fn)
+	}
+
+	file, err := compression.OpenArchive(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return readTar(file, format, identities, fn)
+}
+
+func tarCodec(format string, threads int) (compression.TarCodec, error) {
+	switch format {
+	case compression.FormatTar:
+		return &tar2.EncodeDecoder{}, nil
+	case compression.FormatTarGz:
+		return &tar_gz.EncodeDecoder{Threads: threads}, nil
+	case compression.FormatTarXz:
+		return &tar_xz.EncodeDecoder{Threads: threads}, nil
+	case compression.FormatTarBz, "tar.bz2":
+		return &tar_bz2.EncodeDecoder{}, nil
+	}
+
+	return nil, fmt.Errorf("unknown archive format: %s", format)
+}
+
+// ReadStream is Read for an archive that isn't a file, such as a member of
+// another archive. Zip archives need random access and are spooled to a
+// temporary file first.
+func ReadStream(r io.Reader, format string, identities []age.Identity, fn func(*tar.Header, io.Reader) error) error {
+	if format != compression.FormatZip {
+		return readTar(r, format, identities, fn)
+	}
+
+	spool, err := os.CreateTemp("", "archivist-zip-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	size, err := io.Copy(spool, r)
+	if err != nil {
+		return fmt.Errorf("failed to read zip archive: %w", err)
+	}
+
+	reader, err := zip.NewReader(spool, size)
+	if err != nil {
+		return fmt.Errorf("failed to open zip archive: %w", err)
+	}
+
+	return zip2.ReadEntries(reader, fn)
+}
+
+// readTar passes every entry of a tar stream to fn.
+func readTar(r io.Reader, format string, identities []age.Identity, fn func(*tar.Header, io.Reader) error) error {
+	codec, err := tarCodec(format, 0)
+	if err != nil {
+		return err
+	}
+
+	src, err := encryption.Decrypt(r, identities)
+	if err != nil {
+		return err
+	}
+
+	src, err = codec.Decompress(src)
+	if err != nil {
+		return err
+	}
+
+	tarReader := tar.NewReader(src)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar header: %w", err)
+		}
+
+		if err := fn(header, tarReader); err != nil {
+			return err
+		}
+	}
+}
+
+// readZip passes every entry of a zip archive to fn. Symlinks, stored as
+// their target, become link headers, and MANIFEST.sha256 turns back into
+// per-entry digest records.
+func readZip(path string, fn func(*tar.Header, io.Reader) error) error {
+	reader, err := zip2.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	return zip2.ReadEntries(reader.Reader, fn)
+}
+
+type tarWriter struct {
+	tw         *tar.Writer
+	compressor io.WriteCloser
+	encryptor  io.WriteCloser
+}
+
+func newTarWriter(out io.Writer, codec compression.TarCodec, recipients []age.Recipient) (*tarWriter, error) {
+	encryptor, err := encryption.Encrypt(out, recipients)
+	if err != nil {
+		return nil, err
+	}
+
+	compressor, err := codec.Compress(encryptor)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tarWriter{tw: tar.NewWriter(compressor), compressor: compressor, encryptor: encryptor}, nil
+}
+
+func (w *tarWriter) write(header *tar.Header, body io.Reader) error {
+	if err := w.tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write tar header for %s: %w", header.Name, err)
+	}
+	if _, err := io.Copy(w.tw, body); err != nil {
+		return fmt.Errorf("failed to write %s to tar: %w", header.Name, err)
+	}
+	return nil
+}
+
+func (w *tarWriter) close() error {
+	if err := w.tw.Close(); err != nil {
+		return fmt.Errorf("failed to finish tar stream: %w", err)
+	}
+	if err := w.compressor.Close(); err != nil {
+		return fmt.Errorf("failed to finish compressed stream: %w", err)
+	}
+	if err := w.encryptor.Close(); err != nil {
+		return fmt.Errorf("failed to finish encryption: %w", err)
+	}
+	return nil
+}
+
+type zipWriter struct {
+	archive  *zip.Writer
+	logger   *slog.Logger
+	warned   map[string]bool
+	manifest []compression.ManifestEntry
+}
+
+func newZipWriter(out io.Writer, logger *slog.Logger) *zipWriter {
+	return &zipWriter{archive: zip.NewWriter(out), logger: logger, warned: make(map[string]bool)}
+}
+
+func (w *zipWriter) write(header *tar.Header, body io.Reader) error {
+	switch header.Typeflag {
+	case tar.TypeReg, tar.TypeDir, tar.TypeSymlink:
+	default:
+		w.warn(LogTypeDropped, header.Name)
+		return nil
+	}
+
+	if header.Uid != 0 || header.Gid != 0 || header.Uname != "" || header.Gname != "" {
+		w.warn(LogOwnershipDropped, header.Name)
+	}
+
+	digest := ""
+	for key, value := range header.PAXRecords {
+		if key == compression.PAXDigestKey {
+			digest = value
+		} else if !strings.HasPrefix(key, "GNU.") {
+			w.warn(LogRecordsDropped, header.Name)
+		}
+	}
+
+	zipHeader, err := zip.FileInfoHeader(header.FileInfo())
+	if err != nil {
+		return fmt.Errorf("failed to create zip header for %s: %w", header.Name, err)
+	}
+	zipHeader.Name = header.Name
+	zipHeader.Modified = header.ModTime
+
+	switch header.Typeflag {
+	case tar.TypeDir:
+		zipHeader.Name = strings.TrimSuffix(zipHeader.Name, "/") + "/"
+		body = bytes.NewReader(nil)
+	case tar.TypeSymlink:
+		body = strings.NewReader(header.Linkname)
+	default:
+		zipHeader.Method = zip.Deflate
+	}
+
+	writer, err := w.archive.CreateHeader(zipHeader)
+	if err != nil {
+		return fmt.Errorf("failed to create zip entry for %s: %w", header.Name, err)
+	}
+	if _, err := io.Copy(writer, body); err != nil {
+		return fmt.Errorf("failed to write %s to zip: %w", header.Name, err)
+	}
+
+	if digest != "" {
+		w.manifest = append(w.manifest, compression.ManifestEntry{Name: zipHeader.Name, Digest: digest})
+	}
+
+	return nil
+}
+
+// close writes MANIFEST.sha256 when the input carried digests.
+func (w *zipWriter) close() error {
+	if len(w.manifest) > 0 {
+		writer, err := w.archive.Create(compression.ManifestName)
+		if err != nil {
+			return fmt.Errorf("failed to create zip entry for %s: %w", compression.ManifestName, err)
+		}
+		if err := compression.WriteManifest(writer, w.manifest); err != nil {
+			return fmt.Errorf("failed to write %s to zip: %w", compression.ManifestName, err)
+		}
+	}
+
+	if err := w.archive.Close(); err != nil {
+		return fmt.Errorf("failed to write zip central directory: %w", err)
+	}
+	return nil
+}
+
+func (w *zipWriter) warn(message, entry string) {
+	if w.warned[message] {
+		return
+	}
+	w.warned[message] = true
+	w.logger.Warn(message, compression.LogEntryKey, entry)
+}
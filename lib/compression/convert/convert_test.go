@@ -0,0 +1,103 @@
+package convert
+
+import (
+	"archive/tar"
+	"archivist/lib/compression"
+	"archivist/lib/compression/tar_gz"
+	"errors"
+	"filippo.io/age"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// entries reads the archive at path, in format, into its headers and the
+// contents of its files by name.
+func entries(t *testing.T, path, format string) (map[string]*tar.Header, map[string]string) {
+	t.Helper()
+
+	headers := map[string]*tar.Header{}
+	data := map[string]string{}
+	err := Read(path, format, nil, func(header *tar.Header, body io.Reader) error {
+		content, err := io.ReadAll(body)
+		headers[header.Name], data[header.Name] = header, string(content)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Read %s: %v", path, err)
+	}
+	return headers, data
+}
+
+// Converting a tar.gz to zip and back keeps contents, symlinks and digests.
+func TestConvertRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.MkdirAll(filepath.Join(src, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "sub", "a.txt"), []byte("alpha"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("sub/a.txt", filepath.Join(src, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	original := filepath.Join(dir, "src.tar.gz")
+	ed := tar_gz.New(original)
+	ed.Manifest = true
+	if err := ed.Encode([]string{src}); err != nil {
+		t.Fatal(err)
+	}
+
+	zipped := filepath.Join(dir, "src.zip")
+	if err := Convert(original, compression.FormatTarGz, zipped, compression.FormatZip, Options{}); err != nil {
+		t.Fatalf("Convert to zip: %v", err)
+	}
+	back := filepath.Join(dir, "back.tar")
+	if err := Convert(zipped, compression.FormatZip, back, compression.FormatTar, Options{}); err != nil {
+		t.Fatalf("Convert to tar: %v", err)
+	}
+
+	wantHeaders, wantData := entries(t, original, compression.FormatTarGz)
+	for _, path := range []string{zipped, back} {
+		format := compression.FormatTar
+		if path == zipped {
+			format = compression.FormatZip
+		}
+		headers, data := entries(t, path, format)
+
+		if _, ok := headers[compression.ManifestName]; ok {
+			t.Errorf("%s: %s read as an entry", path, compression.ManifestName)
+		}
+		for _, name := range []string{"src/sub/a.txt", "src/link"} {
+			header, want := headers[name], wantHeaders[name]
+			if header == nil {
+				t.Errorf("%s: %s missing", path, name)
+				continue
+			}
+			if header.Typeflag != want.Typeflag || header.Linkname != want.Linkname || data[name] != wantData[name] {
+				t.Errorf("%s: %s is type %c, link %q, data %q; want type %c, link %q, data %q", path, name,
+					header.Typeflag, header.Linkname, data[name], want.Typeflag, want.Linkname, wantData[name])
+			}
+			if digest := want.PAXRecords[compression.PAXDigestKey]; header.PAXRecords[compression.PAXDigestKey] != digest {
+				t.Errorf("%s: %s has digest %q, want %q", path, name, header.PAXRecords[compression.PAXDigestKey], digest)
+			}
+		}
+	}
+}
+
+func TestConvertZipEncryption(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out.zip")
+	opts := Options{Recipients: []age.Recipient{identity.Recipient()}}
+	if err := Convert(filepath.Join(dir, "in.tar"), compression.FormatTar, out, compression.FormatZip, opts); !errors.Is(err, ErrZipEncryption) {
+		t.Errorf("Convert to an encrypted zip = %v, want %v", err, ErrZipEncryption)
+	}
+}
@@ -0,0 +1,73 @@
+package compression
+
+import (
+	"archivist/lib/encryption"
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// Format names accepted by the pack and unpack commands.
+const (
+	FormatZip   = "zip"
+	FormatTar   = "tar"
+	FormatTarGz = "tar.gz"
+	FormatTarBz = "tar.bz"
+	FormatTarXz = "tar.xz"
+	// FormatAge marks an age-encrypted archive whose inner format is unknown
+	// until it is decrypted.
+	FormatAge = "age"
+)
+
+// SniffLen is the length of the prefix DetectFormat reads. It covers the
+// tar "ustar" magic, the deepest signature we look for.
+const SniffLen = 512
+
+var magics = []struct {
+	format string
+	offset int
+	magic  []byte
+}{
+	{FormatZip, 0, []byte("PK\x03\x04")},
+	{FormatZip, 0, []byte("PK\x05\x06")},
+	{FormatZip, 0, []byte("PK\x07\x08")},
+	{FormatZip, 0, []byte("PK00")},
+	{FormatTarGz, 0, []byte{0x1f, 0x8b}},
+	{FormatTarXz, 0, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+	{FormatTarBz, 0, []byte("BZh")},
+	{FormatTar, 257, []byte("ustar")},
+}
+
+// DetectFormat sniffs the archive format from the magic bytes at the start of r.
+func DetectFormat(r io.Reader) (string, error) {
+	prefix := make([]byte, SniffLen)
+	n, err := io.ReadFull(r, prefix)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", fmt.Errorf("failed to read archive header: %w", err)
+	}
+	prefix = prefix[:n]
+
+	if encryption.IsEncrypted(prefix) {
+		return FormatAge, nil
+	}
+
+	for _, m := range magics {
+		if len(prefix) >= m.offset+len(m.magic) && bytes.Equal(prefix[m.offset:m.offset+len(m.magic)], m.magic) {
+			return m.format, nil
+		}
+	}
+
+	return "", fmt.Errorf("unrecognized archive format")
+}
+
+// DetectFileFormat sniffs the archive format of the file at path, which
+// may be the first volume of a split archive.
+func DetectFileFormat(path string) (string, error) {
+	file, err := OpenArchive(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	return DetectFormat(file)
+}
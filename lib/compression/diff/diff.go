@@ -0,0 +1,248 @@
+// Package diff compares the entries of two archives, or of an archive and a
+// directory, by type, size, mode, modification time and content digest.
+package diff
+
+import (
+	"archive/tar"
+	"archivist/lib/compression"
+	"archivist/lib/compression/convert"
+	"encoding/hex"
+	"filippo.io/age"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Entry types.
+const (
+	TypeFile     = "file"
+	TypeDir      = "dir"
+	TypeSymlink  = "symlink"
+	TypeHardlink = "hardlink"
+	TypeOther    = "other"
+)
+
+// Entry is an archive member or a file, reduced to what diff compares.
+type Entry struct {
+	Type    string
+	Size    int64
+	Mode    fs.FileMode
+	ModTime time.Time
+	Digest  string
+	Link    string
+	// Implied marks a directory without an entry of its own that holds
+	// other entries, as in zip archives, which don't store directories.
+	// Its mode and time are unknown.
+	Implied bool
+}
+
+// Snapshot maps normalized member names to their entries.
+type Snapshot map[string]Entry
+
+// ReadArchive hashes every entry of the archive at path, in format.
+func ReadArchive(path, format string, identities []age.Identity) (Snapshot, error) {
+	snapshot := make(Snapshot)
+	if err := convert.Read(path, format, identities, snapshot.add); err != nil {
+		return nil, err
+	}
+	snapshot.imply()
+
+	return snapshot, nil
+}
+
+// ReadDir hashes every file under the directory at root. Names start with the
+// directory's base name, as pack stores them.
+func ReadDir(root string) (Snapshot, error) {
+	snapshot := make(Snapshot)
+
+	err := filepath.Walk(root, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return fmt.Errorf("error walking through %s: %w", filePath, err)
+		}
+
+		link := ""
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if link, err = os.Readlink(filePath); err != nil {
+				return fmt.Errorf("failed to read symlink %s: %w", filePath, err)
+			}
+		}
+
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return fmt.Errorf("failed to create header for %s: %w", filePath, err)
+		}
+
+		relPath, err := filepath.Rel(filepath.Dir(root), filePath)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path for %s: %w", filePath, err)
+		}
+		header.Name = filepath.ToSlash(relPath)
+
+		if header.Typeflag != tar.TypeReg {
+			return snapshot.add(header, nil)
+		}
+
+		file, err := os.Open(filePath)
+		if err != nil {
+			return fmt.Errorf("failed to open file %s: %w", filePath, err)
+		}
+		defer file.Close()
+
+		return snapshot.add(header, file)
+	})
+	if err != nil {
+		return nil, err
+	}
+	snapshot.imply()
+
+	return snapshot, nil
+}
+
+func (s Snapshot) add(header *tar.Header, body io.Reader) error {
+	name := compression.MemberName(header.Name)
+	if name == "" {
+		return nil
+	}
+
+	entry := Entry{
+		Mode:    header.FileInfo().Mode().Perm(),
+		ModTime: header.ModTime,
+	}
+
+	switch header.Typeflag {
+	case tar.TypeReg:
+		entry.Type = TypeFile
+		entry.Size = header.Size
+
+		digest := compression.NewDigest()
+		if _, err := io.Copy(digest, body); err != nil {
+			return fmt.Errorf("failed to read %s: %w", header.Name, err)
+		}
+		entry.Digest = hex.EncodeToString(digest.Sum(nil))
+	case tar.TypeDir:
+		entry.Type = TypeDir
+	case tar.TypeSymlink:
+		entry.Type = TypeSymlink
+		entry.Link = header.Linkname
+	case tar.TypeLink:
+		entry.Type = TypeHardlink
+		entry.Link = header.Linkname
+	default:
+		entry.Type = TypeOther
+	}
+
+	// A later copy of a member replaces the earlier one, as on extraction.
+	s[name] = entry
+
+	return nil
+}
+
+// imply adds the parent directories missing from the snapshot.
+func (s Snapshot) imply() {
+	for name := range s {
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			if _, ok := s[dir]; ok {
+				break
+			}
+			s[dir] = Entry{Type: TypeDir, Implied: true}
+		}
+	}
+}
+
+// Kind tells how an entry changed.
+type Kind string
+
+const (
+	Added    Kind = "added"
+	Removed  Kind = "removed"
+	Modified Kind = "modified"
+)
+
+// Change is an entry that differs between two snapshots.
+type Change struct {
+	Path   string  `json:"path"`
+	Kind   Kind    `json:"change"`
+	Fields []Field `json:"fields,omitempty"`
+}
+
+// Field is an attribute of a modified entry, with its old and new values.
+type Field struct {
+	Name string `json:"field"`
+	Old  string `json:"old"`
+	New  string `json:"new"`
+}
+
+// Options tunes Compare.
+type Options struct {
+	// IgnoreModTime skips modification times, which differ between builds.
+	IgnoreModTime bool
+}
+
+// Compare lists the entries that differ from old to new, sorted by path.
+func Compare(old, new Snapshot, opts Options) []Change {
+	names := make([]string, 0, len(old)+len(new))
+	for name := range old {
+		names = append(names, name)
+	}
+	for name := range new {
+		if _, ok := old[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	changes := []Change{}
+	for _, name := range names {
+		a, inOld := old[name]
+		b, inNew := new[name]
+
+		switch {
+		case !inOld:
+			changes = append(changes, Change{Path: name, Kind: Added})
+		case !inNew:
+			changes = append(changes, Change{Path: name, Kind: Removed})
+		default:
+			if fields := compareEntries(a, b, opts); len(fields) > 0 {
+				changes = append(changes, Change{Path: name, Kind: Modified, Fields: fields})
+			}
+		}
+	}
+
+	return changes
+}
+
+func compareEntries(a, b Entry, opts Options) []Field {
+	if a.Type != b.Type {
+		return []Field{{Name: "type", Old: a.Type, New: b.Type}}
+	}
+
+	var fields []Field
+	add := func(name, old, new string) {
+		if old != new {
+			fields = append(fields, Field{Name: name, Old: old, New: new})
+		}
+	}
+
+	if a.Type == TypeFile {
+		add("size", strconv.FormatInt(a.Size, 10), strconv.FormatInt(b.Size, 10))
+	}
+	if !a.Implied && !b.Implied {
+		add("mode", a.Mode.String(), b.Mode.String())
+	}
+	// Directory times change whenever their contents do, so they only add noise.
+	// Archives keep whole seconds, rounded by tar and truncated by zip, so
+	// times less than a second apart are the same.
+	if !opts.IgnoreModTime && a.Type != TypeDir && a.ModTime.Sub(b.ModTime).Abs() >= time.Second {
+		add("mtime", a.ModTime.UTC().Format(time.RFC3339), b.ModTime.UTC().Format(time.RFC3339))
+	}
+	add("content", a.Digest, b.Digest)
+	add("link", a.Link, b.Link)
+
+	return fields
+}
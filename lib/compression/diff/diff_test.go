@@ -0,0 +1,156 @@
+package diff
+
+import (
+	"archivist/lib/compression"
+	tarformat "archivist/lib/compression/tar"
+	zipformat "archivist/lib/compression/zip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// writeTree writes files, by slash-separated name, under dir/src with a
+// fixed modification time, which tar rounds up to the next second, and
+// returns dir/src.
+func writeTree(t *testing.T, dir string, files map[string]string) string {
+	t.Helper()
+
+	mtime := time.Date(2024, 5, 1, 10, 0, 0, 700_000_000, time.UTC)
+	for name, data := range files {
+		path := filepath.Join(dir, "src", filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return filepath.Join(dir, "src")
+}
+
+func TestCompare(t *testing.T) {
+	dir := t.TempDir()
+	src := writeTree(t, dir, map[string]string{
+		"a":     "alpha",
+		"b":     "bravo",
+		"d":     "delta",
+		"e":     "echo",
+		"sub/f": "foxtrot",
+	})
+	archive := filepath.Join(dir, "src.tar")
+	if err := tarformat.New(archive).Encode([]string{src}); err != nil {
+		t.Fatal(err)
+	}
+
+	old, err := ReadArchive(archive, compression.FormatTar, nil)
+	if err != nil {
+		t.Fatalf("ReadArchive: %v", err)
+	}
+	tree, err := ReadDir(src)
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	if changes := Compare(old, tree, Options{}); len(changes) != 0 {
+		t.Fatalf("archive and the tree it was packed from differ: %+v", changes)
+	}
+
+	// Same size, new content; one file gone, one new, one chmodded and one
+	// touched.
+	if err := os.WriteFile(filepath.Join(src, "a"), []byte("ALPHA"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(src, "b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "c"), []byte("charlie"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(src, "d"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	touched := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(src, "e"), touched, touched); err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err = ReadDir(src)
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+
+	changes := Compare(old, tree, Options{})
+	var paths []string
+	for _, change := range changes {
+		paths = append(paths, change.Path+" "+string(change.Kind))
+	}
+	want := []string{"src/a modified", "src/b removed", "src/c added", "src/d modified", "src/e modified"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Fatalf("Compare = %q, want %q", paths, want)
+	}
+
+	fields := map[string][]string{}
+	for _, change := range changes {
+		for _, field := range change.Fields {
+			fields[change.Path] = append(fields[change.Path], field.Name)
+		}
+	}
+	// Rewriting a also moved its modification time.
+	wantFields := map[string][]string{
+		"src/a": {"mtime", "content"},
+		"src/d": {"mode"},
+		"src/e": {"mtime"},
+	}
+	if !reflect.DeepEqual(fields, wantFields) {
+		t.Errorf("changed fields = %v, want %v", fields, wantFields)
+	}
+	if field := changes[3].Fields[0]; field.Old != "-rw-r--r--" || field.New != "-rw-------" {
+		t.Errorf("mode changed from %s to %s", field.Old, field.New)
+	}
+
+	for _, change := range Compare(old, tree, Options{IgnoreModTime: true}) {
+		if change.Path == "src/e" {
+			t.Errorf("IgnoreModTime still reports %+v", change)
+		}
+		for _, field := range change.Fields {
+			if field.Name == "mtime" {
+				t.Errorf("IgnoreModTime still reports the mtime of %s", change.Path)
+			}
+		}
+	}
+}
+
+// Zip archives don't store directories, so the directories of the tree match
+// the ones the archive implies, whatever their mode.
+func TestCompareImpliedDirs(t *testing.T) {
+	dir := t.TempDir()
+	src := writeTree(t, dir, map[string]string{"a": "alpha", "sub/deep/b": "bravo"})
+	if err := os.Chmod(filepath.Join(src, "sub"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	archive := filepath.Join(dir, "src.zip")
+	if err := zipformat.New(archive).Encode([]string{src}); err != nil {
+		t.Fatal(err)
+	}
+
+	packed, err := ReadArchive(archive, compression.FormatZip, nil)
+	if err != nil {
+		t.Fatalf("ReadArchive: %v", err)
+	}
+	if entry := packed["src/sub/deep"]; entry.Type != TypeDir {
+		t.Errorf("src/sub/deep = %+v, want an implied directory", entry)
+	}
+
+	tree, err := ReadDir(src)
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	if changes := Compare(packed, tree, Options{}); len(changes) != 0 {
+		t.Errorf("Compare = %+v, want no changes", changes)
+	}
+}
@@ -0,0 +1,123 @@
+package compression
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var ErrMemberNotFound = errors.New("not found in archive")
+
+var ErrEncryptedModify = errors.New("encrypted archives cannot be modified")
+
+// Edit describes a change to the members of an archive.
+type Edit struct {
+	// Sources are added to the archive, replacing members of the same name.
+	Sources []Source
+	// OnlyNewer skips sources that aren't newer than the member they would
+	// replace, like tar --update.
+	OnlyNewer bool
+	// Delete names members to remove; a directory takes its contents along.
+	Delete []string
+}
+
+// EditPlan applies an Edit while the existing members are scanned in order.
+type EditPlan struct {
+	edit    Edit
+	pending map[string]bool
+	deleted map[string]bool
+}
+
+// Plan starts applying edit.
+func (edit Edit) Plan() *EditPlan {
+	plan := &EditPlan{
+		edit:    edit,
+		pending: make(map[string]bool),
+		deleted: make(map[string]bool),
+	}
+	for _, source := range edit.Sources {
+		plan.pending[source.Name] = true
+	}
+	plan.edit.Delete = nil
+	for _, name := range edit.Delete {
+		plan.edit.Delete = append(plan.edit.Delete, strings.TrimSuffix(strings.TrimPrefix(name, "./"), "/"))
+	}
+
+	return plan
+}
+
+// Keep reports whether the existing member name, last modified at modTime,
+// stays in the archive.
+func (plan *EditPlan) Keep(name string, modTime time.Time) bool {
+	name = strings.TrimSuffix(name, "/")
+
+	for _, deleted := range plan.edit.Delete {
+		if name == deleted || strings.HasPrefix(name, deleted+"/") {
+			plan.deleted[deleted] = true
+			return false
+		}
+	}
+
+	if !plan.pending[name] {
+		return true
+	}
+
+	if plan.edit.OnlyNewer && !plan.source(name).Info.ModTime().Truncate(time.Second).After(modTime) {
+		plan.pending[name] = false
+		return true
+	}
+
+	return false
+}
+
+// Pending returns the sources still to be written, in walk order.
+func (plan *EditPlan) Pending() []Source {
+	var sources []Source
+	for _, source := range plan.edit.Sources {
+		if plan.pending[source.Name] {
+			sources = append(sources, source)
+		}
+	}
+	return sources
+}
+
+// Err reports the members asked to be deleted that weren't in the archive.
+func (plan *EditPlan) Err() error {
+	var missing []string
+	for _, name := range plan.edit.Delete {
+		if !plan.deleted[name] {
+			missing = append(missing, name)
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: %s", ErrMemberNotFound, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func (plan *EditPlan) source(name string) Source {
+	for _, source := range plan.edit.Sources {
+		if source.Name == name {
+			return source
+		}
+	}
+	return Source{}
+}
+
+// CheckModifiable rejects archives that can't be rewritten without keys,
+// and split archives, whose volumes can't be rewritten in place. A split
+// archive may be named by one of its volumes or by the unsplit name it was
+// packed under.
+func CheckModifiable(path string) error {
+	if IsSplit(path) || HasVolumes(path) {
+		return fmt.Errorf("%s: %w", path, ErrSplitModify)
+	}
+
+	format, err := DetectFileFormat(path)
+	if err == nil && format == FormatAge {
+		return fmt.Errorf("%s: %w", path, ErrEncryptedModify)
+	}
+	return nil
+}
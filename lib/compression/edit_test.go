@@ -0,0 +1,355 @@
+package compression_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"archivist/lib/compression"
+	tarformat "archivist/lib/compression/tar"
+	"archivist/lib/compression/tar_gz"
+	zipformat "archivist/lib/compression/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// editFormat packs, modifies, extracts and reads members of one archive
+// format with manifests on.
+type editFormat struct {
+	name   string
+	encode func(path string, sources []string) error
+	modify func(path string, edit compression.Edit) error
+	decode func(path, outputDir string) error
+	cat    func(path, member string, w io.Writer) error
+}
+
+var editFormats = []editFormat{
+	{
+		name: "tar",
+		encode: func(path string, sources []string) error {
+			ed := tarformat.New(path)
+			ed.Manifest = true
+			return ed.Encode(sources)
+		},
+		modify: func(path string, edit compression.Edit) error {
+			return tarformat.New(path).Modify(edit)
+		},
+		decode: func(path, outputDir string) error {
+			ed := tarformat.New(path)
+			ed.VerifyManifest = true
+			return ed.Decode(outputDir)
+		},
+		cat: func(path, member string, w io.Writer) error {
+			return tarformat.New(path).Cat(member, w)
+		},
+	},
+	{
+		name: "tar.gz",
+		encode: func(path string, sources []string) error {
+			ed := tar_gz.New(path)
+			ed.Manifest = true
+			return ed.Encode(sources)
+		},
+		modify: func(path string, edit compression.Edit) error {
+			return tar_gz.New(path).Modify(edit)
+		},
+		decode: func(path, outputDir string) error {
+			ed := tar_gz.New(path)
+			ed.VerifyManifest = true
+			return ed.Decode(outputDir)
+		},
+		cat: func(path, member string, w io.Writer) error {
+			return tar_gz.New(path).Cat(member, w)
+		},
+	},
+	{
+		name: "zip",
+		encode: func(path string, sources []string) error {
+			ed := zipformat.New(path)
+			ed.Manifest = true
+			return ed.Encode(sources)
+		},
+		modify: func(path string, edit compression.Edit) error {
+			return zipformat.New(path).Modify(edit)
+		},
+		decode: func(path, outputDir string) error {
+			ed := zipformat.New(path)
+			ed.VerifyManifest = true
+			return ed.Decode(outputDir)
+		},
+		cat: func(path, member string, w io.Writer) error {
+			return zipformat.New(path).Cat(member, w)
+		},
+	},
+}
+
+// checkTree reports files under dir that differ from files, and regular
+// files under dir/src that files doesn't list.
+func checkTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	for name, want := range files {
+		got, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if string(got) != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+
+	entries, err := os.ReadDir(filepath.Join(dir, "src"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if _, ok := files["src/"+entry.Name()]; !ok {
+			t.Errorf("src/%s extracted, want it gone", entry.Name())
+		}
+	}
+}
+
+func collect(t *testing.T, paths ...string) []compression.Source {
+	t.Helper()
+
+	sources, err := compression.CollectSources(paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sources
+}
+
+func TestModify(t *testing.T) {
+	for _, format := range editFormats {
+		t.Run(format.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTree(t, filepath.Join(dir, "v1"), map[string]string{
+				"src/a": "first a",
+				"src/b": "first b",
+			})
+			archive := filepath.Join(dir, "src."+format.name)
+			if err := format.encode(archive, []string{filepath.Join(dir, "v1", "src")}); err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+
+			// Add c and update a.
+			writeTree(t, filepath.Join(dir, "v2"), map[string]string{
+				"src/a": "second a",
+				"src/c": "first c",
+			})
+			edit := compression.Edit{Sources: collect(t, filepath.Join(dir, "v2", "src"))}
+			if err := format.modify(archive, edit); err != nil {
+				t.Fatalf("Modify adding files: %v", err)
+			}
+			want := map[string]string{"src/a": "second a", "src/b": "first b", "src/c": "first c"}
+			out := filepath.Join(dir, "out1")
+			if err := format.decode(archive, out); err != nil {
+				t.Fatalf("Decode after adding: %v", err)
+			}
+			checkTree(t, out, want)
+
+			if err := format.modify(archive, compression.Edit{Delete: []string{"src/b"}}); err != nil {
+				t.Fatalf("Modify deleting a file: %v", err)
+			}
+			delete(want, "src/b")
+			out = filepath.Join(dir, "out2")
+			if err := format.decode(archive, out); err != nil {
+				t.Fatalf("Decode after deleting: %v", err)
+			}
+			checkTree(t, out, want)
+
+			if format.name == "zip" {
+				checkZipManifest(t, archive, want)
+			}
+
+			err := format.modify(archive, compression.Edit{Delete: []string{"src/missing"}})
+			if !errors.Is(err, compression.ErrMemberNotFound) {
+				t.Errorf("Modify deleting a missing member = %v, want %v", err, compression.ErrMemberNotFound)
+			}
+		})
+	}
+}
+
+// checkZipManifest checks that MANIFEST.sha256 lists exactly files, with
+// their digests.
+func checkZipManifest(t *testing.T, archive string, files map[string]string) {
+	t.Helper()
+
+	reader, err := zip.OpenReader(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	r, err := reader.Open(compression.ManifestName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	digests, err := compression.ReadManifest(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(digests) != len(files) {
+		t.Errorf("%s lists %d files, want %d: %v", compression.ManifestName, len(digests), len(files), digests)
+	}
+	for name, data := range files {
+		sum := sha256.Sum256([]byte(data))
+		if digests[name] != hex.EncodeToString(sum[:]) {
+			t.Errorf("%s has digest %q for %s, want that of %q", compression.ManifestName, digests[name], name, data)
+		}
+	}
+}
+
+// An archive cut short without its end marker, whose last entry ends in
+// zeros, keeps that entry whole when files are appended.
+func TestAppendWithoutEndMarker(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "cut.tar")
+
+	data := append([]byte("data then zeros"), make([]byte, 4096)...)
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(&tar.Header{Name: "zeros", Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	// Flush pads the entry without writing the end marker Close would.
+	if err := tw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(archive, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	writeTree(t, filepath.Join(dir, "add"), map[string]string{"src/new": "appended"})
+	edit := compression.Edit{Sources: collect(t, filepath.Join(dir, "add", "src"))}
+	if err := tarformat.New(archive).Modify(edit); err != nil {
+		t.Fatalf("Modify: %v", err)
+	}
+
+	file, err := os.Open(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	got := map[string][]byte{}
+	tr := tar.NewReader(file)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("reading the appended archive: %v", err)
+		}
+		if got[header.Name], err = io.ReadAll(tr); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !bytes.Equal(got["zeros"], data) {
+		t.Errorf("zeros is %d bytes after appending, want %d", len(got["zeros"]), len(data))
+	}
+	if string(got["src/new"]) != "appended" {
+		t.Errorf("src/new = %q, want %q", got["src/new"], "appended")
+	}
+}
+
+// Appending to an archive with its end marker writes over the marker.
+func TestAppendOverEndMarker(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, filepath.Join(dir, "v1"), map[string]string{"src/a": "a"})
+	archive := filepath.Join(dir, "src.tar")
+	if err := tarformat.New(archive).Encode([]string{filepath.Join(dir, "v1", "src")}); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.Stat(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeTree(t, filepath.Join(dir, "v2"), map[string]string{"src/b": "b"})
+	edit := compression.Edit{Sources: []compression.Source{collect(t, filepath.Join(dir, "v2", "src"))[1]}}
+	if err := tarformat.New(archive).Modify(edit); err != nil {
+		t.Fatalf("Modify: %v", err)
+	}
+
+	// One header and one data block more, with the marker still at the end.
+	after, err := os.Stat(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Size() != before.Size()+1024 {
+		t.Errorf("archive grew from %d to %d bytes, want the old end marker overwritten", before.Size(), after.Size())
+	}
+}
+
+// A source removed after it was collected fails the append before the
+// archive is written to.
+func TestAppendMissingSource(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, filepath.Join(dir, "v1"), map[string]string{"src/a": "a"})
+	archive := filepath.Join(dir, "src.tar")
+	if err := tarformat.New(archive).Encode([]string{filepath.Join(dir, "v1", "src")}); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeTree(t, filepath.Join(dir, "v2"), map[string]string{"src/b": "b", "src/c": "c"})
+	edit := compression.Edit{Sources: collect(t, filepath.Join(dir, "v2", "src"))}
+	if err := os.Remove(filepath.Join(dir, "v2", "src", "c")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tarformat.New(archive).Modify(edit); err == nil {
+		t.Fatal("Modify with a missing source succeeded")
+	}
+
+	after, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(after, before) {
+		t.Error("archive changed by a failed append")
+	}
+}
+
+// A source that fails while it is written leaves the archive as it was,
+// ending with an end marker.
+func TestAppendFailureRestoresEndMarker(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, filepath.Join(dir, "v1"), map[string]string{"src/a": "a"})
+	archive := filepath.Join(dir, "src.tar")
+	if err := tarformat.New(archive).Encode([]string{filepath.Join(dir, "v1", "src")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// b goes in whole; c shrinks after it was collected, so its entry is
+	// cut short.
+	writeTree(t, filepath.Join(dir, "v2"), map[string]string{"src/b": "b", "src/c": "longer than it will be"})
+	edit := compression.Edit{Sources: collect(t, filepath.Join(dir, "v2", "src"))}
+	if err := os.WriteFile(filepath.Join(dir, "v2", "src", "c"), []byte("short"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := tarformat.New(archive).Modify(edit); err == nil {
+		t.Fatal("Modify with a shrunk source succeeded")
+	}
+
+	out := filepath.Join(dir, "out")
+	if err := tarformat.New(archive).Decode(out); err != nil {
+		t.Fatalf("Decode after a failed append: %v", err)
+	}
+	checkTree(t, out, map[string]string{"src/a": "a"})
+}
@@ -0,0 +1,98 @@
+package compression_test
+
+import (
+	"archivist/lib/compression"
+	tarformat "archivist/lib/compression/tar"
+	"archivist/lib/compression/tar_gz"
+	zipformat "archivist/lib/compression/zip"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// fsCodec packs file systems and opens archives as file systems.
+type fsCodec interface {
+	compression.FSEncoder
+	compression.FSOpener
+}
+
+// EncodeFS packs an fstest.MapFS with the modes, times and symlinks it
+// holds, naming members as Encode does.
+func TestEncodeFS(t *testing.T) {
+	formats := []struct {
+		name string
+		new  func(path string) fsCodec
+	}{
+		{"tar", func(path string) fsCodec { return tarformat.New(path) }},
+		{"tar.gz", func(path string) fsCodec { return tar_gz.New(path) }},
+		{"zip", func(path string) fsCodec { return zipformat.New(path) }},
+	}
+
+	mtime := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"static/app.js":       {Data: []byte("app"), Mode: 0644, ModTime: mtime},
+		"static/css/site.css": {Data: []byte("site"), Mode: 0600, ModTime: mtime},
+		"static/current":      {Data: []byte("app.js"), Mode: fs.ModeSymlink | 0777, ModTime: mtime},
+		"other/skipped.txt":   {Data: []byte("other"), Mode: 0644, ModTime: mtime},
+	}
+
+	for _, format := range formats {
+		t.Run(format.name, func(t *testing.T) {
+			archive := filepath.Join(t.TempDir(), "static."+format.name)
+			if err := format.new(archive).EncodeFS(fsys, []string{"static"}); err != nil {
+				t.Fatalf("EncodeFS: %v", err)
+			}
+
+			packed, err := format.new(archive).OpenFS()
+			if err != nil {
+				t.Fatalf("OpenFS: %v", err)
+			}
+			defer packed.Close()
+
+			for name, want := range map[string]string{"static/app.js": "app", "static/css/site.css": "site"} {
+				if got, err := fs.ReadFile(packed, name); err != nil || string(got) != want {
+					t.Errorf("%s = %q, %v; want %q", name, got, err, want)
+				}
+			}
+
+			info, err := packed.Stat("static/css/site.css")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != 0600 || !info.ModTime().Equal(mtime) {
+				t.Errorf("site.css has mode %v and time %v, want 0600 and %v", info.Mode(), info.ModTime(), mtime)
+			}
+
+			links, ok := packed.(interface {
+				ReadLink(name string) (string, error)
+			})
+			if !ok {
+				t.Fatalf("%T can't read symlinks", packed)
+			}
+			if target, err := links.ReadLink("static/current"); err != nil || target != "app.js" {
+				t.Errorf("ReadLink(static/current) = %q, %v; want app.js", target, err)
+			}
+
+			if _, err := packed.Stat("other/skipped.txt"); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("member outside the root packed: %v", err)
+			}
+		})
+	}
+
+	// The root "." packs the whole file system under its own names.
+	archive := filepath.Join(t.TempDir(), "all.tar")
+	if err := tarformat.New(archive).EncodeFS(fsys, []string{"."}); err != nil {
+		t.Fatalf("EncodeFS: %v", err)
+	}
+	packed, err := tarformat.New(archive).OpenFS()
+	if err != nil {
+		t.Fatalf("OpenFS: %v", err)
+	}
+	defer packed.Close()
+	if got, err := fs.ReadFile(packed, "other/skipped.txt"); err != nil || string(got) != "other" {
+		t.Errorf("other/skipped.txt = %q, %v", got, err)
+	}
+}
@@ -0,0 +1,235 @@
+package compression
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OverwritePolicy decides what happens when an extracted file already exists.
+type OverwritePolicy string
+
+const (
+	// OverwriteAlways replaces existing files. It is the default.
+	OverwriteAlways OverwritePolicy = "always"
+	// OverwriteNever keeps existing files and skips the entry.
+	OverwriteNever OverwritePolicy = "never"
+	// OverwriteNewer replaces existing files only with newer entries.
+	OverwriteNewer OverwritePolicy = "newer"
+	// OverwriteAsk leaves the decision to Extractor.Ask.
+	OverwriteAsk OverwritePolicy = "ask"
+	// OverwriteKeepOld refuses to replace existing files and fails the
+	// extraction, like tar --keep-old-files.
+	OverwriteKeepOld OverwritePolicy = "keep-old-files"
+)
+
+// BackupPolicy decides whether replaced files are kept aside.
+type BackupPolicy string
+
+const (
+	// BackupNone replaces files without a backup. It is the default.
+	BackupNone BackupPolicy = ""
+	// BackupNumbered renames replaced files to name.~N~, N being one more
+	// than the highest existing backup.
+	BackupNumbered BackupPolicy = "numbered"
+)
+
+var ErrFileExists = errors.New("file already exists")
+
+// Extractor creates the files written by decoders, applying one conflict
+// policy across every format. A nil Extractor overwrites existing files.
+type Extractor struct {
+	Overwrite OverwritePolicy
+	Backup    BackupPolicy
+	// Ask decides OverwriteAsk conflicts for the file at path. Calls are
+	// serialized, even when entries are extracted in parallel.
+	Ask func(path string) (bool, error)
+	// Logger receives a record for every file left in place.
+	Logger *slog.Logger
+
+	mu sync.Mutex
+}
+
+// ParseOverwritePolicy validates a policy name.
+func ParseOverwritePolicy(name string) (OverwritePolicy, error) {
+	switch policy := OverwritePolicy(name); policy {
+	case OverwriteAlways, OverwriteNever, OverwriteNewer, OverwriteAsk, OverwriteKeepOld:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown overwrite policy %q: use always, never, newer or ask", name)
+}
+
+// ParseBackupPolicy validates a backup control name.
+func ParseBackupPolicy(name string) (BackupPolicy, error) {
+	switch policy := BackupPolicy(name); policy {
+	case BackupNone, BackupNumbered:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown backup control %q: use numbered", name)
+}
+
+// Create starts writing an entry with the given mode and modification time
+// to targetPath, creating its parent directories. The data goes to a
+// temporary file that only replaces targetPath once committed, which is
+// also when the existing file is moved to its backup. Create returns a nil
+// file when the policy keeps the existing one, in which case the entry
+// should be skipped.
+func (x *Extractor) Create(targetPath string, mode fs.FileMode, modTime time.Time) (*AtomicFile, error) {
+	if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create parent directory for %s: %w", targetPath, err)
+	}
+
+	replace, err := x.replace(targetPath, modTime)
+	if err != nil {
+		return nil, err
+	}
+	if !replace {
+		Logger(x.Logger).Info(LogKept, LogEntryKey, targetPath)
+		return nil, nil
+	}
+
+	file, err := CreateAtomic(targetPath, mode)
+	if err != nil {
+		return nil, err
+	}
+	if x != nil && x.Backup == BackupNumbered {
+		file.beforeRename = func() error { return backupNumbered(targetPath) }
+	}
+
+	return file, nil
+}
+
+// Symlink creates a symlink to target at targetPath, as Create does a file:
+// the link is made under a temporary name and then moved into place,
+// following the same policy for an existing file.
+func (x *Extractor) Symlink(targetPath, target string, modTime time.Time) error {
+	if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
+		return fmt.Errorf("failed to create parent directory for %s: %w", targetPath, err)
+	}
+
+	replace, err := x.replace(targetPath, modTime)
+	if err != nil {
+		return err
+	}
+	if !replace {
+		Logger(x.Logger).Info(LogKept, LogEntryKey, targetPath)
+		return nil
+	}
+
+	// CreateTemp picks a free name, which the link then takes.
+	temp, err := os.CreateTemp(filepath.Dir(targetPath), "."+filepath.Base(targetPath)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file for %s: %w", targetPath, err)
+	}
+	temp.Close()
+	if err := os.Remove(temp.Name()); err != nil {
+		return fmt.Errorf("failed to create symlink %s: %w", targetPath, err)
+	}
+	if err := os.Symlink(target, temp.Name()); err != nil {
+		return fmt.Errorf("failed to create symlink %s: %w", targetPath, err)
+	}
+
+	if x != nil && x.Backup == BackupNumbered {
+		if err := backupNumbered(targetPath); err != nil {
+			os.Remove(temp.Name())
+			return err
+		}
+	}
+	if err := os.Rename(temp.Name(), targetPath); err != nil {
+		os.Remove(temp.Name())
+		return fmt.Errorf("failed to move %s into place: %w", targetPath, err)
+	}
+
+	return nil
+}
+
+// SafeLink reports whether the symlink member name may point to target:
+// relative targets that stay inside the directory the archive is
+// extracted to.
+func SafeLink(name, target string) bool {
+	if target == "" || path.IsAbs(target) || filepath.IsAbs(target) {
+		return false
+	}
+	return filepath.IsLocal(filepath.Join(filepath.Dir(filepath.FromSlash(name)), filepath.FromSlash(target)))
+}
+
+// replace reports whether targetPath may be written.
+func (x *Extractor) replace(targetPath string, modTime time.Time) (bool, error) {
+	if x == nil {
+		return true, nil
+	}
+
+	existing, err := os.Lstat(targetPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to stat %s: %w", targetPath, err)
+	}
+	if existing.IsDir() {
+		return false, fmt.Errorf("cannot replace directory %s with a file", targetPath)
+	}
+
+	switch x.Overwrite {
+	case OverwriteNever:
+		return false, nil
+	case OverwriteKeepOld:
+		return false, fmt.Errorf("%w: %s", ErrFileExists, targetPath)
+	case OverwriteNewer:
+		if !modTime.After(existing.ModTime()) {
+			return false, nil
+		}
+	case OverwriteAsk:
+		if x.Ask == nil {
+			return false, fmt.Errorf("%w: %s", ErrFileExists, targetPath)
+		}
+
+		x.mu.Lock()
+		ok, err := x.Ask(targetPath)
+		x.mu.Unlock()
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// backupNumbered renames path, if it exists, to the next free path.~N~.
+func backupNumbered(path string) error {
+	if _, err := os.Lstat(path); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return fmt.Errorf("failed to list backups of %s: %w", path, err)
+	}
+
+	prefix := filepath.Base(path) + ".~"
+	next := 1
+	for _, entry := range entries {
+		name := entry.Name()
+		if len(name) <= len(prefix) || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, "~") {
+			continue
+		}
+		if n, err := strconv.Atoi(name[len(prefix) : len(name)-1]); err == nil && n >= next {
+			next = n + 1
+		}
+	}
+
+	backup := fmt.Sprintf("%s.~%d~", path, next)
+	if err := os.Rename(path, backup); err != nil {
+		return fmt.Errorf("failed to back up %s: %w", path, err)
+	}
+
+	return nil
+}
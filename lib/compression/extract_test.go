@@ -0,0 +1,67 @@
+package compression
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func readString(t *testing.T, path string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// The existing file stays in place until the replacement is committed.
+func TestExtractorBackup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, []byte("v1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	x := &Extractor{Backup: BackupNumbered}
+
+	// A replacement that is never committed leaves no trace.
+	file, err := x.Create(path, 0644, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteString("discarded"); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := readString(t, path); got != "v1" {
+		t.Errorf("file = %q after a discarded replacement, want %q", got, "v1")
+	}
+	if _, err := os.Stat(path + ".~1~"); !os.IsNotExist(err) {
+		t.Errorf("backup made for a discarded replacement: %v", err)
+	}
+
+	for _, version := range []string{"v2", "v3"} {
+		file, err := x.Create(path, 0644, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := file.WriteString(version); err != nil {
+			t.Fatal(err)
+		}
+		if got := readString(t, path); got == version {
+			t.Fatalf("file replaced before Commit")
+		}
+		if err := file.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for path, want := range map[string]string{path: "v3", path + ".~1~": "v1", path + ".~2~": "v2"} {
+		if got := readString(t, path); got != want {
+			t.Errorf("%s = %q, want %q", filepath.Base(path), got, want)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package compression_test
+
+import (
+	"archivist/lib/compression"
+	tarformat "archivist/lib/compression/tar"
+	"archivist/lib/compression/tar_gz"
+	"archivist/lib/compression/tar_xz"
+	zipformat "archivist/lib/compression/zip"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+// fsEncoder packs archives and opens them as file systems.
+type fsEncoder interface {
+	compression.Encoder
+	compression.FSOpener
+}
+
+// Every format serves its members through io/fs, symlinks included, and
+// passes fstest.TestFS.
+func TestArchiveFS(t *testing.T) {
+	formats := []struct {
+		name string
+		new  func(path string) fsEncoder
+	}{
+		{"tar", func(path string) fsEncoder {
+			return tarformat.New(path)
+		}},
+		{"tar.gz", func(path string) fsEncoder {
+			return tar_gz.New(path)
+		}},
+		{"tar.xz", func(path string) fsEncoder {
+			return tar_xz.New(path)
+		}},
+		{"zip", func(path string) fsEncoder {
+			return zipformat.New(path)
+		}},
+	}
+
+	files := map[string]string{
+		"src/a.txt":          "alpha",
+		"src/sub/b.txt":      "bravo",
+		"src/sub/deep/c.txt": "charlie",
+	}
+
+	for _, format := range formats {
+		t.Run(format.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTree(t, dir, files)
+			if err := os.Symlink("sub/b.txt", filepath.Join(dir, "src", "link")); err != nil {
+				t.Fatal(err)
+			}
+			archive := filepath.Join(dir, "src."+format.name)
+			if err := format.new(archive).Encode([]string{filepath.Join(dir, "src")}); err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+
+			fsys, err := format.new(archive).OpenFS()
+			if err != nil {
+				t.Fatalf("OpenFS: %v", err)
+			}
+			defer fsys.Close()
+
+			if err := fstest.TestFS(fsys, "src/a.txt", "src/sub/b.txt", "src/sub/deep/c.txt"); err != nil {
+				t.Fatal(err)
+			}
+
+			for name, want := range files {
+				if got, err := fs.ReadFile(fsys, name); err != nil || string(got) != want {
+					t.Errorf("ReadFile(%s) = %q, %v; want %q", name, got, err, want)
+				}
+			}
+			if got, err := fs.ReadFile(fsys, "src/link"); err != nil || string(got) != "bravo" {
+				t.Errorf("ReadFile through a symlink = %q, %v", got, err)
+			}
+			if _, err := fsys.Open("src/missing"); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("Open of a missing member = %v, want %v", err, fs.ErrNotExist)
+			}
+			if _, err := fsys.Open("../src/a.txt"); !errors.Is(err, fs.ErrInvalid) {
+				t.Errorf("Open of an invalid path = %v, want %v", err, fs.ErrInvalid)
+			}
+		})
+	}
+}
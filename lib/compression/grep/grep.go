@@ -0,0 +1,165 @@
+// Package grep searches the members of archives for a regular expression
+// without extracting them.
+package grep
+
+import (
+	"archive/tar"
+	"archivist/lib/compression"
+	"archivist/lib/compression/walk"
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+)
+
+// binarySniffLen is how much of a member is checked for NUL bytes to tell
+// binary data from text, as grep does.
+const binarySniffLen = 8000
+
+// maxLineLength bounds the part of a line that is kept and matched; the
+// rest of a longer line is skipped, so memory doesn't grow with the line.
+const maxLineLength = 1 << 20
+
+// Options configures a search.
+type Options struct {
+	// Include, when set, restricts the search to members whose name or base
+	// name matches one of these path.Match patterns.
+	Include []string
+	// Exclude skips members whose name or base name matches one of these
+	// path.Match patterns.
+	Exclude []string
+	// Options decrypt the archive and, with Recursive, search the members
+	// of archives found inside it.
+	walk.Options
+}
+
+// Match is a line of a member that matches the pattern. Archive is the
+// path of the archive holding the member, joined with
+// compression.NestedSeparator to the names of the archives it is nested in.
+type Match struct {
+	Archive string
+	Member  string
+	Line    int
+	Text    string
+	// Binary reports a match in a binary member, whose lines aren't shown.
+	Binary bool
+}
+
+// Grep passes every line of the archive at archivePath, in format, that
+// matches pattern to fn.
+func Grep(archivePath, format string, pattern *regexp.Regexp, opts Options, fn func(Match) error) error {
+	for _, patterns := range [][]string{opts.Include, opts.Exclude} {
+		for _, glob := range patterns {
+			if _, err := path.Match(glob, ""); err != nil {
+				return fmt.Errorf("invalid member pattern %q: %w", glob, err)
+			}
+		}
+	}
+
+	// Binary members are matched as a single stream, where ^ and $ still
+	// have to match at every line.
+	stream, err := regexp.Compile("(?m:" + pattern.String() + ")")
+	if err != nil {
+		stream = pattern
+	}
+
+	s := &searcher{pattern: pattern, stream: stream, opts: opts, fn: fn}
+	return walk.Walk(archivePath, format, opts.Options, func(entry walk.Entry, body io.Reader) error {
+		if entry.Format != "" || entry.Typeflag != tar.TypeReg || !s.selected(entry.Name) {
+			return nil
+		}
+
+		archive := archivePath
+		if nested := entry.Archive(); nested != "" {
+			archive += compression.NestedSeparator + nested
+		}
+
+		return s.search(archive, entry.Name, bufio.NewReaderSize(body, binarySniffLen))
+	})
+}
+
+type searcher struct {
+	pattern *regexp.Regexp
+	stream  *regexp.Regexp
+	opts    Options
+	fn      func(Match) error
+}
+
+func (s *searcher) selected(name string) bool {
+	if len(s.opts.Include) > 0 && !matchAny(s.opts.Include, name) {
+		return false
+	}
+	return !matchAny(s.opts.Exclude, name)
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, path.Base(name)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// search scans a member line by line. Members with NUL bytes near the start
+// are binary and are matched as one stream, which only reports whether they
+// match.
+func (s *searcher) search(archive, member string, reader *bufio.Reader) error {
+	prefix, _ := reader.Peek(binarySniffLen)
+	if bytes.IndexByte(prefix, 0) >= 0 {
+		return s.searchBinary(archive, member, reader)
+	}
+
+	for number := 1; ; number++ {
+		line, err := readLine(reader)
+		line = bytes.TrimRight(line, "\r\n")
+		if s.pattern.Match(line) && (len(line) > 0 || err != io.EOF) {
+			if err := s.fn(Match{Archive: archive, Member: member, Line: number, Text: string(line)}); err != nil {
+				return err
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", member, err)
+		}
+	}
+}
+
+// searchBinary runs the pattern over the member as it streams, without
+// holding any of it in memory.
+func (s *searcher) searchBinary(archive, member string, reader *bufio.Reader) error {
+	matched := s.stream.MatchReader(reader)
+
+	// Drain the member, so stream checksums are still verified.
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		return fmt.Errorf("failed to read %s: %w", member, err)
+	}
+
+	if !matched {
+		return nil
+	}
+	return s.fn(Match{Archive: archive, Member: member, Binary: true})
+}
+
+// readLine reads up to and including the next newline, keeping at most
+// maxLineLength bytes of it.
+func readLine(reader *bufio.Reader) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if room := maxLineLength - len(line); room > 0 {
+			line = append(line, chunk[:min(len(chunk), room)]...)
+		}
+		if err != bufio.ErrBufferFull {
+			return line, err
+		}
+	}
+}
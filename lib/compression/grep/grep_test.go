@@ -0,0 +1,168 @@
+package grep
+
+import (
+	"archivist/lib/compression"
+	tarformat "archivist/lib/compression/tar"
+	"archivist/lib/compression/walk"
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// packTree writes files, by slash-separated name, under dir/src and packs
+// them into dir/src.tar.
+func packTree(t *testing.T, dir string, files map[string]string) string {
+	t.Helper()
+
+	for name, data := range files {
+		path := filepath.Join(dir, "src", filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	archive := filepath.Join(dir, "src.tar")
+	if err := tarformat.New(archive).Encode([]string{filepath.Join(dir, "src")}); err != nil {
+		t.Fatal(err)
+	}
+	return archive
+}
+
+func grep(t *testing.T, archive, pattern string, opts Options) []Match {
+	t.Helper()
+
+	var matches []Match
+	err := Grep(archive, compression.FormatTar, regexp.MustCompile(pattern), opts, func(match Match) error {
+		matches = append(matches, match)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Grep: %v", err)
+	}
+	return matches
+}
+
+func TestGrepLines(t *testing.T) {
+	archive := packTree(t, t.TempDir(), map[string]string{
+		"app.log": "start\nERROR disk full\nok\r\nERROR again",
+	})
+
+	got := grep(t, archive, "^ERROR", Options{})
+	want := []Match{
+		{Archive: archive, Member: "src/app.log", Line: 2, Text: "ERROR disk full"},
+		{Archive: archive, Member: "src/app.log", Line: 4, Text: "ERROR again"},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("matches = %+v, want %+v", got, want)
+	}
+}
+
+// Lines longer than maxLineLength are matched on their first part and
+// still count as one line.
+func TestGrepLongLine(t *testing.T) {
+	long := strings.Repeat("x", maxLineLength) + "hidden"
+	archive := packTree(t, t.TempDir(), map[string]string{
+		"long.txt": long + "\nvisible\n",
+	})
+
+	if got := grep(t, archive, "hidden", Options{}); len(got) != 0 {
+		t.Errorf("matched past maxLineLength: %+v", got)
+	}
+
+	got := grep(t, archive, "visible|^x+$", Options{})
+	if len(got) != 2 || got[0].Line != 1 || len(got[0].Text) != maxLineLength || got[1].Line != 2 {
+		t.Errorf("got %d matches, want the cut first line and line 2", len(got))
+	}
+}
+
+// Binary members report whether they match, and ^ still anchors at every
+// line of them.
+func TestGrepBinary(t *testing.T) {
+	data := string(bytes.Repeat([]byte{0, 1, 2}, 1000)) + "\nmagic\n"
+	archive := packTree(t, t.TempDir(), map[string]string{"blob.bin": data})
+
+	got := grep(t, archive, "^magic$", Options{})
+	want := []Match{{Archive: archive, Member: "src/blob.bin", Binary: true}}
+	if !slices.Equal(got, want) {
+		t.Errorf("matches = %+v, want %+v", got, want)
+	}
+
+	if got := grep(t, archive, "absent", Options{}); len(got) != 0 {
+		t.Errorf("matches = %+v, want none", got)
+	}
+}
+
+func TestGrepIncludeExclude(t *testing.T) {
+	archive := packTree(t, t.TempDir(), map[string]string{
+		"app.log":       "hit",
+		"debug.log":     "hit",
+		"notes.txt":     "hit",
+		"logs/deep.log": "hit",
+	})
+
+	members := func(matches []Match) []string {
+		var names []string
+		for _, match := range matches {
+			names = append(names, match.Member)
+		}
+		slices.Sort(names)
+		return names
+	}
+
+	tests := []struct {
+		include, exclude []string
+		want             []string
+	}{
+		{nil, nil, []string{"src/app.log", "src/debug.log", "src/logs/deep.log", "src/notes.txt"}},
+		{[]string{"*.log"}, nil, []string{"src/app.log", "src/debug.log", "src/logs/deep.log"}},
+		{[]string{"*.log"}, []string{"debug*"}, []string{"src/app.log", "src/logs/deep.log"}},
+		{[]string{"src/*.log"}, nil, []string{"src/app.log", "src/debug.log"}},
+		{nil, []string{"*.log"}, []string{"src/notes.txt"}},
+	}
+
+	for _, test := range tests {
+		got := members(grep(t, archive, "hit", Options{Include: test.include, Exclude: test.exclude}))
+		if !slices.Equal(got, test.want) {
+			t.Errorf("include %q, exclude %q: matched %q, want %q", test.include, test.exclude, got, test.want)
+		}
+	}
+
+	err := Grep(archive, compression.FormatTar, regexp.MustCompile("hit"), Options{Include: []string{"["}}, func(Match) error { return nil })
+	if err == nil {
+		t.Error("Grep with an invalid pattern succeeded")
+	}
+}
+
+// Members of nested archives are found with Recursive and reported under
+// the address of the archive holding them.
+func TestGrepRecursive(t *testing.T) {
+	dir := t.TempDir()
+	inner := packTree(t, filepath.Join(dir, "inner"), map[string]string{"deep.txt": "needle"})
+	data, err := os.ReadFile(inner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := packTree(t, dir, map[string]string{"lib/inner.tar": string(data), "top.txt": "needle"})
+
+	got := grep(t, archive, "needle", Options{Options: walk.Options{Recursive: true}})
+	want := []Match{
+		{Archive: archive + "!/src/lib/inner.tar", Member: "src/deep.txt", Line: 1, Text: "needle"},
+		{Archive: archive, Member: "src/top.txt", Line: 1, Text: "needle"},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("matches = %+v, want %+v", got, want)
+	}
+
+	// Include and exclude patterns apply to the members of nested archives.
+	got = grep(t, archive, "needle", Options{Exclude: []string{"deep.txt"}, Options: walk.Options{Recursive: true}})
+	if len(got) != 1 || got[0].Member != "src/top.txt" {
+		t.Errorf("matches excluding deep.txt = %+v", got)
+	}
+}
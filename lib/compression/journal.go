@@ -0,0 +1,290 @@
+package compression
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// JournalName is the file a resumable Decode keeps in the output directory.
+const JournalName = ".archivist-journal"
+
+// Journal records the files a resumable Decode has extracted, so that a run
+// cut short by a crash or a lost mount picks up where it stopped. It lives
+// in JournalName in the output directory until the extraction completes. A
+// nil Journal records nothing, so decoders can use one unconditionally.
+type Journal struct {
+	path string
+	dir  string
+	file *os.File
+	mu   sync.Mutex
+	done map[string]journalEntry
+}
+
+// journalArchive, the first line of a journal, identifies the archive.
+type journalArchive struct {
+	Archive string    `json:"archive"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+}
+
+// journalEntry is a file extracted in full, as it was left on disk, and the
+// archive offset reached once it was. An entry with only Started set is
+// logged before the file is written.
+type journalEntry struct {
+	Name    string    `json:"name"`
+	Started bool      `json:"started,omitempty"`
+	Size    int64     `json:"size,omitempty"`
+	ModTime time.Time `json:"mtime,omitzero"`
+	Offset  int64     `json:"offset,omitempty"`
+}
+
+// OpenJournal returns the journal of extracting archivePath into outputDir,
+// or nil when resume is false. Files recorded by an earlier run are kept
+// unless the archive has changed since, in which case it starts over.
+func OpenJournal(outputDir, archivePath string, resume bool) (*Journal, error) {
+	if !resume {
+		return nil, nil
+	}
+
+	info, err := os.Stat(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat %s: %w", archivePath, err)
+	}
+	archive := journalArchive{Archive: filepath.Base(archivePath), Size: info.Size(), ModTime: info.ModTime()}
+
+	j := &Journal{path: filepath.Join(outputDir, JournalName), dir: outputDir, done: map[string]journalEntry{}}
+	started, err := j.load(archive)
+	if err != nil {
+		return nil, err
+	}
+
+	// Files being written when the run was cut short left temporary files.
+	for name := range started {
+		if err := removeTemps(filepath.Join(outputDir, name)); err != nil {
+			return nil, err
+		}
+	}
+
+	// The journal is rewritten from what was read, dropping a line torn by
+	// the interruption, and then only appended to.
+	if err := j.rewrite(archive); err != nil {
+		return nil, err
+	}
+
+	j.file, err = os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal %s: %w", j.path, err)
+	}
+
+	return j, nil
+}
+
+// load reads the entries of an existing journal of archive, returning the
+// files started but not extracted in full.
+func (j *Journal) load(archive journalArchive) (map[string]bool, error) {
+	file, err := os.Open(j.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal %s: %w", j.path, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	started := map[string]bool{}
+
+	var recorded journalArchive
+	if !scanner.Scan() || json.Unmarshal(scanner.Bytes(), &recorded) != nil ||
+		recorded.Archive != archive.Archive || recorded.Size != archive.Size || !recorded.ModTime.Equal(archive.ModTime) {
+		return nil, nil
+	}
+
+	for scanner.Scan() {
+		var entry journalEntry
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil {
+			break
+		}
+		if entry.Started {
+			started[entry.Name] = true
+			continue
+		}
+		delete(started, entry.Name)
+		j.done[entry.Name] = entry
+	}
+
+	return started, nil
+}
+
+func (j *Journal) rewrite(archive journalArchive) error {
+	file, err := CreateAtomic(j.path, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	if err := encoder.Encode(archive); err != nil {
+		return fmt.Errorf("failed to write journal %s: %w", j.path, err)
+	}
+	for _, entry := range j.done {
+		if err := encoder.Encode(entry); err != nil {
+			return fmt.Errorf("failed to write journal %s: %w", j.path, err)
+		}
+	}
+
+	return file.Commit()
+}
+
+// Offset returns how far into the archive a resumed run may skip: the
+// furthest offset recorded for a file still in place, short of the first
+// recorded file that isn't, which must be extracted again. For uncompressed
+// tar archives it is where the entry after that file starts.
+func (j *Journal) Offset() int64 {
+	if j == nil {
+		return 0
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	inPlace := map[string]bool{}
+	limit := int64(math.MaxInt64)
+	for name, entry := range j.done {
+		inPlace[name] = j.inPlace(entry, filepath.Join(j.dir, name))
+		if !inPlace[name] {
+			limit = min(limit, entry.Offset)
+		}
+	}
+
+	var offset int64
+	for name, entry := range j.done {
+		if inPlace[name] && entry.Offset < limit {
+			offset = max(offset, entry.Offset)
+		}
+	}
+
+	return offset
+}
+
+// Done reports whether name was extracted to targetPath by an earlier run
+// and the file is still as that run left it.
+func (j *Journal) Done(name, targetPath string) bool {
+	if j == nil {
+		return false
+	}
+
+	j.mu.Lock()
+	entry, ok := j.done[name]
+	j.mu.Unlock()
+
+	return ok && j.inPlace(entry, targetPath)
+}
+
+// inPlace reports whether the file recorded by entry is still at targetPath
+// as it was extracted.
+func (j *Journal) inPlace(entry journalEntry, targetPath string) bool {
+	info, err := os.Lstat(targetPath)
+	return err == nil && info.Mode().IsRegular() && info.Size() == entry.Size && info.ModTime().Equal(entry.ModTime)
+}
+
+// Start notes that name is about to be written, so that a later run can
+// clean up after this one if it is cut short meanwhile.
+func (j *Journal) Start(name string) error {
+	if j == nil {
+		return nil
+	}
+
+	return j.write(journalEntry{Name: name, Started: true})
+}
+
+// Record notes that name has been extracted to targetPath in full, with the
+// archive read up to offset. It is safe for concurrent use.
+func (j *Journal) Record(name, targetPath string, offset int64) error {
+	if j == nil {
+		return nil
+	}
+
+	info, err := os.Lstat(targetPath)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", targetPath, err)
+	}
+
+	return j.write(journalEntry{Name: name, Size: info.Size(), ModTime: info.ModTime(), Offset: offset})
+}
+
+func (j *Journal) write(entry journalEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write journal %s: %w", j.path, err)
+	}
+	if !entry.Started {
+		j.done[entry.Name] = entry
+	}
+
+	return nil
+}
+
+// Finish removes the journal once the extraction has completed.
+func (j *Journal) Finish() error {
+	if j == nil {
+		return nil
+	}
+
+	j.Close()
+	if err := os.Remove(j.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to remove journal %s: %w", j.path, err)
+	}
+
+	return nil
+}
+
+// Close closes the journal, keeping it for a later run.
+func (j *Journal) Close() error {
+	if j == nil || j.file == nil {
+		return nil
+	}
+
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
+
+// OffsetReader counts the bytes read through it, starting from a given
+// offset, to tell how far into an archive a decoder has read.
+type OffsetReader struct {
+	r      io.Reader
+	offset int64
+}
+
+// NewOffsetReader returns a reader over r, which is at offset.
+func NewOffsetReader(r io.Reader, offset int64) *OffsetReader {
+	return &OffsetReader{r: r, offset: offset}
+}
+
+func (r *OffsetReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+// Offset returns the offset reached.
+func (r *OffsetReader) Offset() int64 {
+	return r.offset
+}
@@ -0,0 +1,25 @@
+package compression
+
+import "log/slog"
+
+// Messages logged by encoders and decoders. Every record carries the archive
+// member under LogEntryKey.
+const (
+	LogAdding     = "adding"
+	LogExtracting = "extracting"
+	LogSkipped    = "skipping potentially unsafe path"
+	LogKept       = "keeping existing file"
+	LogDone       = "skipping file extracted by an earlier run"
+	LogSparse     = "storing sparse file in full, its holes filled with zeros"
+)
+
+// LogEntryKey is the attribute holding the archive member name.
+const LogEntryKey = "entry"
+
+// Logger returns logger, or one that discards every record when it is nil.
+func Logger(logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		return slog.New(slog.DiscardHandler)
+	}
+	return logger
+}
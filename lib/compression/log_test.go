@@ -0,0 +1,190 @@
+package compression_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"archivist/lib/compression"
+	tarformat "archivist/lib/compression/tar"
+	zipformat "archivist/lib/compression/zip"
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// recordHandler keeps the message, level and entry of every record.
+type recordHandler struct {
+	mu      sync.Mutex
+	records []logRecord
+}
+
+type logRecord struct {
+	level   slog.Level
+	message string
+	entry   string
+}
+
+func (h *recordHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h *recordHandler) Handle(_ context.Context, record slog.Record) error {
+	logged := logRecord{level: record.Level, message: record.Message}
+	record.Attrs(func(attr slog.Attr) bool {
+		if attr.Key == compression.LogEntryKey {
+			logged.entry = attr.Value.String()
+		}
+		return true
+	})
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.records = append(h.records, logged)
+	return nil
+}
+
+func (h *recordHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+func (h *recordHandler) WithGroup(string) slog.Handler      { return h }
+
+// entries returns the entries logged with message at level.
+func (h *recordHandler) entries(level slog.Level, message string) []string {
+	var entries []string
+	for _, record := range h.records {
+		if record.level == level && record.message == message {
+			entries = append(entries, record.entry)
+		}
+	}
+	slices.Sort(entries)
+	return entries
+}
+
+// Encoders log every entry they add and decoders every entry they extract,
+// at info level, with the member under LogEntryKey.
+func TestLogEntries(t *testing.T) {
+	formats := []struct {
+		name   string
+		encode func(path, src string, logger *slog.Logger) error
+		decode func(path, out string, logger *slog.Logger) error
+	}{
+		{
+			name: "tar",
+			encode: func(path, src string, logger *slog.Logger) error {
+				return (&tarformat.EncodeDecoder{OutputPath: path, Logger: logger}).Encode([]string{src})
+			},
+			decode: func(path, out string, logger *slog.Logger) error {
+				return (&tarformat.EncodeDecoder{OutputPath: path, Logger: logger}).Decode(out)
+			},
+		},
+		{
+			name: "zip",
+			encode: func(path, src string, logger *slog.Logger) error {
+				return (&zipformat.EncodeDecoder{OutputPath: path, Logger: logger}).Encode([]string{src})
+			},
+			decode: func(path, out string, logger *slog.Logger) error {
+				return (&zipformat.EncodeDecoder{OutputPath: path, Logger: logger}).Decode(out)
+			},
+		},
+	}
+
+	for _, format := range formats {
+		t.Run(format.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTree(t, dir, map[string]string{"src/a": "a", "src/sub/b": "b"})
+			archive := filepath.Join(dir, "src."+format.name)
+
+			var packing recordHandler
+			if err := format.encode(archive, filepath.Join(dir, "src"), slog.New(&packing)); err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+			added := packing.entries(slog.LevelInfo, compression.LogAdding)
+			for _, name := range []string{"src/a", "src/sub/b"} {
+				if !slices.Contains(added, name) {
+					t.Errorf("%s not logged as added, got %q", name, added)
+				}
+			}
+
+			var unpacking recordHandler
+			if err := format.decode(archive, filepath.Join(dir, "out"), slog.New(&unpacking)); err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			extracted := unpacking.entries(slog.LevelInfo, compression.LogExtracting)
+			for _, name := range []string{"src/a", "src/sub/b"} {
+				if !slices.Contains(extracted, name) {
+					t.Errorf("%s not logged as extracted, got %q", name, extracted)
+				}
+			}
+
+			// A nil logger discards everything.
+			if err := format.encode(archive, filepath.Join(dir, "src"), nil); err != nil {
+				t.Fatalf("Encode without a logger: %v", err)
+			}
+		})
+	}
+}
+
+// Members with .. in their names are skipped with a warning, not
+// extracted outside the output directory.
+func TestLogSkippedUnsafePaths(t *testing.T) {
+	dir := t.TempDir()
+	const evil = "../evil.txt"
+
+	var tarData bytes.Buffer
+	tw := tar.NewWriter(&tarData)
+	for _, name := range []string{evil, "good.txt"} {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: 4, Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte("data"))
+	}
+	tw.Close()
+	tarPath := filepath.Join(dir, "evil.tar")
+	if err := os.WriteFile(tarPath, tarData.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var zipData bytes.Buffer
+	zw := zip.NewWriter(&zipData)
+	for _, name := range []string{evil, "good.txt"} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte("data"))
+	}
+	zw.Close()
+	zipPath := filepath.Join(dir, "evil.zip")
+	if err := os.WriteFile(zipPath, zipData.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	decoders := map[string]func(out string, logger *slog.Logger) error{
+		"tar": func(out string, logger *slog.Logger) error {
+			return (&tarformat.EncodeDecoder{OutputPath: tarPath, Logger: logger}).Decode(out)
+		},
+		"zip": func(out string, logger *slog.Logger) error {
+			return (&zipformat.EncodeDecoder{OutputPath: zipPath, Logger: logger}).Decode(out)
+		},
+	}
+
+	for name, decode := range decoders {
+		t.Run(name, func(t *testing.T) {
+			out := filepath.Join(dir, name, "out")
+			var handler recordHandler
+			if err := decode(out, slog.New(&handler)); err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+
+			if got := handler.entries(slog.LevelWarn, compression.LogSkipped); !slices.Equal(got, []string{evil}) {
+				t.Errorf("skipped %q, want %q", got, evil)
+			}
+			if _, err := os.Stat(filepath.Join(out, evil)); !os.IsNotExist(err) {
+				t.Errorf("%s extracted: %v", evil, err)
+			}
+			if _, err := os.Stat(filepath.Join(out, "good.txt")); err != nil {
+				t.Errorf("good.txt not extracted: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package compression
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+)
+
+// ManifestSHA256 is the digest algorithm accepted by pack --manifest.
+const ManifestSHA256 = "sha256"
+
+// PAXDigestKey is the PAX record holding a tar entry's SHA-256 digest.
+const PAXDigestKey = "ARCHIVIST.sha256"
+
+// ManifestName is the zip member listing entry digests in sha256sum format,
+// so it can also be checked with `sha256sum -c` after extraction.
+const ManifestName = "MANIFEST.sha256"
+
+var (
+	ErrDigestMismatch = errors.New("content does not match manifest digest")
+	ErrNoDigest       = errors.New("no manifest digest recorded")
+)
+
+// NewDigest returns the hash used for manifest digests.
+func NewDigest() hash.Hash {
+	return sha256.New()
+}
+
+// CheckDigest compares the content hashed into digest with the expected
+// hex-encoded manifest digest.
+func CheckDigest(digest hash.Hash, expected string) error {
+	if expected == "" {
+		return ErrNoDigest
+	}
+
+	if actual := hex.EncodeToString(digest.Sum(nil)); actual != strings.ToLower(expected) {
+		return fmt.Errorf("%w: expected %s, got %s", ErrDigestMismatch, expected, actual)
+	}
+
+	return nil
+}
+
+// ManifestEntry is one line of a manifest file.
+type ManifestEntry struct {
+	Name   string
+	Digest string
+}
+
+// WriteManifest writes entries in sha256sum format.
+func WriteManifest(w io.Writer, entries []ManifestEntry) error {
+	for _, entry := range entries {
+		if _, err := fmt.Fprintf(w, "%s  %s\n", entry.Digest, entry.Name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ReadManifest parses a sha256sum-formatted manifest into digests by name.
+func ReadManifest(r io.Reader) (map[string]string, error) {
+	digests := make(map[string]string)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+
+		digest, name, ok := strings.Cut(line, "  ")
+		if !ok {
+			return nil, fmt.Errorf("malformed manifest line: %q", line)
+		}
+		digests[name] = digest
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	return digests, nil
+}
@@ -0,0 +1,47 @@
+package compression
+
+import (
+	"bytes"
+	"strings"
+)
+
+// NestedSeparator joins the path of an archive and the name of an archive
+// inside it, as in outer.zip!/inner.tar.gz!/file.
+const NestedSeparator = "!/"
+
+// tarSuffixes name the compressed tar archives, told apart from singly
+// compressed files by their extension.
+var tarSuffixes = map[string][]string{
+	FormatTarGz: {".tar.gz", ".tgz"},
+	FormatTarXz: {".tar.xz", ".txz"},
+	FormatTarBz: {".tar.bz", ".tar.bz2", ".tbz", ".tbz2"},
+}
+
+// NestedFormat returns the format of the archive member called name whose
+// data starts with prefix, its first SniffLen bytes, or "" when it isn't an
+// archive to descend into. Zip and tar are recognized by their magic bytes
+// alone; compressed data is only taken for a tar archive when the name says
+// so, since a log.gz is just a compressed file. Encrypted members are left
+// alone.
+func NestedFormat(name string, prefix []byte) string {
+	format, err := DetectFormat(bytes.NewReader(prefix))
+	if err != nil {
+		return ""
+	}
+
+	switch format {
+	case FormatZip, FormatTar:
+		return format
+	case FormatAge:
+		return ""
+	}
+
+	lower := strings.ToLower(name)
+	for _, suffix := range tarSuffixes[format] {
+		if strings.HasSuffix(lower, suffix) {
+			return format
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,137 @@
+package parallel
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// GzipBlockSize is the amount of input compressed by each gzip job.
+const GzipBlockSize = 1 << 20
+
+// gzipWindow is the deflate window, primed from the previous block so that
+// splitting the input costs almost no compression ratio.
+const gzipWindow = 32 << 10
+
+// gzipHeader matches what compress/gzip writes with a zero Header: no name,
+// no modification time and an unknown OS.
+var gzipHeader = []byte{0x1f, 0x8b, 8, 0, 0, 0, 0, 0, 0, 255}
+
+// GzipWriter is a pigz-style gzip writer. Each block ends with a deflate sync
+// flush, so the concatenated blocks form one ordinary deflate stream.
+type GzipWriter struct {
+	w      io.Writer
+	pipe   *Pipeline[[]byte]
+	blocks *blockBuffer
+	dict   []byte
+	crc    uint32
+	size   uint32
+	closed bool
+}
+
+// NewGzipWriter returns a gzip writer compressing on threads goroutines,
+// or on all cores when threads is below one.
+func NewGzipWriter(w io.Writer, threads int) (*GzipWriter, error) {
+	if _, err := w.Write(gzipHeader); err != nil {
+		return nil, fmt.Errorf("failed to write gzip header: %w", err)
+	}
+
+	gw := &GzipWriter{
+		w: w,
+		pipe: NewPipeline(threads, func(data []byte) error {
+			_, err := w.Write(data)
+			return err
+		}),
+	}
+	gw.blocks = &blockBuffer{
+		size: GzipBlockSize,
+		flush: func(block []byte) error {
+			return gw.submit(block, false)
+		},
+	}
+
+	return gw, nil
+}
+
+func (gw *GzipWriter) Write(p []byte) (int, error) {
+	if gw.closed {
+		return 0, fmt.Errorf("write to closed gzip writer")
+	}
+
+	gw.crc = crc32.Update(gw.crc, crc32.IEEETable, p)
+	gw.size += uint32(len(p))
+
+	return gw.blocks.Write(p)
+}
+
+// Close compresses the remaining input and writes the gzip trailer.
+// It does not close the underlying writer.
+func (gw *GzipWriter) Close() error {
+	if gw.closed {
+		return nil
+	}
+	gw.closed = true
+
+	err := gw.submit(gw.blocks.rest(), true)
+	if closeErr := gw.pipe.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	trailer := make([]byte, 8)
+	binary.LittleEndian.PutUint32(trailer[:4], gw.crc)
+	binary.LittleEndian.PutUint32(trailer[4:], gw.size)
+	if _, err := gw.w.Write(trailer); err != nil {
+		return fmt.Errorf("failed to write gzip trailer: %w", err)
+	}
+
+	return nil
+}
+
+func (gw *GzipWriter) submit(block []byte, last bool) error {
+	dict := gw.dict
+
+	// Blocks are never modified after submission, so the window can share them.
+	window := block
+	if len(block) < gzipWindow {
+		window = append(append([]byte(nil), dict...), block...)
+	}
+	if len(window) > gzipWindow {
+		window = window[len(window)-gzipWindow:]
+	}
+	gw.dict = window
+
+	return gw.pipe.Submit(func() ([]byte, error) {
+		return deflateBlock(block, dict, last)
+	})
+}
+
+func deflateBlock(block, dict []byte, last bool) ([]byte, error) {
+	var out bytes.Buffer
+
+	fw, err := flate.NewWriterDict(&out, flate.DefaultCompression, dict)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fw.Write(block); err != nil {
+		return nil, err
+	}
+
+	// Only the last block may carry the final-block bit; the others end on a
+	// byte-aligned sync flush so the next block can follow directly.
+	if last {
+		err = fw.Close()
+	} else {
+		err = fw.Flush()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
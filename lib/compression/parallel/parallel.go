@@ -0,0 +1,155 @@
+// Package parallel provides block-parallel gzip and xz writers. Input is cut
+// into fixed-size blocks that are compressed concurrently and written out in
+// order, so the output is a single standard stream whose bytes don't depend
+// on the number of threads. Pipeline offers the same ordered fan-out to other
+// encoders.
+package parallel
+
+import (
+	"io"
+	"runtime"
+	"sync"
+)
+
+// Threads resolves a thread count, treating values below one as all cores.
+func Threads(threads int) int {
+	if threads < 1 {
+		return runtime.NumCPU()
+	}
+	return threads
+}
+
+type result[T any] struct {
+	value T
+	err   error
+}
+
+// Pipeline runs compression jobs on a bounded number of goroutines and hands
+// their results to write in submission order.
+type Pipeline[T any] struct {
+	// Discard, when set, receives the results that are skipped because an
+	// earlier job or write failed, so their resources can be released.
+	Discard func(value T)
+
+	queue chan chan result[T]
+	sem   chan struct{}
+	done  chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+// NewPipeline starts a pipeline running up to threads jobs at once, or one
+// per core when threads is below one. write is called on a single goroutine.
+func NewPipeline[T any](threads int, write func(value T) error) *Pipeline[T] {
+	threads = Threads(threads)
+
+	p := &Pipeline[T]{
+		queue: make(chan chan result[T], threads),
+		sem:   make(chan struct{}, threads),
+		done:  make(chan struct{}),
+	}
+
+	go func() {
+		defer close(p.done)
+
+		for pending := range p.queue {
+			res := <-pending
+			if p.failed() != nil {
+				if res.err == nil && p.Discard != nil {
+					p.Discard(res.value)
+				}
+				continue
+			}
+			if res.err == nil {
+				res.err = write(res.value)
+			}
+			if res.err != nil {
+				p.fail(res.err)
+			}
+		}
+	}()
+
+	return p
+}
+
+// Submit schedules job, blocking while all threads are busy.
+func (p *Pipeline[T]) Submit(job func() (T, error)) error {
+	if err := p.failed(); err != nil {
+		return err
+	}
+
+	pending := make(chan result[T], 1)
+	p.queue <- pending
+	p.sem <- struct{}{}
+
+	go func() {
+		defer func() { <-p.sem }()
+
+		value, err := job()
+		pending <- result[T]{value: value, err: err}
+	}()
+
+	return nil
+}
+
+// Close waits for every submitted job to be written.
+func (p *Pipeline[T]) Close() error {
+	close(p.queue)
+	<-p.done
+	return p.failed()
+}
+
+func (p *Pipeline[T]) failed() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+func (p *Pipeline[T]) fail(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+// blockBuffer accumulates writes into blocks of a fixed size.
+type blockBuffer struct {
+	size  int
+	buf   []byte
+	flush func(block []byte) error
+}
+
+func (b *blockBuffer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if b.buf == nil {
+			b.buf = make([]byte, 0, b.size)
+		}
+
+		n := min(len(p), b.size-len(b.buf))
+		b.buf = append(b.buf, p[:n]...)
+		p = p[n:]
+		written += n
+
+		if len(b.buf) == b.size {
+			block := b.buf
+			b.buf = nil
+			if err := b.flush(block); err != nil {
+				return written, err
+			}
+		}
+	}
+
+	return written, nil
+}
+
+// rest returns the partial block left after the last full one.
+func (b *blockBuffer) rest() []byte {
+	block := b.buf
+	b.buf = nil
+	return block
+}
+
+var _ io.Writer = (*blockBuffer)(nil)
@@ -0,0 +1,179 @@
+package parallel
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/ulikunitz/xz"
+	"io"
+	"math/rand"
+	"runtime"
+	"testing"
+)
+
+// sample returns n bytes of compressible text, the same on every call.
+func sample(n int) []byte {
+	words := []string{"archive", "tar", "volume", "entry", "header", "block", "stream", "the", "of", "and"}
+	rng := rand.New(rand.NewSource(1))
+
+	var buf bytes.Buffer
+	for buf.Len() < n {
+		buf.WriteString(words[rng.Intn(len(words))])
+		buf.WriteByte(" \n"[rng.Intn(2)])
+		if rng.Intn(50) == 0 {
+			fmt.Fprintf(&buf, "%x", rng.Uint64())
+		}
+	}
+
+	return buf.Bytes()[:n]
+}
+
+func compress(t testing.TB, data []byte, newWriter func(io.Writer) (io.WriteCloser, error)) []byte {
+	t.Helper()
+
+	var out bytes.Buffer
+	w, err := newWriter(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return out.Bytes()
+}
+
+func gzipWriter(threads int) func(io.Writer) (io.WriteCloser, error) {
+	return func(w io.Writer) (io.WriteCloser, error) {
+		return NewGzipWriter(w, threads)
+	}
+}
+
+func xzWriter(threads int) func(io.Writer) (io.WriteCloser, error) {
+	return func(w io.Writer) (io.WriteCloser, error) {
+		return NewXzWriter(w, threads)
+	}
+}
+
+// threadCounts are the thread counts output must not depend on.
+var threadCounts = []int{1, 2, 3, 8}
+
+func TestGzipRoundTrip(t *testing.T) {
+	// Several blocks, the last one partial.
+	data := sample(3*GzipBlockSize + 12345)
+
+	var first []byte
+	for _, threads := range threadCounts {
+		out := compress(t, data, gzipWriter(threads))
+
+		r, err := gzip.NewReader(bytes.NewReader(out))
+		if err != nil {
+			t.Fatalf("threads=%d: %v", threads, err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("threads=%d: compress/gzip can't read the output: %v", threads, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("threads=%d: output decodes to different data", threads)
+		}
+
+		if first == nil {
+			first = out
+		} else if !bytes.Equal(out, first) {
+			t.Errorf("threads=%d: output differs from threads=%d", threads, threadCounts[0])
+		}
+	}
+}
+
+func TestGzipEmpty(t *testing.T) {
+	out := compress(t, nil, gzipWriter(4))
+
+	r, err := gzip.NewReader(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := io.ReadAll(r); err != nil || len(got) != 0 {
+		t.Fatalf("ReadAll = %d bytes, %v; want an empty stream", len(got), err)
+	}
+}
+
+func TestXzRoundTrip(t *testing.T) {
+	if testing.Short() {
+		t.Skip("compresses more than one xz block")
+	}
+
+	data := sample(XzBlockSize + 54321)
+
+	var first []byte
+	for _, threads := range threadCounts[:2] {
+		out := compress(t, data, xzWriter(threads))
+
+		r, err := xz.NewReader(bytes.NewReader(out))
+		if err != nil {
+			t.Fatalf("threads=%d: %v", threads, err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("threads=%d: ulikunitz/xz can't read the output: %v", threads, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("threads=%d: output decodes to different data", threads)
+		}
+
+		if first == nil {
+			first = out
+		} else if !bytes.Equal(out, first) {
+			t.Errorf("threads=%d: output differs from threads=%d", threads, threadCounts[0])
+		}
+	}
+}
+
+func TestXzEmpty(t *testing.T) {
+	out := compress(t, nil, xzWriter(4))
+
+	r, err := xz.NewReader(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := io.ReadAll(r); err != nil || len(got) != 0 {
+		t.Fatalf("ReadAll = %d bytes, %v; want an empty stream", len(got), err)
+	}
+}
+
+// benchmark compresses data with baseline, the single-threaded writer the
+// parallel one replaces, then with newWriter on one thread and on every
+// core, reporting throughput.
+func benchmark(b *testing.B, data []byte, baseline func(io.Writer) (io.WriteCloser, error), newWriter func(threads int) func(io.Writer) (io.WriteCloser, error)) {
+	run := func(name string, newWriter func(io.Writer) (io.WriteCloser, error)) {
+		b.Run(name, func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for range b.N {
+				compress(b, data, newWriter)
+			}
+		})
+	}
+
+	run("baseline", baseline)
+	run("threads=1", newWriter(1))
+	if cores := runtime.NumCPU(); cores > 1 {
+		run(fmt.Sprintf("threads=%d", cores), newWriter(cores))
+	}
+}
+
+// BenchmarkGzip compares GzipWriter with compress/gzip.
+func BenchmarkGzip(b *testing.B) {
+	benchmark(b, sample(16*GzipBlockSize), func(w io.Writer) (io.WriteCloser, error) {
+		return gzip.NewWriter(w), nil
+	}, gzipWriter)
+}
+
+// BenchmarkXz compares XzWriter with the ulikunitz/xz writer.
+func BenchmarkXz(b *testing.B) {
+	benchmark(b, sample(4*XzBlockSize), func(w io.Writer) (io.WriteCloser, error) {
+		return xz.NewWriter(w)
+	}, xzWriter)
+}
@@ -0,0 +1,169 @@
+package parallel
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/ulikunitz/xz/lzma"
+	"hash/crc32"
+	"hash/crc64"
+	"io"
+)
+
+// XzDictCap is the LZMA2 dictionary size, the xz and lzma package default.
+const XzDictCap = 8 << 20
+
+// XzBlockSize follows xz -T and uses three times the dictionary size.
+const XzBlockSize = 3 * XzDictCap
+
+const (
+	xzCheckCRC64  = 0x04
+	xzLZMA2Filter = 0x21
+	xzCheckSize   = 8
+)
+
+var (
+	xzHeaderMagic = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	xzFooterMagic = []byte{'Y', 'Z'}
+	xzStreamFlags = []byte{0x00, xzCheckCRC64}
+	crc64Table    = crc64.MakeTable(crc64.ECMA)
+)
+
+// xzRecord is an index entry describing one block.
+type xzRecord struct {
+	unpaddedSize     int64
+	uncompressedSize int64
+}
+
+// xzBlock is a compressed block ready to be written, with its index record.
+type xzBlock struct {
+	data   []byte
+	record xzRecord
+}
+
+// XzWriter writes a single xz stream made of independently compressed
+// blocks, like xz -T. Any xz decoder reads it as a regular .xz file.
+type XzWriter struct {
+	w       io.Writer
+	pipe    *Pipeline[xzBlock]
+	blocks  *blockBuffer
+	records []xzRecord
+	closed  bool
+}
+
+// NewXzWriter returns an xz writer compressing on threads goroutines,
+// or on all cores when threads is below one.
+func NewXzWriter(w io.Writer, threads int) (*XzWriter, error) {
+	header := append(append([]byte(nil), xzHeaderMagic...), xzStreamFlags...)
+	header = binary.LittleEndian.AppendUint32(header, crc32.ChecksumIEEE(xzStreamFlags))
+	if _, err := w.Write(header); err != nil {
+		return nil, fmt.Errorf("failed to write xz stream header: %w", err)
+	}
+
+	xw := &XzWriter{w: w}
+	xw.pipe = NewPipeline(threads, func(block xzBlock) error {
+		xw.records = append(xw.records, block.record)
+		_, err := w.Write(block.data)
+		return err
+	})
+	xw.blocks = &blockBuffer{
+		size: XzBlockSize,
+		flush: func(block []byte) error {
+			return xw.pipe.Submit(func() (xzBlock, error) {
+				return compressXzBlock(block)
+			})
+		},
+	}
+
+	return xw, nil
+}
+
+func (xw *XzWriter) Write(p []byte) (int, error) {
+	if xw.closed {
+		return 0, fmt.Errorf("write to closed xz writer")
+	}
+
+	return xw.blocks.Write(p)
+}
+
+// Close compresses the remaining input and writes the stream index and
+// footer. It does not close the underlying writer.
+func (xw *XzWriter) Close() error {
+	if xw.closed {
+		return nil
+	}
+	xw.closed = true
+
+	var err error
+	if rest := xw.blocks.rest(); len(rest) > 0 {
+		err = xw.pipe.Submit(func() (xzBlock, error) {
+			return compressXzBlock(rest)
+		})
+	}
+	if closeErr := xw.pipe.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	index := []byte{0x00}
+	index = binary.AppendUvarint(index, uint64(len(xw.records)))
+	for _, record := range xw.records {
+		index = binary.AppendUvarint(index, uint64(record.unpaddedSize))
+		index = binary.AppendUvarint(index, uint64(record.uncompressedSize))
+	}
+	index = append(index, make([]byte, padding(len(index)))...)
+	index = binary.LittleEndian.AppendUint32(index, crc32.ChecksumIEEE(index))
+
+	footer := binary.LittleEndian.AppendUint32(nil, uint32(len(index)/4-1))
+	footer = append(footer, xzStreamFlags...)
+	footer = append(binary.LittleEndian.AppendUint32(nil, crc32.ChecksumIEEE(footer)), footer...)
+	footer = append(footer, xzFooterMagic...)
+
+	if _, err := xw.w.Write(append(index, footer...)); err != nil {
+		return fmt.Errorf("failed to write xz index: %w", err)
+	}
+
+	return nil
+}
+
+// compressXzBlock encodes one xz block: header, LZMA2 data, padding and
+// CRC-64 check.
+func compressXzBlock(block []byte) (xzBlock, error) {
+	var out bytes.Buffer
+
+	header := []byte{0, 0x00, xzLZMA2Filter, 1, lzma.EncodeDictCap(XzDictCap)}
+	header = append(header, make([]byte, padding(len(header)))...)
+	header[0] = byte((len(header)+4)/4 - 1)
+	header = binary.LittleEndian.AppendUint32(header, crc32.ChecksumIEEE(header))
+	out.Write(header)
+
+	lw, err := lzma.Writer2Config{DictCap: XzDictCap}.NewWriter2(&out)
+	if err != nil {
+		return xzBlock{}, fmt.Errorf("failed to create lzma2 writer: %w", err)
+	}
+	if _, err := lw.Write(block); err != nil {
+		return xzBlock{}, err
+	}
+	if err := lw.Close(); err != nil {
+		return xzBlock{}, err
+	}
+
+	unpadded := out.Len()
+	out.Write(make([]byte, padding(unpadded)))
+	out.Write(binary.LittleEndian.AppendUint64(nil, crc64.Checksum(block, crc64Table)))
+
+	return xzBlock{
+		data: out.Bytes(),
+		record: xzRecord{
+			unpaddedSize:     int64(unpadded + xzCheckSize),
+			uncompressedSize: int64(len(block)),
+		},
+	}, nil
+}
+
+// padding returns the zero bytes needed to align n to four bytes.
+func padding(n int) int {
+	return (4 - n%4) % 4
+}
@@ -0,0 +1,100 @@
+package compression
+
+import (
+	"io"
+	"sync"
+)
+
+// Progress is a snapshot of a running Encode or Decode.
+type Progress struct {
+	// Entry is the archive member most recently started.
+	Entry string
+	// Done counts the bytes processed so far: source file bytes when
+	// packing, compressed archive bytes when unpacking.
+	Done int64
+	// Total is the value Done is heading for, or zero when it is unknown.
+	Total int64
+}
+
+// ProgressFunc receives progress snapshots. Calls never overlap but may come
+// from different goroutines, and they are frequent, so it should return
+// quickly and do its own rate limiting.
+type ProgressFunc func(progress Progress)
+
+// Tracker counts processed bytes on behalf of a ProgressFunc. A nil Tracker
+// ignores every call, so encoders can use one unconditionally.
+type Tracker struct {
+	report   ProgressFunc
+	mu       sync.Mutex
+	progress Progress
+}
+
+// NewTracker returns a tracker reporting to report, or nil if report is nil.
+func NewTracker(report ProgressFunc, total int64) *Tracker {
+	if report == nil {
+		return nil
+	}
+
+	return &Tracker{report: report, progress: Progress{Total: total}}
+}
+
+// TrackSources returns a tracker whose total is the size of the regular
+// files among sources.
+func TrackSources(report ProgressFunc, sources []Source) *Tracker {
+	if report == nil {
+		return nil
+	}
+
+	var total int64
+	for _, source := range sources {
+		if source.Info.Mode().IsRegular() {
+			total += source.Info.Size()
+		}
+	}
+
+	return NewTracker(report, total)
+}
+
+// Start reports that entry is being processed.
+func (t *Tracker) Start(entry string) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.progress.Entry = entry
+	t.report(t.progress)
+}
+
+// Add reports n more bytes processed.
+func (t *Tracker) Add(n int64) {
+	if t == nil || n == 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.progress.Done += n
+	t.report(t.progress)
+}
+
+// Reader wraps r so that every byte read through it is added to the tracker.
+func (t *Tracker) Reader(r io.Reader) io.Reader {
+	if t == nil {
+		return r
+	}
+
+	return &trackedReader{r: r, tracker: t}
+}
+
+type trackedReader struct {
+	r       io.Reader
+	tracker *Tracker
+}
+
+func (tr *trackedReader) Read(p []byte) (int, error) {
+	n, err := tr.r.Read(p)
+	tr.tracker.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,129 @@
+package compression_test
+
+import (
+	"archivist/lib/compression"
+	"archivist/lib/compression/tar_gz"
+	zipformat "archivist/lib/compression/zip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// progressLog records the snapshots a ProgressFunc receives.
+type progressLog struct {
+	snapshots []compression.Progress
+}
+
+func (p *progressLog) report(progress compression.Progress) {
+	p.snapshots = append(p.snapshots, progress)
+}
+
+// check reports snapshots that go backwards or change their total, and a
+// last snapshot that isn't done at total.
+func (p *progressLog) check(t *testing.T, total int64) {
+	t.Helper()
+
+	if len(p.snapshots) == 0 {
+		t.Fatal("no progress reported")
+	}
+	for i, snapshot := range p.snapshots {
+		if snapshot.Total != total {
+			t.Fatalf("snapshot %d has total %d, want %d", i, snapshot.Total, total)
+		}
+		if i > 0 && snapshot.Done < p.snapshots[i-1].Done {
+			t.Fatalf("progress went back from %d to %d", p.snapshots[i-1].Done, snapshot.Done)
+		}
+	}
+	if last := p.snapshots[len(p.snapshots)-1]; last.Done != total {
+		t.Errorf("progress ended at %d of %d", last.Done, total)
+	}
+}
+
+func (p *progressLog) entries() []string {
+	var entries []string
+	for _, snapshot := range p.snapshots {
+		if len(entries) == 0 || entries[len(entries)-1] != snapshot.Entry {
+			entries = append(entries, snapshot.Entry)
+		}
+	}
+	return entries
+}
+
+// Packing counts source bytes up to their total, and unpacking counts
+// archive bytes up to the archive's size.
+func TestProgress(t *testing.T) {
+	formats := []struct {
+		name   string
+		encode func(path, src string, progress compression.ProgressFunc) error
+		decode func(path, out string, progress compression.ProgressFunc) error
+	}{
+		{
+			name: "tar.gz",
+			encode: func(path, src string, progress compression.ProgressFunc) error {
+				return (&tar_gz.EncodeDecoder{OutputPath: path, Progress: progress}).Encode([]string{src})
+			},
+			decode: func(path, out string, progress compression.ProgressFunc) error {
+				return (&tar_gz.EncodeDecoder{OutputPath: path, Progress: progress}).Decode(out)
+			},
+		},
+		{
+			name: "zip",
+			encode: func(path, src string, progress compression.ProgressFunc) error {
+				return (&zipformat.EncodeDecoder{OutputPath: path, Threads: 2, Progress: progress}).Encode([]string{src})
+			},
+			decode: func(path, out string, progress compression.ProgressFunc) error {
+				return (&zipformat.EncodeDecoder{OutputPath: path, Progress: progress}).Decode(out)
+			},
+		},
+	}
+
+	files := map[string]string{
+		"src/a":     strings.Repeat("0123456789", 10000),
+		"src/b":     "",
+		"src/sub/c": strings.Repeat("c", 3000),
+	}
+	var total int64
+	for _, data := range files {
+		total += int64(len(data))
+	}
+
+	for _, format := range formats {
+		t.Run(format.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTree(t, dir, files)
+			archive := filepath.Join(dir, "src."+format.name)
+
+			var packing progressLog
+			if err := format.encode(archive, filepath.Join(dir, "src"), packing.report); err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+			packing.check(t, total)
+			for _, name := range []string{"src/a", "src/sub/c"} {
+				if !slices.Contains(packing.entries(), name) {
+					t.Errorf("%s never reported, got %q", name, packing.entries())
+				}
+			}
+
+			info, err := os.Stat(archive)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var unpacking progressLog
+			if err := format.decode(archive, filepath.Join(dir, "out"), unpacking.report); err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if format.name == "zip" {
+				// Zip counts the compressed size of each entry, leaving out
+				// headers and the central directory.
+				unpacking.check(t, unpacking.snapshots[0].Total)
+				if unpacking.snapshots[0].Total > info.Size() {
+					t.Errorf("unpack total %d exceeds the archive's %d bytes", unpacking.snapshots[0].Total, info.Size())
+				}
+			} else {
+				unpacking.check(t, info.Size())
+			}
+		})
+	}
+}
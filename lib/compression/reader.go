@@ -0,0 +1,146 @@
+package compression
+
+import (
+	"archive/tar"
+	"archivist/lib/encryption"
+	"filippo.io/age"
+	"fmt"
+	"hash"
+	"io"
+	"iter"
+)
+
+// Entry is a member read by an ArchiveReader. Zip members are described by
+// the tar header they convert to, so every format reads alike: a symlink's
+// target is in Linkname, and a recorded manifest digest in PAXRecords.
+// Names are as stored; sanitize them before using them as paths.
+type Entry struct {
+	*tar.Header
+}
+
+// Digest returns the manifest digest recorded for the entry, or "".
+func (e *Entry) Digest() string {
+	return e.PAXRecords[PAXDigestKey]
+}
+
+// Entries iterates over the entries left in r. The body of each entry is
+// read from r before the loop moves on. An error ends the iteration after
+// it is yielded.
+func Entries(r ArchiveReader) iter.Seq2[*Entry, error] {
+	return func(yield func(*Entry, error) bool) {
+		for {
+			entry, err := r.Next()
+			if err == io.EOF {
+				return
+			}
+			if !yield(entry, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Files is Entries restricted to regular files.
+func Files(r ArchiveReader) iter.Seq2[*Entry, error] {
+	return func(yield func(*Entry, error) bool) {
+		for entry, err := range Entries(r) {
+			if err == nil && entry.Typeflag != tar.TypeReg {
+				continue
+			}
+			if !yield(entry, err) {
+				return
+			}
+		}
+	}
+}
+
+// TarReader is the ArchiveReader of the tar-based formats.
+type TarReader struct {
+	file      *ArchiveFile
+	tarReader *tar.Reader
+	verify    bool
+	body      io.Reader
+}
+
+// OpenTarReader opens the tar archive at path, decrypting it with
+// identities and decompressing it with decompress. With verify, reading a
+// file's body to the end checks it against its manifest digest.
+func OpenTarReader(path string, identities []age.Identity, decompress func(io.Reader) (io.Reader, error), verify bool) (*TarReader, error) {
+	file, err := OpenArchive(path)
+	if err != nil {
+		return nil, err
+	}
+
+	src, err := encryption.Decrypt(file, identities)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	src, err = decompress(src)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &TarReader{file: file, tarReader: tar.NewReader(src), verify: verify}, nil
+}
+
+// Next advances to the next entry, skipping the rest of the current one.
+func (t *TarReader) Next() (*Entry, error) {
+	t.body = nil
+
+	header, err := t.tarReader.Next()
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tar header: %w", err)
+	}
+
+	entry := &Entry{Header: header}
+	t.body = t.tarReader
+	if t.verify && header.Typeflag == tar.TypeReg {
+		t.body = VerifyReader(t.tarReader, entry)
+	}
+
+	return entry, nil
+}
+
+// Read reads the body of the current entry.
+func (t *TarReader) Read(p []byte) (int, error) {
+	if t.body == nil {
+		return 0, io.EOF
+	}
+	return t.body.Read(p)
+}
+
+// Close closes the archive.
+func (t *TarReader) Close() error {
+	return t.file.Close()
+}
+
+// VerifyReader wraps the body of entry, failing the read that reaches its
+// end when the body doesn't match the entry's manifest digest.
+func VerifyReader(r io.Reader, entry *Entry) io.Reader {
+	return &verifyReader{r: r, digest: NewDigest(), entry: entry}
+}
+
+type verifyReader struct {
+	r      io.Reader
+	digest hash.Hash
+	entry  *Entry
+}
+
+func (v *verifyReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.digest.Write(p[:n])
+
+	if err == io.EOF {
+		if err := CheckDigest(v.digest, v.entry.Digest()); err != nil {
+			return n, fmt.Errorf("failed to verify %s: %w", v.entry.Name, err)
+		}
+	}
+
+	return n, err
+}
@@ -0,0 +1,208 @@
+package compression_test
+
+import (
+	"archive/tar"
+	"archivist/lib/compression"
+	tarformat "archivist/lib/compression/tar"
+	"archivist/lib/compression/tar_bz2"
+	"archivist/lib/compression/tar_gz"
+	"archivist/lib/compression/tar_xz"
+	zipformat "archivist/lib/compression/zip"
+	"bytes"
+	"errors"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// readFiles reads the regular files of an archive through its ArchiveReader.
+func readFiles(t *testing.T, opener compression.Opener) (map[string]string, error) {
+	t.Helper()
+
+	reader, err := opener.Open()
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer reader.Close()
+
+	files := map[string]string{}
+	for entry, err := range compression.Files(reader) {
+		if err != nil {
+			return files, err
+		}
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			return files, err
+		}
+		files[entry.Name] = string(data)
+	}
+	return files, nil
+}
+
+func TestArchiveReader(t *testing.T) {
+	formats := []struct {
+		name   string
+		encode func(path, src string) error
+		open   func(path string, verify bool) compression.Opener
+	}{
+		{
+			name: "tar",
+			encode: func(path, src string) error {
+				return (&tarformat.EncodeDecoder{OutputPath: path, Manifest: true}).Encode([]string{src})
+			},
+			open: func(path string, verify bool) compression.Opener {
+				return &tarformat.EncodeDecoder{OutputPath: path, VerifyManifest: verify}
+			},
+		},
+		{
+			name: "tar.gz",
+			encode: func(path, src string) error {
+				return (&tar_gz.EncodeDecoder{OutputPath: path, Manifest: true}).Encode([]string{src})
+			},
+			open: func(path string, verify bool) compression.Opener {
+				return &tar_gz.EncodeDecoder{OutputPath: path, VerifyManifest: verify}
+			},
+		},
+		{
+			name: "tar.bz2",
+			encode: func(path, src string) error {
+				return (&tar_bz2.EncodeDecoder{OutputPath: path, Manifest: true}).Encode([]string{src})
+			},
+			open: func(path string, verify bool) compression.Opener {
+				return &tar_bz2.EncodeDecoder{OutputPath: path, VerifyManifest: verify}
+			},
+		},
+		{
+			name: "tar.xz",
+			encode: func(path, src string) error {
+				return (&tar_xz.EncodeDecoder{OutputPath: path, Manifest: true}).Encode([]string{src})
+			},
+			open: func(path string, verify bool) compression.Opener {
+				return &tar_xz.EncodeDecoder{OutputPath: path, VerifyManifest: verify}
+			},
+		},
+		{
+			name: "zip",
+			encode: func(path, src string) error {
+				return (&zipformat.EncodeDecoder{OutputPath: path, Manifest: true}).Encode([]string{src})
+			},
+			open: func(path string, verify bool) compression.Opener {
+				return &zipformat.EncodeDecoder{OutputPath: path, VerifyManifest: verify}
+			},
+		},
+	}
+
+	files := map[string]string{
+		"src/a.txt":     "alpha",
+		"src/sub/b.txt": "bravo",
+		"src/empty":     "",
+	}
+
+	for _, format := range formats {
+		t.Run(format.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTree(t, dir, files)
+			archive := filepath.Join(dir, "src."+format.name)
+			if err := format.encode(archive, filepath.Join(dir, "src")); err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+
+			got, err := readFiles(t, format.open(archive, true))
+			if err != nil {
+				t.Fatalf("reading with VerifyManifest: %v", err)
+			}
+			if !maps.Equal(got, files) {
+				t.Errorf("read %q, want %q", got, files)
+			}
+
+			// Every entry carries its digest, and the manifest itself isn't
+			// an entry. Bodies left unread are skipped.
+			reader, err := format.open(archive, false).Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer reader.Close()
+			var dirs int
+			for entry, err := range compression.Entries(reader) {
+				if err != nil {
+					t.Fatal(err)
+				}
+				switch {
+				case entry.Name == compression.ManifestName:
+					t.Errorf("%s returned as an entry", entry.Name)
+				case entry.Typeflag == tar.TypeDir:
+					dirs++
+				case entry.Digest() == "":
+					t.Errorf("%s has no digest", entry.Name)
+				}
+			}
+			if format.name != "zip" && dirs != 2 {
+				t.Errorf("read %d directories, want src and src/sub", dirs)
+			}
+		})
+	}
+}
+
+// Stopping a range over Entries early leaves the reader usable.
+func TestEntriesBreak(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"src/a": "a", "src/b": "b", "src/c": "c"})
+	archive := filepath.Join(dir, "src.tar")
+	if err := tarformat.New(archive).Encode([]string{filepath.Join(dir, "src")}); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := tarformat.New(archive).Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	for entry, err := range compression.Files(reader) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.Name == "src/a" {
+			break
+		}
+	}
+	entry, err := reader.Next()
+	if err != nil || entry.Name != "src/b" {
+		t.Errorf("Next after break = %v, %v; want src/b", entry, err)
+	}
+}
+
+// With VerifyManifest, a body that doesn't match its digest fails the read
+// that reaches its end, and a zip without a manifest can't be opened.
+func TestArchiveReaderVerify(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"src/a": string(content)})
+	archive := filepath.Join(dir, "src.tar")
+	if err := (&tarformat.EncodeDecoder{OutputPath: archive, Manifest: true}).Encode([]string{filepath.Join(dir, "src")}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flipByte(t, archive, int64(bytes.Index(data, content)+10))
+
+	if _, err := readFiles(t, &tarformat.EncodeDecoder{OutputPath: archive}); err != nil {
+		t.Errorf("reading without VerifyManifest: %v", err)
+	}
+	if _, err := readFiles(t, &tarformat.EncodeDecoder{OutputPath: archive, VerifyManifest: true}); !errors.Is(err, compression.ErrDigestMismatch) {
+		t.Errorf("reading a damaged member = %v, want %v", err, compression.ErrDigestMismatch)
+	}
+
+	zipPath := filepath.Join(dir, "src.zip")
+	if err := zipformat.New(zipPath).Encode([]string{filepath.Join(dir, "src")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&zipformat.EncodeDecoder{OutputPath: zipPath, VerifyManifest: true}).Open(); !errors.Is(err, compression.ErrNoDigest) {
+		t.Errorf("Open of a zip without a manifest = %v, want %v", err, compression.ErrNoDigest)
+	}
+}
@@ -0,0 +1,72 @@
+package compression
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// SourceDateEpoch returns the time set by the SOURCE_DATE_EPOCH environment
+// variable (https://reproducible-builds.org/specs/source-date-epoch/), or
+// nil when it is unset.
+func SourceDateEpoch() (*time.Time, error) {
+	value := os.Getenv("SOURCE_DATE_EPOCH")
+	if value == "" {
+		return nil, nil
+	}
+
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SOURCE_DATE_EPOCH %q: %w", value, err)
+	}
+
+	epoch := time.Unix(seconds, 0).UTC()
+	return &epoch, nil
+}
+
+// SortedSources returns a sorted copy of sourcePaths. filepath.Walk already
+// visits each tree in lexical order, so this fixes the order of the trees.
+func SortedSources(sourcePaths []string) []string {
+	sorted := append([]string(nil), sourcePaths...)
+	sort.Strings(sorted)
+	return sorted
+}
+
+// NormalizeTarHeader strips the metadata that differs between otherwise
+// identical trees: ownership, access and change times, permission noise and,
+// with an epoch, modification times newer than it.
+func NormalizeTarHeader(header *tar.Header, epoch *time.Time) {
+	header.Uid, header.Gid = 0, 0
+	header.Uname, header.Gname = "", ""
+	header.AccessTime, header.ChangeTime = time.Time{}, time.Time{}
+	header.Mode = int64(normalizeMode(fs.FileMode(header.Mode), header.Typeflag == tar.TypeDir))
+	header.ModTime = clampTime(header.ModTime, epoch)
+}
+
+// NormalizeZipHeader is the zip counterpart of NormalizeTarHeader.
+func NormalizeZipHeader(header *zip.FileHeader, epoch *time.Time) {
+	mode := header.Mode()
+	header.SetMode(mode.Type() | normalizeMode(mode.Perm(), mode.IsDir()))
+	header.Modified = clampTime(header.Modified, epoch)
+}
+
+// normalizeMode maps permissions to 0755 for directories and executables
+// and to 0644 for everything else.
+func normalizeMode(perm fs.FileMode, isDir bool) fs.FileMode {
+	if isDir || perm&0111 != 0 {
+		return 0755
+	}
+	return 0644
+}
+
+func clampTime(t time.Time, epoch *time.Time) time.Time {
+	if epoch != nil && t.After(*epoch) {
+		t = *epoch
+	}
+	return t.Truncate(time.Second).UTC()
+}
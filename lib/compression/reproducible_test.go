@@ -0,0 +1,103 @@
+package compression_test
+
+import (
+	"archivist/lib/compression/tar_gz"
+	"archivist/lib/compression/tar_xz"
+	zipformat "archivist/lib/compression/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// reproducibleTree writes the same files under dir/src each time, with
+// modification times after the epoch that differ between runs and, when
+// the test may change ownership, owned by owner.
+func reproducibleTree(t *testing.T, dir string, mtime time.Time, perm os.FileMode, owner int) string {
+	t.Helper()
+
+	writeTree(t, dir, map[string]string{
+		"src/a.txt":       "alpha",
+		"src/sub/b.txt":   "bravo",
+		"src/sub/c/d.txt": "delta",
+	})
+
+	src := filepath.Join(dir, "src")
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			if err := os.Chmod(path, perm); err != nil {
+				return err
+			}
+		}
+		if owner >= 0 {
+			if err := os.Lchown(path, owner, owner); err != nil {
+				return err
+			}
+		}
+		return os.Chtimes(path, mtime, mtime)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return src
+}
+
+func TestReproducible(t *testing.T) {
+	t.Setenv("SOURCE_DATE_EPOCH", "1577836800") // 2020-01-01
+
+	formats := []struct {
+		name   string
+		encode func(path, src string, threads int) error
+	}{
+		{"tar.gz", func(path, src string, threads int) error {
+			return (&tar_gz.EncodeDecoder{OutputPath: path, Reproducible: true, Threads: threads}).Encode([]string{src})
+		}},
+		{"tar.xz", func(path, src string, threads int) error {
+			return (&tar_xz.EncodeDecoder{OutputPath: path, Reproducible: true, Threads: threads}).Encode([]string{src})
+		}},
+		{"zip", func(path, src string, threads int) error {
+			return (&zipformat.EncodeDecoder{OutputPath: path, Reproducible: true, Threads: threads}).Encode([]string{src})
+		}},
+	}
+
+	// Changing ownership needs root; otherwise both trees keep the owner
+	// running the test.
+	owners := [2]int{-1, -1}
+	if os.Geteuid() == 0 {
+		owners = [2]int{0, 4242}
+	}
+
+	dir := t.TempDir()
+	first := reproducibleTree(t, filepath.Join(dir, "1"), time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC), 0644, owners[0])
+	second := reproducibleTree(t, filepath.Join(dir, "2"), time.Date(2025, 7, 3, 8, 30, 0, 0, time.Local), 0640, owners[1])
+
+	for _, format := range formats {
+		t.Run(format.name, func(t *testing.T) {
+			firstPath := filepath.Join(dir, "1", "src."+format.name)
+			secondPath := filepath.Join(dir, "2", "src."+format.name)
+			if err := format.encode(firstPath, first, 1); err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+			if err := format.encode(secondPath, second, 4); err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+
+			firstData, err := os.ReadFile(firstPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			secondData, err := os.ReadFile(secondPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(firstData, secondData) {
+				t.Errorf("archives of the same tree differ: %d and %d bytes", len(firstData), len(secondData))
+			}
+		})
+	}
+}
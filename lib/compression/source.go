@@ -0,0 +1,150 @@
+package compression
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Source is a file to archive and the member name it is stored under. It
+// is read from disk at Path or, when FS is set, from Path inside FS.
+type Source struct {
+	FS   fs.FS
+	Path string
+	Name string
+	Info fs.FileInfo
+}
+
+// readLinkFS is implemented by file systems that can hold symlinks, such as
+// os.DirFS and the archive file systems.
+type readLinkFS interface {
+	ReadLink(name string) (string, error)
+}
+
+// Open opens the source for reading.
+func (s Source) Open() (io.ReadCloser, error) {
+	var file io.ReadCloser
+	var err error
+	if s.FS == nil {
+		file, err = os.Open(s.Path)
+	} else {
+		file, err = s.FS.Open(s.Path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file %s: %w", s.Path, err)
+	}
+
+	return file, nil
+}
+
+// Readlink returns the target of a symlink source.
+func (s Source) Readlink() (string, error) {
+	var target string
+	var err error
+	if fsys, ok := s.FS.(readLinkFS); ok {
+		target, err = fsys.ReadLink(s.Path)
+	} else if s.FS == nil {
+		target, err = os.Readlink(s.Path)
+	} else {
+		err = fmt.Errorf("file system can't read symlinks")
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read symlink %s: %w", s.Path, err)
+	}
+
+	return target, nil
+}
+
+// CollectSources walks sourcePaths the way the encoders do, naming every
+// file relative to the parent of its source.
+func CollectSources(sourcePaths []string) ([]Source, error) {
+	var sources []Source
+
+	for _, source := range sourcePaths {
+		err := filepath.Walk(source, func(filePath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return fmt.Errorf("error walking through %s: %w", filePath, err)
+			}
+
+			relPath, err := filepath.Rel(filepath.Dir(source), filePath)
+			if err != nil {
+				return fmt.Errorf("failed to get relative path for %s: %w", filePath, err)
+			}
+
+			sources = append(sources, Source{
+				Path: filePath,
+				Name: strings.ReplaceAll(relPath, string(os.PathSeparator), "/"),
+				Info: info,
+			})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return sources, nil
+}
+
+// CollectFS is CollectSources for roots inside fsys. The root "." stands
+// for the whole file system, whose members keep their own names.
+func CollectFS(fsys fs.FS, roots []string) ([]Source, error) {
+	var sources []Source
+
+	for _, root := range roots {
+		parent := path.Dir(root)
+
+		err := fs.WalkDir(fsys, root, func(filePath string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return fmt.Errorf("error walking through %s: %w", filePath, err)
+			}
+
+			name := filePath
+			if root == "." {
+				if filePath == "." {
+					return nil
+				}
+			} else if parent != "." {
+				name = strings.TrimPrefix(filePath, parent+"/")
+			}
+
+			info, err := entry.Info()
+			if err != nil {
+				return fmt.Errorf("failed to stat %s: %w", filePath, err)
+			}
+
+			sources = append(sources, Source{FS: fsys, Path: filePath, Name: name, Info: info})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return sources, nil
+}
+
+// TarHeader builds the tar header for source, reading the target of
+// symlinks.
+func TarHeader(source Source) (*tar.Header, error) {
+	link := ""
+	if source.Info.Mode()&fs.ModeSymlink != 0 {
+		var err error
+		if link, err = source.Readlink(); err != nil {
+			return nil, err
+		}
+	}
+
+	header, err := tar.FileInfoHeader(source.Info, link)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tar header for %s: %w", source.Path, err)
+	}
+	header.Name = source.Name
+
+	return header, nil
+}
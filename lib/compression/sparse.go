@@ -0,0 +1,243 @@
+package compression
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+)
+
+// sparseBlockSize is the granularity at which SparseWriter leaves zeros
+// out as holes, the usual file system block size.
+const sparseBlockSize = 4096
+
+// PAX records of the GNU 1.0 sparse format, which the standard library
+// reads but doesn't write.
+const (
+	paxSparseMajor    = "GNU.sparse.major"
+	paxSparseMinor    = "GNU.sparse.minor"
+	paxSparseName     = "GNU.sparse.name"
+	paxSparseRealSize = "GNU.sparse.realsize"
+	paxSparseMap      = "GNU.sparse.map"
+)
+
+// sparseRegion is a region of a sparse file that holds data.
+type sparseRegion struct {
+	Offset int64
+	Length int64
+}
+
+// sparseFile is the body of a file with holes, of which only the data
+// regions are archived.
+type sparseFile struct {
+	*os.File
+	data []sparseRegion
+}
+
+// IsSparse reports whether header is that of a sparse file, whose holes
+// the tar reader fills with zeros.
+func IsSparse(header *tar.Header) bool {
+	return header.PAXRecords[paxSparseMajor] != "" || header.PAXRecords[paxSparseMap] != ""
+}
+
+// writeSparse writes a sparse file entry in the PAX 1.0 format of GNU tar:
+// an extended header naming the file, then a regular entry whose data is
+// the map of the data regions followed by their contents.
+func writeSparse(w io.Writer, header *tar.Header, body *sparseFile, tracker *Tracker) error {
+	var sparseMap []byte
+	sparseMap = append(strconv.AppendInt(sparseMap, int64(len(body.data)), 10), '\n')
+	var data int64
+	for _, region := range body.data {
+		sparseMap = append(strconv.AppendInt(sparseMap, region.Offset, 10), '\n')
+		sparseMap = append(strconv.AppendInt(sparseMap, region.Length, 10), '\n')
+		data += region.Length
+	}
+	sparseMap = append(sparseMap, make([]byte, tarPadding(int64(len(sparseMap))))...)
+	stored := int64(len(sparseMap)) + data
+
+	records := map[string]string{
+		paxSparseMajor:    "1",
+		paxSparseMinor:    "0",
+		paxSparseName:     header.Name,
+		paxSparseRealSize: strconv.FormatInt(header.Size, 10),
+	}
+	for key, value := range header.PAXRecords {
+		records[key] = value
+	}
+
+	dir, file := path.Split(header.Name)
+	stub := *header
+	stub.Name = path.Join(dir, "GNUSparseFile.0", file)
+	stub.Size = stored
+
+	if _, err := w.Write(sparseHeaders(header.Name, &stub, records)); err != nil {
+		return err
+	}
+	if _, err := w.Write(sparseMap); err != nil {
+		return err
+	}
+
+	for _, region := range body.data {
+		src := tracker.Reader(io.NewSectionReader(body, region.Offset, region.Length))
+		if n, err := io.Copy(w, src); err != nil {
+			return err
+		} else if n < region.Length {
+			return fmt.Errorf("file shrank while being read")
+		}
+	}
+	tracker.Add(header.Size - data)
+
+	_, err := w.Write(make([]byte, tarPadding(stored)))
+	return err
+}
+
+// sparseHeaders encodes the extended header of the sparse file name,
+// holding records, followed by the ustar header of header, its stub entry.
+// Fields the ustar header can't hold move to records.
+func sparseHeaders(name string, header *tar.Header, records map[string]string) []byte {
+	var block [tarBlockSize]byte
+
+	numeric := func(field []byte, value int64, key string) {
+		if value >= 0 && value < 1<<(3*(len(field)-1)) {
+			copy(field, fmt.Sprintf("%0*o", len(field)-1, value))
+		} else {
+			copy(field, fmt.Sprintf("%0*o", len(field)-1, 0))
+			records[key] = strconv.FormatInt(value, 10)
+		}
+	}
+	text := func(field []byte, value string, key string) {
+		if len(value) <= len(field) && isASCII(value) {
+			copy(field, value)
+		} else {
+			records[key] = value
+		}
+	}
+
+	text(block[0:100], header.Name, "path")
+	copy(block[100:108], fmt.Sprintf("%07o", header.Mode&07777777))
+	numeric(block[108:116], int64(header.Uid), "uid")
+	numeric(block[116:124], int64(header.Gid), "gid")
+	numeric(block[124:136], header.Size, "size")
+	numeric(block[136:148], header.ModTime.Unix(), "mtime")
+	block[156] = tar.TypeReg
+	text(block[265:297], header.Uname, "uname")
+	text(block[297:329], header.Gname, "gname")
+
+	var data bytes.Buffer
+	for _, key := range slices.Sorted(maps.Keys(records)) {
+		data.WriteString(paxRecord(key, records[key]))
+	}
+
+	// Readers ignore the name of the extended header itself, so it is cut
+	// to fit, as archive/tar does.
+	dir, file := path.Split(name)
+	var extended [tarBlockSize]byte
+	copy(extended[0:100], path.Join(dir, "PaxHeaders.0", file))
+	copy(extended[100:108], "0000644")
+	copy(extended[108:116], "0000000")
+	copy(extended[116:124], "0000000")
+	copy(extended[124:136], fmt.Sprintf("%011o", data.Len()))
+	copy(extended[136:148], "00000000000")
+	extended[156] = tar.TypeXHeader
+
+	out := make([]byte, 0, 2*tarBlockSize+data.Len()+tarBlockSize)
+	out = append(out, ustarBlock(extended)...)
+	out = append(out, data.Bytes()...)
+	out = append(out, make([]byte, tarPadding(int64(data.Len())))...)
+	return append(out, ustarBlock(block)...)
+}
+
+// ustarBlock stamps a header block with the ustar magic and its checksum.
+func ustarBlock(block [tarBlockSize]byte) []byte {
+	copy(block[257:265], "ustar\x0000")
+
+	copy(block[148:156], "        ")
+	sum := 0
+	for _, b := range block {
+		sum += int(b)
+	}
+	copy(block[148:156], fmt.Sprintf("%06o\x00 ", sum))
+
+	return block[:]
+}
+
+// paxRecord formats a record as "length key=value\n", the length counting
+// its own digits.
+func paxRecord(key, value string) string {
+	size := len(key) + len(value) + 3
+	size += len(strconv.Itoa(size))
+	record := strconv.Itoa(size) + " " + key + "=" + value + "\n"
+	if len(record) != size {
+		record = strconv.Itoa(len(record)) + " " + key + "=" + value + "\n"
+	}
+	return record
+}
+
+func isASCII(s string) bool {
+	for i := range len(s) {
+		if s[i] >= 0x80 || s[i] == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// SparseWriter writes an extracted sparse file into a new, empty file,
+// leaving blocks of zeros unwritten so that they stay holes. Close sets the
+// final size, which a trailing hole doesn't reach. A nil SparseWriter
+// closes without doing anything.
+type SparseWriter struct {
+	file   *os.File
+	offset int64
+}
+
+// NewSparseWriter returns a SparseWriter writing file from its start.
+func NewSparseWriter(file *os.File) *SparseWriter {
+	return &SparseWriter{file: file}
+}
+
+func (w *SparseWriter) Write(p []byte) (int, error) {
+	written := 0
+
+	for len(p) > 0 {
+		chunk := p[:min(int64(len(p)), sparseBlockSize-w.offset%sparseBlockSize)]
+		if !isZero(chunk) {
+			if _, err := w.file.WriteAt(chunk, w.offset); err != nil {
+				return written, err
+			}
+		}
+
+		w.offset += int64(len(chunk))
+		written += len(chunk)
+		p = p[len(chunk):]
+	}
+
+	return written, nil
+}
+
+// Close extends the file to the size written.
+func (w *SparseWriter) Close() error {
+	if w == nil {
+		return nil
+	}
+
+	if err := w.file.Truncate(w.offset); err != nil {
+		return fmt.Errorf("failed to set size of %s: %w", w.file.Name(), err)
+	}
+
+	return nil
+}
+
+func isZero(p []byte) bool {
+	for _, b := range p {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
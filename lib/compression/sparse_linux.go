@@ -0,0 +1,47 @@
+package compression
+
+import (
+	"errors"
+	"golang.org/x/sys/unix"
+	"io"
+	"os"
+)
+
+// dataRegions returns the regions of the first size bytes of file that
+// hold data, found with SEEK_DATA and SEEK_HOLE. It returns nil when the
+// file has no holes, or when they can't be found.
+func dataRegions(file *os.File, size int64) []sparseRegion {
+	defer file.Seek(0, io.SeekStart)
+
+	var regions []sparseRegion
+	for offset := int64(0); offset < size; {
+		start, err := file.Seek(offset, unix.SEEK_DATA)
+		if errors.Is(err, unix.ENXIO) || err == nil && start >= size {
+			// The rest of the file is a hole.
+			break
+		}
+		if err != nil {
+			return nil
+		}
+
+		end, err := file.Seek(start, unix.SEEK_HOLE)
+		if err != nil {
+			return nil
+		}
+		end = min(end, size)
+
+		regions = append(regions, sparseRegion{Offset: start, Length: end - start})
+		offset = end
+	}
+
+	if size == 0 || len(regions) == 1 && regions[0].Length == size {
+		return nil
+	}
+
+	// A trailing hole ends with an empty region, which GNU tar needs to
+	// restore the full size.
+	if len(regions) == 0 || regions[len(regions)-1].Offset+regions[len(regions)-1].Length < size {
+		regions = append(regions, sparseRegion{Offset: size})
+	}
+	return regions
+}
@@ -0,0 +1,46 @@
+package compression
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// DecodeStaged extracts into a staging directory next to outputDir and swaps
+// it in only once decoding has succeeded, so outputDir holds either its old
+// contents or the complete new tree, never a partial extraction. The old
+// contents are removed after the swap, so overwrite policies don't apply.
+func DecodeStaged(decoder Decoder, outputDir string) error {
+	outputDir = filepath.Clean(outputDir)
+	parent := filepath.Dir(outputDir)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", parent, err)
+	}
+
+	staging, err := os.MkdirTemp(parent, "."+filepath.Base(outputDir)+".staging-*")
+	if err != nil {
+		return fmt.Errorf("failed to create staging directory for %s: %w", outputDir, err)
+	}
+
+	if err := decoder.Decode(staging); err != nil {
+		os.RemoveAll(staging)
+		return err
+	}
+
+	if err := os.Chmod(staging, 0755); err != nil {
+		os.RemoveAll(staging)
+		return fmt.Errorf("failed to set permissions of %s: %w", staging, err)
+	}
+
+	if err := swapDir(staging, outputDir); err != nil {
+		os.RemoveAll(staging)
+		return err
+	}
+
+	// After the swap the staging path holds the previous tree, if any.
+	if err := os.RemoveAll(staging); err != nil {
+		return fmt.Errorf("failed to remove previous %s: %w", outputDir, err)
+	}
+
+	return syncDir(parent)
+}
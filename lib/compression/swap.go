@@ -0,0 +1,22 @@
+package compression
+
+import (
+	"fmt"
+	"os"
+)
+
+// renameSwap exchanges staging and the existing target with two renames,
+// for systems without an atomic exchange. The target is moved aside first,
+// leaving a brief window where it is absent.
+func renameSwap(staging, target string) error {
+	previous := staging + ".old"
+	if err := os.Rename(target, previous); err != nil {
+		return fmt.Errorf("failed to move %s aside: %w", target, err)
+	}
+	if err := os.Rename(staging, target); err != nil {
+		os.Rename(previous, target)
+		return fmt.Errorf("failed to move %s into place: %w", target, err)
+	}
+
+	return os.Rename(previous, staging)
+}
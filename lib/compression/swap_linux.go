@@ -0,0 +1,28 @@
+package compression
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"io/fs"
+	"os"
+)
+
+// swapDir moves staging to target. An existing target is exchanged with
+// staging in a single renameat2(RENAME_EXCHANGE) call, or with two renames
+// on kernels and file systems that don't support it.
+func swapDir(staging, target string) error {
+	if _, err := os.Lstat(target); errors.Is(err, fs.ErrNotExist) {
+		return os.Rename(staging, target)
+	}
+
+	err := unix.Renameat2(unix.AT_FDCWD, staging, unix.AT_FDCWD, target, unix.RENAME_EXCHANGE)
+	if errors.Is(err, unix.EINVAL) || errors.Is(err, unix.ENOSYS) {
+		return renameSwap(staging, target)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to swap %s into place: %w", target, err)
+	}
+
+	return nil
+}
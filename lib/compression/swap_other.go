@@ -0,0 +1,19 @@
+//go:build !linux
+
+package compression
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+)
+
+// swapDir moves staging to target. Without an atomic exchange the existing
+// target is moved aside first; the previous tree ends up at the staging path.
+func swapDir(staging, target string) error {
+	if _, err := os.Lstat(target); errors.Is(err, fs.ErrNotExist) {
+		return os.Rename(staging, target)
+	}
+
+	return renameSwap(staging, target)
+}
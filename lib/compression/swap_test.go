@@ -0,0 +1,45 @@
+package compression
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testSwap swaps a staging tree into an existing target with swap.
+func testSwap(t *testing.T, swap func(staging, target string) error) {
+	dir := t.TempDir()
+	staging := filepath.Join(dir, ".out.staging")
+	target := filepath.Join(dir, "out")
+	for path, name := range map[string]string{staging: "new", target: "old"} {
+		if err := os.MkdirAll(path, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(path, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := swap(staging, target); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(target, "new")); err != nil {
+		t.Errorf("new tree isn't at the target: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(staging, "old")); err != nil {
+		t.Errorf("previous tree isn't at the staging path: %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Errorf("%d entries left in %s, want the target and the staging path", len(entries), dir)
+	}
+}
+
+func TestSwapDir(t *testing.T) {
+	testSwap(t, swapDir)
+}
+
+// renameSwap is the fallback where the kernel can't exchange directories.
+func TestRenameSwap(t *testing.T) {
+	testSwap(t, renameSwap)
+}
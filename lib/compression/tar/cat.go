@@ -0,0 +1,41 @@
+package tar
+
+import (
+	"archive/tar"
+	"archivist/lib/compression"
+	"fmt"
+	"io"
+)
+
+// Cat streams member to w. Members replaced by add or update stay in the
+// archive, so unencrypted archives are scanned first to find the last copy,
+// the one extraction leaves behind. The scan seeks over entry data.
+func (ed *EncodeDecoder) Cat(member string, w io.Writer) error {
+	format, err := compression.DetectFileFormat(ed.OutputPath)
+	if err != nil {
+		return err
+	}
+	if format == compression.FormatAge {
+		return compression.CatTarFile(ed.OutputPath, member, ed.Identities, ed.Decompress, w)
+	}
+
+	file, err := compression.OpenArchive(ed.OutputPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	copies, err := compression.CountTarMember(tar.NewReader(file), member)
+	if err != nil {
+		return err
+	}
+	if copies == 0 {
+		return fmt.Errorf("%w: %s", compression.ErrMemberNotFound, member)
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek in %s: %w", ed.OutputPath, err)
+	}
+
+	return compression.CatTar(tar.NewReader(file), member, copies-1, w)
+}
@@ -0,0 +1,13 @@
+package tar
+
+import "archivist/lib/compression"
+
+// OpenFS indexes the archive as a read-only file system, reading members
+// straight from the archive file unless it is encrypted.
+func (ed *EncodeDecoder) OpenFS() (compression.ArchiveFS, error) {
+	fsys, err := compression.OpenTarFS(ed.OutputPath, ed.Identities, ed.Decompress)
+	if err != nil {
+		return nil, err
+	}
+	return fsys, nil
+}
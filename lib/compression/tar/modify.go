@@ -0,0 +1,34 @@
+package tar
+
+import (
+	"archivist/lib/compression"
+	"io"
+)
+
+// Modify appends added files in place. Deleting members rewrites the
+// archive.
+func (ed *EncodeDecoder) Modify(edit compression.Edit) error {
+	if len(edit.Delete) == 0 {
+		return compression.AppendTar(ed.OutputPath, edit, ed.Logger)
+	}
+
+	return compression.RewriteTar(ed.OutputPath, edit, ed.Decompress, ed.Compress, ed.Logger)
+}
+
+// Decompress returns r, since plain tar has no compression layer.
+func (ed *EncodeDecoder) Decompress(r io.Reader) (io.Reader, error) {
+	return r, nil
+}
+
+// Compress returns w with a no-op Close.
+func (ed *EncodeDecoder) Compress(w io.Writer) (io.WriteCloser, error) {
+	return nopWriteCloser{w}, nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
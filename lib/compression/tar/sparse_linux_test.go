@@ -0,0 +1,158 @@
+package tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"golang.org/x/sys/unix"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// allocated returns the bytes of disk space path takes.
+func allocated(t *testing.T, path string) int64 {
+	t.Helper()
+
+	var stat unix.Stat_t
+	if err := unix.Stat(path, &stat); err != nil {
+		t.Fatal(err)
+	}
+	return stat.Blocks * 512
+}
+
+// sparseSize is the size of the files writeSparse makes.
+const sparseSize = 5 << 20
+
+// writeSparse writes a file with holes at the start, between its two data
+// regions and at the end, returning its contents. It skips the test where
+// the file system doesn't report holes.
+func writeSparse(t *testing.T, path string) []byte {
+	t.Helper()
+
+	data := map[int64][]byte{
+		1 << 20: bytes.Repeat([]byte("first"), 1000),
+		3 << 20: bytes.Repeat([]byte("second"), 1000),
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for offset, chunk := range data {
+		if _, err := file.WriteAt(chunk, offset); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := file.Truncate(sparseSize); err != nil {
+		t.Fatal(err)
+	}
+	start, err := file.Seek(0, unix.SEEK_DATA)
+	file.Close()
+	if err != nil || start == 0 || allocated(t, path) >= sparseSize {
+		t.Skip("the file system doesn't report holes with SEEK_DATA and SEEK_HOLE")
+	}
+
+	want := make([]byte, sparseSize)
+	for offset, chunk := range data {
+		copy(want[offset:], chunk)
+	}
+	return want
+}
+
+// checkSparse packs the sparse file dir/src/name and checks its headers,
+// its extracted copy and its contents through OpenFS.
+func checkSparse(t *testing.T, dir, name string, want []byte) {
+	t.Helper()
+
+	archive := filepath.Join(dir, "src.tar")
+	if err := New(archive).Encode([]string{filepath.Join(dir, "src")}); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	f, err := os.Open(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	reader := tar.NewReader(f)
+	found := false
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.Name != name {
+			continue
+		}
+		found = true
+		if major, minor := header.PAXRecords["GNU.sparse.major"], header.PAXRecords["GNU.sparse.minor"]; major != "1" || minor != "0" {
+			t.Errorf("sparse format %s.%s, want 1.0", major, minor)
+		}
+		// Readers that don't know the sparse format see the stub entry.
+		stub := path.Join(path.Dir(name), "GNUSparseFile.0", path.Base(name))
+		if len(stub) > 100 && header.PAXRecords["path"] != stub {
+			t.Errorf("stub entry named %q, want %q", header.PAXRecords["path"], stub)
+		}
+		if header.Size != sparseSize {
+			t.Errorf("header size %d, want %d", header.Size, sparseSize)
+		}
+		if got, err := io.ReadAll(reader); err != nil || !bytes.Equal(got, want) {
+			t.Errorf("archive/tar reads %d bytes, %v; want the file with its holes", len(got), err)
+		}
+	}
+	if !found {
+		t.Fatalf("%s isn't in the archive", name)
+	}
+	if stored := allocated(t, archive); stored > 64<<10 {
+		t.Errorf("archive takes %d bytes, want the holes left out", stored)
+	}
+
+	out := filepath.Join(dir, "out")
+	if err := New(archive).Decode(out); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	extracted := filepath.Join(out, filepath.FromSlash(name))
+	got, err := os.ReadFile(extracted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("extracted file has the wrong contents")
+	}
+	if blocks := allocated(t, extracted); blocks > 64<<10 {
+		t.Errorf("extracted file takes %d bytes on disk, want the holes kept", blocks)
+	}
+
+	fsys, err := New(archive).OpenFS()
+	if err != nil {
+		t.Fatalf("OpenFS: %v", err)
+	}
+	defer fsys.Close()
+	if got, err := fs.ReadFile(fsys, name); err != nil || !bytes.Equal(got, want) {
+		t.Errorf("OpenFS reads %d bytes, %v; want the file with its holes", len(got), err)
+	}
+}
+
+func TestSparseRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	want := writeSparse(t, filepath.Join(dir, "src", "disk.img"))
+	checkSparse(t, dir, "src/disk.img", want)
+}
+
+// The name of the stub entry holding the data is longer than the file's,
+// and moves to a PAX record when the ustar header can't hold it.
+func TestSparseLongName(t *testing.T) {
+	dir := t.TempDir()
+	name := "src/" + strings.Repeat("d", 90) + "/disk.img"
+	want := writeSparse(t, filepath.Join(dir, filepath.FromSlash(name)))
+	checkSparse(t, dir, name, want)
+}
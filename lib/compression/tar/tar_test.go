@@ -0,0 +1,158 @@
+package tar
+
+import (
+	"archivist/lib/compression"
+	"archivist/lib/encryption"
+	"bytes"
+	"errors"
+	"filippo.io/age"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// interruptedExtraction packs three files and extracts them with Resume
+// until the last one, which is blocked by an existing file. It returns the
+// archive and the output directory holding the journal.
+func interruptedExtraction(t *testing.T) (string, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.Mkdir(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"f1", "f2", "f3"} {
+		data := bytes.Repeat([]byte(name), 5000)
+		if err := os.WriteFile(filepath.Join(src, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	archive := filepath.Join(dir, "src.tar")
+	if err := New(archive).Encode([]string{src}); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	out := filepath.Join(dir, "out")
+	blocker := filepath.Join(out, "src", "f3")
+	if err := os.MkdirAll(filepath.Dir(blocker), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(blocker, []byte("in the way"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ed := New(archive)
+	ed.Resume = true
+	ed.Extractor = &compression.Extractor{Overwrite: compression.OverwriteKeepOld}
+	if err := ed.Decode(out); !errors.Is(err, compression.ErrFileExists) {
+		t.Fatalf("Decode = %v, want %v", err, compression.ErrFileExists)
+	}
+	if err := os.Remove(blocker); err != nil {
+		t.Fatal(err)
+	}
+
+	return archive, out
+}
+
+// resume extracts archive into out again, returning the log.
+func resume(t *testing.T, archive, out string) string {
+	t.Helper()
+
+	var log bytes.Buffer
+	ed := New(archive)
+	ed.Resume = true
+	ed.Logger = slog.New(slog.NewTextHandler(&log, nil))
+	if err := ed.Decode(out); err != nil {
+		t.Fatalf("resumed Decode: %v", err)
+	}
+
+	for _, name := range []string{"f1", "f2", "f3"} {
+		data, err := os.ReadFile(filepath.Join(out, "src", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, bytes.Repeat([]byte(name), 5000)) {
+			t.Errorf("%s has the wrong contents", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(out, compression.JournalName)); !os.IsNotExist(err) {
+		t.Errorf("journal left behind after a complete run: %v", err)
+	}
+
+	return log.String()
+}
+
+func TestResumeSeeksPastExtractedFiles(t *testing.T) {
+	archive, out := interruptedExtraction(t)
+
+	log := resume(t, archive, out)
+	if strings.Contains(log, "src/f1") || strings.Contains(log, "src/f2") {
+		t.Errorf("resumed run read the files extracted before instead of seeking past them:\n%s", log)
+	}
+}
+
+func TestResumeRestoresDeletedFile(t *testing.T) {
+	archive, out := interruptedExtraction(t)
+	if err := os.Remove(filepath.Join(out, "src", "f1")); err != nil {
+		t.Fatal(err)
+	}
+
+	log := resume(t, archive, out)
+	for _, line := range strings.Split(log, "\n") {
+		if strings.Contains(line, "src/f2") && !strings.Contains(line, compression.LogDone) {
+			t.Errorf("resumed run extracted f2 again, though it is still in place:\n%s", log)
+		}
+	}
+}
+
+func TestEncryptedRoundTrip(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.Mkdir(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "secret.txt"), []byte("top secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	archive := filepath.Join(dir, "src.tar.age")
+	ed := New(archive)
+	ed.Recipients = []age.Recipient{identity.Recipient()}
+	if err := ed.Encode([]string{src}); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	data, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format, err := compression.DetectFormat(bytes.NewReader(data)); err != nil || format != compression.FormatAge {
+		t.Errorf("encrypted archive detected as %q, %v", format, err)
+	}
+	if bytes.Contains(data, []byte("top secret")) || bytes.Contains(data, []byte("secret.txt")) {
+		t.Error("encrypted archive holds plaintext")
+	}
+
+	if err := New(archive).Decode(filepath.Join(dir, "none")); !errors.Is(err, encryption.ErrNoIdentity) {
+		t.Errorf("Decode without an identity = %v, want %v", err, encryption.ErrNoIdentity)
+	}
+
+	out := filepath.Join(dir, "out")
+	ed = New(archive)
+	ed.Identities = []age.Identity{identity}
+	if err := ed.Decode(out); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if got, err := os.ReadFile(filepath.Join(out, "src", "secret.txt")); err != nil || string(got) != "top secret" {
+		t.Errorf("decrypted secret.txt = %q, %v", got, err)
+	}
+}
@@ -0,0 +1,31 @@
+package tar_bz2
+
+import (
+	"archivist/lib/compression"
+	"fmt"
+	"github.com/dsnet/compress/bzip2"
+	"io"
+)
+
+// Modify recompresses the archive in a single streaming pass.
+func (ed *EncodeDecoder) Modify(edit compression.Edit) error {
+	return compression.RewriteTar(ed.OutputPath, edit, ed.Decompress, ed.Compress, ed.Logger)
+}
+
+// Decompress wraps r in a bzip2 reader.
+func (ed *EncodeDecoder) Decompress(r io.Reader) (io.Reader, error) {
+	bz2Reader, err := bzip2.NewReader(r, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bzip2 reader: %w", err)
+	}
+	return bz2Reader, nil
+}
+
+// Compress wraps w in a bzip2 writer.
+func (ed *EncodeDecoder) Compress(w io.Writer) (io.WriteCloser, error) {
+	bz2Writer, err := bzip2.NewWriter(w, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bzip2 writer: %w", err)
+	}
+	return bz2Writer, nil
+}
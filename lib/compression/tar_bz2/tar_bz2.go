@@ -2,16 +2,43 @@ package tar_bz2
 
 import (
 	"archive/tar"
+	"archivist/lib/compression"
+	"archivist/lib/encryption"
+	"filippo.io/age"
 	"fmt"
 	"github.com/dsnet/compress/bzip2"
 	"io"
-	"os"
-	"path/filepath"
-	"strings"
+	"io/fs"
+	"log/slog"
 )
 
 type EncodeDecoder struct {
 	OutputPath string
+	// Recipients encrypt the archive with age when set.
+	Recipients []age.Recipient
+	// Identities decrypt age-encrypted archives.
+	Identities []age.Identity
+	// Manifest records a SHA-256 digest of every file as a PAX record.
+	Manifest bool
+	// Reproducible sorts sources, clamps mtimes to SOURCE_DATE_EPOCH and
+	// drops ownership and permission noise so identical trees give identical bytes.
+	Reproducible bool
+	// VerifyManifest checks extracted files against their recorded digests.
+	VerifyManifest bool
+	// SplitSize splits the archive into volumes of at most that many bytes,
+	// named OutputPath.001, OutputPath.002 and so on. Zero writes one file.
+	SplitSize int64
+	// Resume keeps a journal of the files extracted in the output directory,
+	// so that a Decode run after an interrupted one skips them.
+	Resume bool
+	// Progress, when set, receives the bytes packed or unpacked so far.
+	Progress compression.ProgressFunc
+	// Logger receives a record per entry and warnings about skipped
+	// entries. Nil discards them.
+	Logger *slog.Logger
+	// Extractor applies the overwrite and backup policy to existing files.
+	// Nil overwrites them.
+	Extractor *compression.Extractor
 }
 
 func New(outPaht string) *EncodeDecoder {
@@ -21,130 +48,107 @@ func New(outPaht string) *EncodeDecoder {
 }
 
 func (ed *EncodeDecoder) Encode(sourcePaths []string) error {
-	file, err := os.Create(ed.OutputPath)
+	if ed.Reproducible {
+		sourcePaths = compression.SortedSources(sourcePaths)
+	}
+
+	sources, err := compression.CollectSources(sourcePaths)
 	if err != nil {
-		return fmt.Errorf("failed to create file %s: %w", ed.OutputPath, err)
+		return err
 	}
-	defer file.Close()
 
-	bz2Writer, err := bzip2.NewWriter(file, nil) // nil для параметрів за замовчуванням
+	return ed.encode(sources)
+}
+
+// EncodeFS packs the trees under roots of fsys.
+func (ed *EncodeDecoder) EncodeFS(fsys fs.FS, roots []string) error {
+	if ed.Reproducible {
+		roots = compression.SortedSources(roots)
+	}
+
+	sources, err := compression.CollectFS(fsys, roots)
+	if err != nil {
+		return err
+	}
+
+	return ed.encode(sources)
+}
+
+func (ed *EncodeDecoder) encode(sources []compression.Source) error {
+	writer, err := ed.create(compression.TrackSources(ed.Progress, sources))
 	if err != nil {
-		return fmt.Errorf("failed to create bzip2 writer: %w", err)
+		return err
 	}
-	defer bz2Writer.Close()
-
-	tarWriter := tar.NewWriter(bz2Writer)
-	defer tarWriter.Close()
-
-	for _, source := range sourcePaths {
-		err = filepath.Walk(source, func(filePath string, info os.FileInfo, err error) error {
-			if err != nil {
-				return fmt.Errorf("error walking through %s: %w", filePath, err)
-			}
-
-			header, err := tar.FileInfoHeader(info, info.Name())
-			if err != nil {
-				return fmt.Errorf("failed to create tar header for %s: %w", filePath, err)
-			}
-
-			relPath, err := filepath.Rel(filepath.Dir(source), filePath)
-			if err != nil {
-				return fmt.Errorf("failed to get relative path for %s: %w", filePath, err)
-			}
-			header.Name = strings.ReplaceAll(relPath, string(os.PathSeparator), "/")
-
-			if err := tarWriter.WriteHeader(header); err != nil {
-				return fmt.Errorf("failed to write tar header for %s: %w", filePath, err)
-			}
-
-			if !info.IsDir() {
-				file, err := os.Open(filePath)
-				if err != nil {
-					return fmt.Errorf("failed to open file %s: %w", filePath, err)
-				}
-				defer file.Close()
-
-				_, err = io.Copy(tarWriter, file)
-				if err != nil {
-					return fmt.Errorf("failed to write file %s to tar.bz2: %w", filePath, err)
-				}
-			}
-
-			return nil
-		})
-
-		if err != nil {
+
+	for _, source := range sources {
+		if err := writer.AddSource(source); err != nil {
+			writer.Close()
 			return err
 		}
 	}
 
-	return nil
+	return writer.Close()
 }
 
-func (ed *EncodeDecoder) Decode(outputDir string) error {
-	if outputDir == "" {
-		return fmt.Errorf("output directory path is empty")
+// Create starts writing the archive, for adding entries one at a time.
+func (ed *EncodeDecoder) Create() (compression.ArchiveWriter, error) {
+	writer, err := ed.create(compression.NewTracker(ed.Progress, 0))
+	if err != nil {
+		return nil, err
 	}
+	return writer, nil
+}
 
-	if info, err := os.Stat(outputDir); err == nil && !info.IsDir() {
-		return fmt.Errorf("output path %s is a file, not a directory", outputDir)
-	}
+func (ed *EncodeDecoder) create(tracker *compression.Tracker) (*compression.TarWriter, error) {
+	return compression.NewTarWriter(ed.OutputPath, ed.Compress, compression.TarWriterOptions{
+		Recipients:   ed.Recipients,
+		Manifest:     ed.Manifest,
+		SplitSize:    ed.SplitSize,
+		Reproducible: ed.Reproducible,
+		Tracker:      tracker,
+		Logger:       ed.Logger,
+	})
+}
+
+// Decode extracts the archive into outputDir.
+func (ed *EncodeDecoder) Decode(outputDir string) error {
+	return compression.DecodeTar(ed.OutputPath, outputDir, ed.Decompress, compression.TarDecodeOptions{
+		Identities:     ed.Identities,
+		VerifyManifest: ed.VerifyManifest,
+		Resume:         ed.Resume,
+		Progress:       ed.Progress,
+		Logger:         ed.Logger,
+		Extractor:      ed.Extractor,
+	})
+}
 
-	if err := os.MkdirAll(outputDir, 0755); err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to create output directory %s: %v\n", outputDir, err)
-		return fmt.Errorf("failed to create output directory %s: %w", outputDir, err)
+// Test reads the whole archive without extracting it, checking tar headers,
+// entry sizes and the bzip2 stream checksums.
+func (ed *EncodeDecoder) Test() error {
+	file, err := compression.OpenArchive(ed.OutputPath)
+	if err != nil {
+		return err
 	}
+	defer file.Close()
 
-	file, err := os.Open(ed.OutputPath)
+	src, err := encryption.Decrypt(file, ed.Identities)
 	if err != nil {
-		return fmt.Errorf("failed to open archive %s: %w", ed.OutputPath, err)
+		return err
 	}
 
-	defer file.Close()
-	bz2Reader, err := bzip2.NewReader(file, nil)
+	bz2Reader, err := bzip2.NewReader(src, nil)
 	if err != nil {
 		return fmt.Errorf("failed to create bzip2 reader: %w", err)
 	}
 	defer bz2Reader.Close()
-	tarReader := tar.NewReader(bz2Reader)
-	for {
-		header, err := tarReader.Next()
-		if err != nil {
-			return fmt.Errorf("failed to read tar header: %w", err)
-		}
 
-		targetPath := filepath.Join(outputDir, header.Name)
-		targetPath = filepath.Clean(targetPath)
-		if strings.Contains(header.Name, "..") {
-			fmt.Fprintf(os.Stderr, "Skipping potentially unsafe path: %s\n", header.Name)
-			continue
-		}
-		if header.Typeflag == tar.TypeDir {
-			fmt.Printf("Creating directory: %s\n", targetPath)
-			if err := os.MkdirAll(targetPath, os.FileMode(header.Mode)); err != nil {
-				return fmt.Errorf("failed to create directory %s: %w", targetPath, err)
-			}
-			continue
-		}
-		if header.Typeflag == tar.TypeReg {
-			if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
-				return fmt.Errorf("failed to create parent directory for %s: %w", targetPath, err)
-			}
-			targetFile, err := os.OpenFile(targetPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(header.Mode))
-			if err != nil {
-				return fmt.Errorf("failed to create file %s: %w", targetPath, err)
-			}
-
-			_, err = io.Copy(targetFile, tarReader)
-			if err != nil {
-				targetFile.Close()
-				return fmt.Errorf("failed to write file %s: %w", targetPath, err)
-			}
-
-			if err := targetFile.Close(); err != nil {
-				return fmt.Errorf("failed to close file %s: %w", targetPath, err)
-			}
-		}
+	if err := compression.TestTar(tar.NewReader(bz2Reader)); err != nil {
+		return err
+	}
+
+	// The final block and stream CRCs are only checked once the stream hits EOF.
+	if _, err := io.Copy(io.Discard, bz2Reader); err != nil {
+		return fmt.Errorf("bzip2 stream is damaged after the last entry: %w", err)
 	}
 
 	return nil
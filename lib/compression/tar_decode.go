@@ -0,0 +1,200 @@
+package compression
+
+import (
+	"archive/tar"
+	"archivist/lib/encryption"
+	"filippo.io/age"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// TarDecodeOptions configures DecodeTar.
+type TarDecodeOptions struct {
+	// Identities decrypt age-encrypted archives.
+	Identities []age.Identity
+	// VerifyManifest checks extracted files against their recorded digests.
+	VerifyManifest bool
+	// Resume keeps a journal of the files extracted in the output directory,
+	// so that a run after an interrupted one skips them.
+	Resume bool
+	// Progress, when set, receives the bytes unpacked so far.
+	Progress ProgressFunc
+	// Logger receives a record per entry and warnings about skipped
+	// entries. Nil discards them.
+	Logger *slog.Logger
+	// Extractor applies the overwrite and backup policy to existing files.
+	// Nil overwrites them.
+	Extractor *Extractor
+}
+
+// DecodeTar extracts the tar archive at path into outputDir, decrypting it
+// and decompressing it with decompress. A nil decompress reads an
+// uncompressed archive, which a resumed run seeks into instead of reading
+// through the files extracted before.
+func DecodeTar(path, outputDir string, decompress func(io.Reader) (io.Reader, error), opts TarDecodeOptions) error {
+	logger := Logger(opts.Logger)
+
+	if outputDir == "" {
+		return fmt.Errorf("output directory path is empty")
+	}
+
+	if info, err := os.Stat(outputDir); err == nil && !info.IsDir() {
+		return fmt.Errorf("output path %s is a file, not a directory", outputDir)
+	}
+
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory %s: %w", outputDir, err)
+	}
+
+	file, err := OpenArchive(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	tracker := NewTracker(opts.Progress, file.Size())
+	journal, err := OpenJournal(outputDir, path, opts.Resume)
+	if err != nil {
+		return err
+	}
+	defer journal.Close()
+
+	stream, src, err := openTarStream(file, decompress, journal, tracker, opts.Identities)
+	if err != nil {
+		return err
+	}
+
+	// Offsets are recorded where the next entry starts when the archive is
+	// the tar stream itself, so that a resumed run can seek there.
+	offset := stream.Offset
+	if decompress == nil {
+		offset = func() int64 { return stream.Offset() + tarPadding(stream.Offset()) }
+	}
+
+	tarReader := tar.NewReader(src)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar header: %w", err)
+		}
+
+		targetPath := filepath.Join(outputDir, header.Name)
+		targetPath = filepath.Clean(targetPath)
+		if strings.Contains(header.Name, "..") {
+			logger.Warn(LogSkipped, LogEntryKey, header.Name)
+			continue
+		}
+		if header.Typeflag == tar.TypeReg && journal.Done(header.Name, targetPath) {
+			logger.Info(LogDone, LogEntryKey, header.Name)
+			continue
+		}
+		logger.Info(LogExtracting, LogEntryKey, header.Name)
+		tracker.Start(header.Name)
+		if header.Typeflag == tar.TypeDir {
+			if err := os.MkdirAll(targetPath, os.FileMode(header.Mode)); err != nil {
+				return fmt.Errorf("failed to create directory %s: %w", targetPath, err)
+			}
+			continue
+		}
+		if header.Typeflag == tar.TypeReg {
+			if err := journal.Start(header.Name); err != nil {
+				return err
+			}
+			written, err := extractTarFile(tarReader, header, targetPath, opts)
+			if err != nil {
+				return err
+			}
+			if !written {
+				continue
+			}
+			if err := journal.Record(header.Name, targetPath, offset()); err != nil {
+				return err
+			}
+		}
+	}
+
+	return journal.Finish()
+}
+
+// openTarStream returns the tar stream inside file and the reader counting
+// how far into file it has read. Unless the archive is compressed or
+// encrypted, a resumed run starts at the journal's offset.
+func openTarStream(file *ArchiveFile, decompress func(io.Reader) (io.Reader, error), journal *Journal, tracker *Tracker, identities []age.Identity) (*OffsetReader, io.Reader, error) {
+	prefix := make([]byte, SniffLen)
+	n, _ := file.ReadAt(prefix, 0)
+	encrypted := encryption.IsEncrypted(prefix[:n])
+
+	var offset int64
+	if decompress == nil && !encrypted {
+		offset = journal.Offset()
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			return nil, nil, fmt.Errorf("failed to seek in %s: %w", file.Name(), err)
+		}
+		tracker.Add(offset)
+	}
+	stream := NewOffsetReader(tracker.Reader(file), offset)
+
+	src, err := encryption.Decrypt(stream, identities)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if decompress != nil {
+		if src, err = decompress(src); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return stream, src, nil
+}
+
+// extractTarFile writes the current entry of tarReader to targetPath. It
+// reports false when the Extractor keeps the existing file instead.
+func extractTarFile(tarReader *tar.Reader, header *tar.Header, targetPath string, opts TarDecodeOptions) (bool, error) {
+	targetFile, err := opts.Extractor.Create(targetPath, os.FileMode(header.Mode), header.ModTime)
+	if err != nil || targetFile == nil {
+		return false, err
+	}
+	defer targetFile.Close()
+
+	// Sparse files are written around their holes.
+	var holes *SparseWriter
+	var dst io.Writer = targetFile
+	if IsSparse(header) {
+		holes = NewSparseWriter(targetFile.File)
+		dst = holes
+	}
+
+	digest := NewDigest()
+	if opts.VerifyManifest {
+		dst = io.MultiWriter(dst, digest)
+	}
+
+	_, err = io.Copy(dst, tarReader)
+	if err == nil {
+		err = holes.Close()
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to write file %s: %w", targetPath, err)
+	}
+
+	if opts.VerifyManifest {
+		if err := CheckDigest(digest, header.PAXRecords[PAXDigestKey]); err != nil {
+			return false, fmt.Errorf("failed to verify file %s: %w", targetPath, err)
+		}
+	}
+
+	return true, targetFile.Commit()
+}
+
+// tarPadding returns the zeros needed to pad n bytes to a whole block.
+func tarPadding(n int64) int64 {
+	return -n & (tarBlockSize - 1)
+}
@@ -0,0 +1,315 @@
+package compression
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// tarBlockSize is the tar record unit; an archive ends with two zero blocks.
+const tarBlockSize = 512
+
+// WriteTarSource writes source to tw, with a digest PAX record when digest
+// is set.
+func WriteTarSource(tw *tar.Writer, source Source, digest bool) error {
+	header, err := TarHeader(source)
+	if err != nil {
+		return err
+	}
+
+	if header.Typeflag != tar.TypeReg {
+		if err := tw.WriteHeader(header); err != nil {
+			return fmt.Errorf("failed to write tar header for %s: %w", source.Path, err)
+		}
+		return nil
+	}
+
+	file, err := source.Open()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var body io.Reader = file
+	if digest {
+		digested, sum, err := digestedBody(file, header.Size)
+		if err != nil {
+			return fmt.Errorf("failed to hash %s: %w", source.Path, err)
+		}
+		defer digested.Close()
+		header.PAXRecords = map[string]string{PAXDigestKey: sum}
+		body = digested
+	}
+
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write tar header for %s: %w", source.Path, err)
+	}
+
+	if n, err := io.Copy(tw, body); err != nil {
+		return fmt.Errorf("failed to write file %s to tar: %w", source.Path, err)
+	} else if n < header.Size {
+		return fmt.Errorf("failed to write file %s to tar: file shrank while being read", source.Path)
+	}
+
+	return nil
+}
+
+// RewriteTar streams the tar archive at path into a replacement, dropping
+// the members edit removes or replaces and appending its sources, then
+// swaps the replacement in. decompress and compress wrap the compression
+// layer, so every tar format shares the same copy loop. Entry data is
+// copied as is, keeping PAX records such as digests, except that sparse
+// files are stored in full.
+func RewriteTar(path string, edit Edit, decompress func(io.Reader) (io.Reader, error), compress func(io.Writer) (io.WriteCloser, error), logger *slog.Logger) error {
+	logger = Logger(logger)
+
+	if err := CheckModifiable(path); err != nil {
+		return err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open archive %s: %w", path, err)
+	}
+	defer file.Close()
+
+	src, err := decompress(file)
+	if err != nil {
+		return err
+	}
+
+	out, err := CreateArchive(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	dst, err := compress(out)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	tarReader := tar.NewReader(src)
+	tarWriter := tar.NewWriter(dst)
+	defer tarWriter.Close()
+
+	plan := edit.Plan()
+	digests := false
+
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar header: %w", err)
+		}
+
+		if _, ok := header.PAXRecords[PAXDigestKey]; ok {
+			digests = true
+		}
+
+		if !plan.Keep(header.Name, header.ModTime) {
+			continue
+		}
+
+		if IsSparse(header) {
+			logger.Warn(LogSparse, LogEntryKey, header.Name)
+		}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return fmt.Errorf("failed to copy tar header for %s: %w", header.Name, err)
+		}
+		if _, err := io.Copy(tarWriter, tarReader); err != nil {
+			return fmt.Errorf("failed to copy %s: %w", header.Name, err)
+		}
+	}
+
+	if err := plan.Err(); err != nil {
+		return err
+	}
+
+	for _, source := range plan.Pending() {
+		logger.Info(LogAdding, LogEntryKey, source.Name)
+		if err := WriteTarSource(tarWriter, source, digests); err != nil {
+			return err
+		}
+	}
+
+	if err := tarWriter.Close(); err != nil {
+		return fmt.Errorf("failed to finish tar stream: %w", err)
+	}
+	if err := dst.Close(); err != nil {
+		return fmt.Errorf("failed to finish compressed stream: %w", err)
+	}
+
+	return out.Commit()
+}
+
+// AppendTar adds the sources of edit to the end of the uncompressed tar
+// archive at path without rewriting it. Replaced members stay in the
+// archive, shadowed by the later entries, as with tar --append and
+// tar --update. Edits that delete members need RewriteTar.
+func AppendTar(path string, edit Edit, logger *slog.Logger) error {
+	logger = Logger(logger)
+
+	if err := CheckModifiable(path); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open archive %s: %w", path, err)
+	}
+	defer file.Close()
+
+	// The tar reader seeks over entry data, so the scan is cheap, and the
+	// scanner notes where the end marker it read starts.
+	scanner := &tarScanner{file: file, zeros: -1}
+	tarReader := tar.NewReader(scanner)
+	plan := edit.Plan()
+	digests := false
+
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar header: %w", err)
+		}
+
+		if _, ok := header.PAXRecords[PAXDigestKey]; ok {
+			digests = true
+		}
+		plan.Keep(header.Name, header.ModTime)
+	}
+
+	// Sources that went away since they were collected fail here, before
+	// the archive is touched.
+	pending := plan.Pending()
+	for _, source := range pending {
+		if err := checkSource(source); err != nil {
+			return err
+		}
+	}
+
+	end := scanner.end()
+	if _, err := file.Seek(end, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek in %s: %w", path, err)
+	}
+
+	if err := appendSources(file, pending, digests, logger); err != nil {
+		// Put the end marker back after the entries the archive had.
+		if restoreErr := writeEndMarker(file, end); restoreErr != nil {
+			return errors.Join(err, fmt.Errorf("failed to restore %s: %w", path, restoreErr))
+		}
+		return err
+	}
+
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %s: %w", path, err)
+	}
+
+	return file.Close()
+}
+
+// checkSource opens source, failing when it can no longer be read.
+func checkSource(source Source) error {
+	if source.Info.Mode().Type() != 0 {
+		return nil
+	}
+
+	file, err := source.Open()
+	if err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+// appendSources writes sources to file from its current offset, followed by
+// the end marker, and drops whatever followed the old end marker, such as
+// record padding.
+func appendSources(file *os.File, sources []Source, digests bool, logger *slog.Logger) error {
+	tarWriter := tar.NewWriter(file)
+	for _, source := range sources {
+		logger.Info(LogAdding, LogEntryKey, source.Name)
+		if err := WriteTarSource(tarWriter, source, digests); err != nil {
+			return err
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		return fmt.Errorf("failed to finish tar stream: %w", err)
+	}
+
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("failed to seek in %s: %w", file.Name(), err)
+	}
+	if err := file.Truncate(size); err != nil {
+		return fmt.Errorf("failed to truncate %s: %w", file.Name(), err)
+	}
+
+	return nil
+}
+
+// writeEndMarker cuts file at offset and ends it with two zero blocks.
+func writeEndMarker(file *os.File, offset int64) error {
+	if err := file.Truncate(offset); err != nil {
+		return err
+	}
+	if _, err := file.WriteAt(make([]byte, 2*tarBlockSize), offset); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// tarScanner reads a tar archive for a tar.Reader, keeping track of the
+// zero blocks it reads last, which are the end marker.
+type tarScanner struct {
+	file   *os.File
+	offset int64
+	// zeros is the offset of the zero blocks just read, or -1.
+	zeros int64
+}
+
+func (s *tarScanner) Read(p []byte) (int, error) {
+	n, err := s.file.Read(p)
+	if n == tarBlockSize && bytes.Equal(p[:n], make([]byte, tarBlockSize)) {
+		if s.zeros < 0 {
+			s.zeros = s.offset
+		}
+	} else if n > 0 {
+		s.zeros = -1
+	}
+	s.offset += int64(n)
+	return n, err
+}
+
+func (s *tarScanner) Seek(offset int64, whence int) (int64, error) {
+	pos, err := s.file.Seek(offset, whence)
+	if err != nil {
+		return pos, err
+	}
+	if pos != s.offset {
+		s.zeros = -1
+	}
+	s.offset = pos
+	return pos, nil
+}
+
+// end returns where the archive's entries end once the tar.Reader has
+// returned io.EOF: at the end marker, or at the end of the file for an
+// archive cut short without one. Zeros in the last entry's data are never
+// taken for the marker, since the reader seeks over them.
+func (s *tarScanner) end() int64 {
+	if s.zeros >= 0 {
+		return s.zeros
+	}
+	return s.offset
+}
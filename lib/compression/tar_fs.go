@@ -0,0 +1,436 @@
+package compression
+
+import (
+	"archive/tar"
+	"archivist/lib/encryption"
+	"errors"
+	"filippo.io/age"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"time"
+)
+
+// maxLinkHops bounds symlink resolution, like ELOOP does on Linux.
+const maxLinkHops = 40
+
+// FollowLinks resolves name through the symlinks of an archive file system.
+// linkTarget returns the target of a member, or "" when it isn't a symlink.
+// Links leaving the archive don't resolve.
+func FollowLinks(name string, linkTarget func(name string) (string, error)) (string, error) {
+	for range maxLinkHops {
+		target, err := linkTarget(name)
+		if err != nil || target == "" {
+			return name, err
+		}
+
+		if path.IsAbs(target) {
+			return "", fs.ErrNotExist
+		}
+		name = path.Join(path.Dir(name), target)
+		if !fs.ValidPath(name) {
+			return "", fs.ErrNotExist
+		}
+	}
+
+	return "", fmt.Errorf("too many levels of symbolic links")
+}
+
+// TarFS is a read-only file system over a tar archive. Opening it indexes
+// every member's data offset, so members are then read directly. Later
+// copies of a member replace earlier ones, as on extraction.
+type TarFS struct {
+	data   io.ReaderAt
+	size   int64
+	closer io.Closer
+	nodes  map[string]*tarNode
+}
+
+type tarNode struct {
+	// header is nil for directories without an entry of their own.
+	header *tar.Header
+	offset int64
+	// index counts the entries before this one, for members that have to
+	// be read through a tar.Reader.
+	index    int
+	children []string
+}
+
+// OpenTarFS opens the tar archive at path as a file system. Uncompressed
+// archives are read in place; others are decrypted with identities and
+// decompressed with decompress into a temporary file, removed on Close.
+func OpenTarFS(path string, identities []age.Identity, decompress func(io.Reader) (io.Reader, error)) (*TarFS, error) {
+	format, err := DetectFileFormat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := OpenArchive(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if format == FormatTar {
+		return newTarFS(file, file.Size(), file)
+	}
+
+	defer file.Close()
+
+	spool, err := spoolTar(file, identities, decompress)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := spool.Stat()
+	if err != nil {
+		spool.Close()
+		return nil, fmt.Errorf("failed to stat %s: %w", spool.Name(), err)
+	}
+
+	return newTarFS(spool, info.Size(), spool)
+}
+
+// spoolTar writes the tar stream inside r to a temporary file.
+func spoolTar(r io.Reader, identities []age.Identity, decompress func(io.Reader) (io.Reader, error)) (*spoolFile, error) {
+	src, err := encryption.Decrypt(r, identities)
+	if err != nil {
+		return nil, err
+	}
+
+	src, err = decompress(src)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.CreateTemp("", "archivist-tar-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	spool := &spoolFile{file}
+
+	if _, err := io.Copy(spool, src); err != nil {
+		spool.Close()
+		return nil, fmt.Errorf("failed to decompress archive: %w", err)
+	}
+
+	return spool, nil
+}
+
+// spoolFile is a temporary file removed on Close.
+type spoolFile struct {
+	*os.File
+}
+
+func (f *spoolFile) Close() error {
+	err := f.File.Close()
+	os.Remove(f.Name())
+	return err
+}
+
+// NewTarFS indexes the uncompressed tar archive of the given size in r.
+func NewTarFS(r io.ReaderAt, size int64) (*TarFS, error) {
+	return newTarFS(r, size, nil)
+}
+
+func newTarFS(r io.ReaderAt, size int64, closer io.Closer) (*TarFS, error) {
+	t := &TarFS{data: r, size: size, closer: closer, nodes: map[string]*tarNode{".": {}}}
+
+	// The tar reader seeks over entry data, so the section offset is where
+	// each entry's data starts once its header is read.
+	section := io.NewSectionReader(r, 0, size)
+	tarReader := tar.NewReader(section)
+
+	for index := 0; ; index++ {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Close()
+			return nil, fmt.Errorf("failed to read tar header: %w", err)
+		}
+
+		offset, err := section.Seek(0, io.SeekCurrent)
+		if err != nil {
+			t.Close()
+			return nil, err
+		}
+
+		t.add(header, offset, index)
+	}
+
+	for _, node := range t.nodes {
+		slices.Sort(node.children)
+	}
+
+	return t, nil
+}
+
+func (t *TarFS) add(header *tar.Header, offset int64, index int) {
+	name := MemberName(header.Name)
+	if name == "" {
+		return
+	}
+
+	node := &tarNode{header: header, offset: offset, index: index}
+	if existing, ok := t.nodes[name]; ok {
+		node.children = existing.children
+		t.nodes[name] = node
+		return
+	}
+	t.nodes[name] = node
+
+	// Link the member to its parents, creating those the archive lacks.
+	for child, dir := name, path.Dir(name); ; child, dir = dir, path.Dir(dir) {
+		parent, ok := t.nodes[dir]
+		if !ok {
+			parent = &tarNode{}
+			t.nodes[dir] = parent
+		}
+		parent.children = append(parent.children, path.Base(child))
+		if ok {
+			return
+		}
+	}
+}
+
+// Open opens the named member, following symlinks and hard links.
+func (t *TarFS) Open(name string) (fs.File, error) {
+	node, info, err := t.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		return &dirFile{info: info, entries: t.entries(name, node)}, nil
+	}
+
+	if IsSparse(node.header) {
+		return t.openSparse(name, info, node)
+	}
+
+	return &tarFile{info: info, SectionReader: io.NewSectionReader(t.data, node.offset, node.header.Size)}, nil
+}
+
+// openSparse opens a sparse member through a tar.Reader, which expands its
+// holes. The map of its data regions is stored ahead of the data, so the
+// reader goes through the headers again up to the member.
+func (t *TarFS) openSparse(name string, info fs.FileInfo, node *tarNode) (fs.File, error) {
+	tarReader := tar.NewReader(io.NewSectionReader(t.data, 0, t.size))
+	for range node.index + 1 {
+		if _, err := tarReader.Next(); err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+	}
+
+	return &sparseTarFile{info: info, Reader: tarReader}, nil
+}
+
+// Stat returns the file info of the named member, following links.
+func (t *TarFS) Stat(name string) (fs.FileInfo, error) {
+	_, info, err := t.lookup("stat", name)
+	return info, err
+}
+
+// ReadDir lists the named directory, sorted by name.
+func (t *TarFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	node, info, err := t.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+
+	return t.entries(name, node), nil
+}
+
+// Close releases the archive.
+func (t *TarFS) Close() error {
+	if t.closer == nil {
+		return nil
+	}
+	return t.closer.Close()
+}
+
+// ReadLink returns the target of the named symlink.
+func (t *TarFS) ReadLink(name string) (string, error) {
+	node, err := t.member("readlink", name)
+	if err != nil {
+		return "", err
+	}
+	if node.header == nil || node.header.Typeflag != tar.TypeSymlink {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+
+	return node.header.Linkname, nil
+}
+
+// Lstat returns the file info of the named member without following a
+// final symlink.
+func (t *TarFS) Lstat(name string) (fs.FileInfo, error) {
+	node, err := t.member("lstat", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.info(path.Base(name), node), nil
+}
+
+// member finds the node for name, which may be a symlink.
+func (t *TarFS) member(op, name string) (*tarNode, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	node := t.hardNode(name)
+	if node == nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+
+	return node, nil
+}
+
+// lookup finds the node for name, following symlinks, and its file info
+// under that name.
+func (t *TarFS) lookup(op, name string) (*tarNode, fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	resolved, err := FollowLinks(name, func(member string) (string, error) {
+		node := t.hardNode(member)
+		switch {
+		case node == nil:
+			return "", fs.ErrNotExist
+		case node.header != nil && node.header.Typeflag == tar.TypeSymlink:
+			return node.header.Linkname, nil
+		}
+		return "", nil
+	})
+	if err != nil {
+		return nil, nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+
+	node := t.hardNode(resolved)
+	return node, t.info(path.Base(name), node), nil
+}
+
+// hardNode returns the node for name, resolving hard links, which name
+// another copy of the same file from the archive root. It returns nil when
+// there is no such member.
+func (t *TarFS) hardNode(name string) *tarNode {
+	node := t.nodes[name]
+	for range maxLinkHops {
+		if node == nil || node.header == nil || node.header.Typeflag != tar.TypeLink {
+			return node
+		}
+		node = t.nodes[MemberName(node.header.Linkname)]
+	}
+
+	return nil
+}
+
+// info returns the file info of node, called name.
+func (t *TarFS) info(name string, node *tarNode) fs.FileInfo {
+	if node.header == nil {
+		return dirInfo{name: name}
+	}
+	return namedInfo{FileInfo: node.header.FileInfo(), name: name}
+}
+
+// entries lists the children of the directory node, without following
+// their symlinks.
+func (t *TarFS) entries(dir string, node *tarNode) []fs.DirEntry {
+	entries := make([]fs.DirEntry, 0, len(node.children))
+	for _, child := range node.children {
+		if member := t.hardNode(path.Join(dir, child)); member != nil {
+			entries = append(entries, fs.FileInfoToDirEntry(t.info(child, member)))
+		}
+	}
+	return entries
+}
+
+// tarFile is an open regular member, read straight from the archive.
+type tarFile struct {
+	info fs.FileInfo
+	*io.SectionReader
+}
+
+func (f *tarFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *tarFile) Close() error {
+	return nil
+}
+
+// sparseTarFile is an open sparse member, read with its holes filled in.
+type sparseTarFile struct {
+	info fs.FileInfo
+	*tar.Reader
+}
+
+func (f *sparseTarFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *sparseTarFile) Close() error {
+	return nil
+}
+
+// dirFile is an open directory of an archive file system.
+type dirFile struct {
+	info    fs.FileInfo
+	entries []fs.DirEntry
+	pos     int
+}
+
+func (d *dirFile) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *dirFile) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.Name(), Err: errors.New("is a directory")}
+}
+
+func (d *dirFile) Close() error {
+	return nil
+}
+
+func (d *dirFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.pos:]
+	if n > 0 && len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > 0 && n < len(rest) {
+		rest = rest[:n]
+	}
+	d.pos += len(rest)
+	return rest, nil
+}
+
+// dirInfo describes a directory the archive holds no entry for.
+type dirInfo struct {
+	name string
+}
+
+func (i dirInfo) Name() string       { return i.name }
+func (i dirInfo) Size() int64        { return 0 }
+func (i dirInfo) Mode() fs.FileMode  { return fs.ModeDir | 0555 }
+func (i dirInfo) ModTime() time.Time { return time.Time{} }
+func (i dirInfo) IsDir() bool        { return true }
+func (i dirInfo) Sys() any           { return nil }
+
+// namedInfo renames a FileInfo, for members reached through a link.
+type namedInfo struct {
+	fs.FileInfo
+	name string
+}
+
+func (i namedInfo) Name() string {
+	return i.name
+}
@@ -0,0 +1,11 @@
+package tar_gz
+
+import (
+	"archivist/lib/compression"
+	"io"
+)
+
+// Cat streams member to w, decompressing the archive up to its first copy.
+func (ed *EncodeDecoder) Cat(member string, w io.Writer) error {
+	return compression.CatTarFile(ed.OutputPath, member, ed.Identities, ed.Decompress, w)
+}
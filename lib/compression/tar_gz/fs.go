@@ -0,0 +1,13 @@
+package tar_gz
+
+import "archivist/lib/compression"
+
+// OpenFS decompresses the archive to a temporary file and indexes it as a
+// read-only file system.
+func (ed *EncodeDecoder) OpenFS() (compression.ArchiveFS, error) {
+	fsys, err := compression.OpenTarFS(ed.OutputPath, ed.Identities, ed.Decompress)
+	if err != nil {
+		return nil, err
+	}
+	return fsys, nil
+}
@@ -0,0 +1,31 @@
+package tar_gz
+
+import (
+	"archivist/lib/compression"
+	"archivist/lib/compression/parallel"
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+// Modify recompresses the archive in a single streaming pass.
+func (ed *EncodeDecoder) Modify(edit compression.Edit) error {
+	return compression.RewriteTar(ed.OutputPath, edit, ed.Decompress, ed.Compress, ed.Logger)
+}
+
+// Decompress wraps r in a gzip reader.
+func (ed *EncodeDecoder) Decompress(r io.Reader) (io.Reader, error) {
+	gzReader, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gzip reader: %w", err)
+	}
+	return gzReader, nil
+}
+
+// Compress wraps w in a gzip writer, parallel when Threads is set.
+func (ed *EncodeDecoder) Compress(w io.Writer) (io.WriteCloser, error) {
+	if ed.Threads > 0 {
+		return parallel.NewGzipWriter(w, ed.Threads)
+	}
+	return gzip.NewWriter(w), nil
+}
@@ -0,0 +1,13 @@
+package tar_gz
+
+import "archivist/lib/compression"
+
+// Open opens the archive for reading its entries one at a time. With
+// VerifyManifest, reading a file to the end checks its recorded digest.
+func (ed *EncodeDecoder) Open() (compression.ArchiveReader, error) {
+	reader, err := compression.OpenTarReader(ed.OutputPath, ed.Identities, ed.Decompress, ed.VerifyManifest)
+	if err != nil {
+		return nil, err
+	}
+	return reader, nil
+}
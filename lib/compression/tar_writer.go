@@ -0,0 +1,445 @@
+package compression
+
+import (
+	"archive/tar"
+	"archivist/lib/encryption"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"filippo.io/age"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// spoolLimit is how much of a file of unknown size AddFile buffers in
+// memory before spilling the rest to a temporary file.
+const spoolLimit = 8 << 20
+
+var ErrWriterClosed = errors.New("archive writer is closed")
+
+// TarWriterOptions configures a TarWriter.
+type TarWriterOptions struct {
+	// Recipients encrypt the archive with age when set.
+	Recipients []age.Recipient
+	// Manifest records a SHA-256 digest of every file as a PAX record.
+	Manifest bool
+	// SplitSize splits the archive into volumes of at most that many bytes,
+	// named by VolumeName. Zero writes a single file.
+	SplitSize int64
+	// Reproducible clamps mtimes to SOURCE_DATE_EPOCH and drops ownership
+	// and permission noise.
+	Reproducible bool
+	// Tracker counts the bytes of the files added. Nil reports nothing.
+	Tracker *Tracker
+	// Logger receives a record per entry. Nil discards them.
+	Logger *slog.Logger
+}
+
+// TarWriter is the ArchiveWriter of the tar-based formats, which differ
+// only in the compression layer.
+type TarWriter struct {
+	file       Destination
+	encWriter  io.WriteCloser
+	compWriter io.WriteCloser
+	tarWriter  *tar.Writer
+	opts       TarWriterOptions
+	logger     *slog.Logger
+	epoch      *time.Time
+	err        error
+}
+
+// NewTarWriter starts a tar archive at path, compressed by compress.
+func NewTarWriter(path string, compress func(io.Writer) (io.WriteCloser, error), opts TarWriterOptions) (*TarWriter, error) {
+	w := &TarWriter{opts: opts, logger: Logger(opts.Logger)}
+
+	if opts.Reproducible {
+		var err error
+		if w.epoch, err = SourceDateEpoch(); err != nil {
+			return nil, err
+		}
+	}
+
+	file, err := CreateDestination(path, opts.SplitSize)
+	if err != nil {
+		return nil, err
+	}
+	w.file = file
+
+	if w.encWriter, err = encryption.Encrypt(file, opts.Recipients); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if w.compWriter, err = compress(w.encWriter); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	w.tarWriter = tar.NewWriter(w.compWriter)
+
+	return w, nil
+}
+
+// AddSource adds a file walked from disk or an fs.FS, keeping the metadata
+// of its FileInfo.
+func (w *TarWriter) AddSource(source Source) error {
+	header, err := TarHeader(source)
+	if err != nil {
+		return w.fail(err)
+	}
+
+	if header.Typeflag != tar.TypeReg {
+		return w.add(header, nil, source.Path)
+	}
+
+	file, err := source.Open()
+	if err != nil {
+		return w.fail(err)
+	}
+	defer file.Close()
+
+	// Files with holes keep only their data. Where holes fall depends on the
+	// file system rather than the contents, so reproducible archives store
+	// files in full.
+	if osFile, ok := file.(*os.File); ok && !w.opts.Reproducible {
+		if data := dataRegions(osFile, header.Size); data != nil {
+			if w.opts.Manifest {
+				digest, err := hashSparse(osFile, header.Size, data)
+				if err != nil {
+					return w.fail(fmt.Errorf("failed to hash %s: %w", source.Path, err))
+				}
+				header.PAXRecords = map[string]string{PAXDigestKey: digest}
+			}
+			return w.add(header, &sparseFile{File: osFile, data: data}, source.Path)
+		}
+	}
+
+	if !w.opts.Manifest {
+		return w.add(header, file, source.Path)
+	}
+
+	body, digest, err := digestedBody(file, header.Size)
+	if err != nil {
+		return w.fail(fmt.Errorf("failed to hash %s: %w", source.Path, err))
+	}
+	defer body.Close()
+	header.PAXRecords = map[string]string{PAXDigestKey: digest}
+
+	return w.add(header, body, source.Path)
+}
+
+// AddFile adds a regular file with the contents of r. Tar records the size
+// ahead of the data, so a reader that can't seek is buffered first, in
+// memory up to a few megabytes and then in a temporary file.
+func (w *TarWriter) AddFile(name string, mode fs.FileMode, mtime time.Time, r io.Reader) error {
+	body, err := measure(r)
+	if err != nil {
+		return w.fail(fmt.Errorf("failed to read %s: %w", name, err))
+	}
+	defer body.Close()
+
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     int64(mode.Perm()),
+		ModTime:  mtime,
+		Size:     body.size,
+	}
+
+	if w.opts.Manifest {
+		digest, err := body.digest()
+		if err != nil {
+			return w.fail(fmt.Errorf("failed to hash %s: %w", name, err))
+		}
+		header.PAXRecords = map[string]string{PAXDigestKey: digest}
+	}
+
+	return w.add(header, body, name)
+}
+
+// AddDir adds a directory.
+func (w *TarWriter) AddDir(name string, mode fs.FileMode, mtime time.Time) error {
+	header := &tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     strings.TrimSuffix(name, "/"),
+		Mode:     int64(mode.Perm()),
+		ModTime:  mtime,
+	}
+
+	return w.add(header, nil, name)
+}
+
+// AddSymlink adds a symlink to target.
+func (w *TarWriter) AddSymlink(name, target string, mtime time.Time) error {
+	header := &tar.Header{
+		Typeflag: tar.TypeSymlink,
+		Name:     name,
+		Linkname: target,
+		Mode:     0777,
+		ModTime:  mtime,
+	}
+
+	return w.add(header, nil, name)
+}
+
+// add writes header followed by the data of body, a regular file's.
+func (w *TarWriter) add(header *tar.Header, body io.Reader, path string) error {
+	if w.err != nil {
+		return w.err
+	}
+
+	if w.opts.Reproducible {
+		NormalizeTarHeader(header, w.epoch)
+	}
+
+	w.logger.Info(LogAdding, LogEntryKey, header.Name)
+	w.opts.Tracker.Start(header.Name)
+
+	// The tar writer can't write sparse entries, so they are written
+	// around it, once it has padded the entry before.
+	if sparse, ok := body.(*sparseFile); ok {
+		if err := w.tarWriter.Flush(); err != nil {
+			return w.fail(fmt.Errorf("failed to write tar padding: %w", err))
+		}
+		if err := writeSparse(w.compWriter, header, sparse, w.opts.Tracker); err != nil {
+			return w.fail(fmt.Errorf("failed to write sparse file %s to tar: %w", path, err))
+		}
+		return nil
+	}
+
+	if err := w.tarWriter.WriteHeader(header); err != nil {
+		return w.fail(fmt.Errorf("failed to write tar header for %s: %w", path, err))
+	}
+
+	if body == nil {
+		return nil
+	}
+
+	if _, err := io.Copy(w.tarWriter, w.opts.Tracker.Reader(body)); err != nil {
+		return w.fail(fmt.Errorf("failed to write file %s to tar: %w", path, err))
+	}
+
+	return nil
+}
+
+// fail records err; once an entry fails, the archive can't be completed.
+func (w *TarWriter) fail(err error) error {
+	if w.err == nil {
+		w.err = err
+	}
+	return err
+}
+
+// Close finishes the archive and moves it into place. After a failed Add,
+// it discards the archive and returns that error instead.
+func (w *TarWriter) Close() error {
+	if w.err != nil {
+		w.file.Close()
+		return w.err
+	}
+	w.err = ErrWriterClosed
+
+	if err := w.finish(); err != nil {
+		w.file.Close()
+		return err
+	}
+
+	return w.file.Commit()
+}
+
+func (w *TarWriter) finish() error {
+	if err := w.tarWriter.Close(); err != nil {
+		return fmt.Errorf("failed to finish tar stream: %w", err)
+	}
+	if err := w.compWriter.Close(); err != nil {
+		return fmt.Errorf("failed to finish compression: %w", err)
+	}
+	if err := w.encWriter.Close(); err != nil {
+		return fmt.Errorf("failed to finish encryption: %w", err)
+	}
+
+	return nil
+}
+
+// measuredReader is the data of a file whose size is known, read in place
+// from a seekable reader or from a buffered copy.
+type measuredReader struct {
+	io.Reader
+	size  int64
+	seek  io.ReadSeeker
+	start int64
+	buf   *bytes.Buffer
+	spill *os.File
+}
+
+// measure finds the size of the data left in r, buffering it when r can't
+// seek.
+func measure(r io.Reader) (*measuredReader, error) {
+	if seeker, ok := r.(io.ReadSeeker); ok {
+		if m, err := measureSeeker(seeker); err == nil {
+			return m, nil
+		}
+	}
+
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, r, spoolLimit+1)
+	if err == io.EOF {
+		return &measuredReader{Reader: &buf, size: n, buf: &buf}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	spill, err := os.CreateTemp("", "archivist-entry-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	m := &measuredReader{spill: spill}
+
+	size, err := io.Copy(spill, io.MultiReader(&buf, r))
+	if err != nil {
+		m.Close()
+		return nil, err
+	}
+	if _, err := spill.Seek(0, io.SeekStart); err != nil {
+		m.Close()
+		return nil, err
+	}
+	m.Reader, m.size = spill, size
+
+	return m, nil
+}
+
+// measureSeeker sizes r by seeking to its end and back, failing for
+// readers such as pipes that can't seek after all.
+func measureSeeker(r io.ReadSeeker) (*measuredReader, error) {
+	start, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	size := end - start
+	return &measuredReader{Reader: io.LimitReader(r, size), size: size, seek: r, start: start}, nil
+}
+
+// digest returns the manifest digest of the data, leaving it unread.
+func (m *measuredReader) digest() (string, error) {
+	hash := NewDigest()
+
+	switch {
+	case m.seek != nil:
+		if _, err := io.CopyN(hash, m.seek, m.size); err != nil {
+			return "", err
+		}
+		if _, err := m.seek.Seek(m.start, io.SeekStart); err != nil {
+			return "", err
+		}
+	case m.spill != nil:
+		if _, err := io.Copy(hash, m.spill); err != nil {
+			return "", err
+		}
+		if _, err := m.spill.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+	default:
+		hash.Write(m.buf.Bytes())
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// digestedBody returns the size bytes of data in r along with their
+// manifest digest. The digest goes in the tar header, ahead of the data, so
+// it can't be taken while the data is copied: files up to spoolLimit are
+// read once into memory, hashed on the way, and larger ones are hashed
+// first and then hashed again as they are copied, which fails the copy if
+// they changed in between.
+func digestedBody(r io.Reader, size int64) (io.ReadCloser, string, error) {
+	if size <= spoolLimit {
+		hash := NewDigest()
+		data, err := io.ReadAll(io.TeeReader(io.LimitReader(r, size), hash))
+		if err != nil {
+			return nil, "", err
+		}
+		return io.NopCloser(bytes.NewReader(data)), hex.EncodeToString(hash.Sum(nil)), nil
+	}
+
+	body, err := measure(r)
+	if err != nil {
+		return nil, "", err
+	}
+	digest, err := body.digest()
+	if err != nil {
+		body.Close()
+		return nil, "", err
+	}
+
+	return &checkedReader{ReadCloser: body, hash: NewDigest(), digest: digest}, digest, nil
+}
+
+// checkedReader hashes data as it is read and fails at the end if it no
+// longer matches the digest taken before.
+type checkedReader struct {
+	io.ReadCloser
+	hash   hash.Hash
+	digest string
+}
+
+func (c *checkedReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.hash.Write(p[:n])
+	if err == io.EOF {
+		if err := CheckDigest(c.hash, c.digest); err != nil {
+			return n, fmt.Errorf("file changed while it was archived: %w", err)
+		}
+	}
+	return n, err
+}
+
+// hashSparse returns the manifest digest of the first size bytes of file,
+// reading only its data regions and hashing zeros for the holes.
+func hashSparse(file *os.File, size int64, data []sparseRegion) (string, error) {
+	hash := NewDigest()
+	zeros := make([]byte, 32<<10)
+	hole := func(n int64) {
+		for ; n > 0; n -= int64(len(zeros)) {
+			hash.Write(zeros[:min(n, int64(len(zeros)))])
+		}
+	}
+
+	var offset int64
+	for _, region := range data {
+		hole(region.Offset - offset)
+		if _, err := io.Copy(hash, io.NewSectionReader(file, region.Offset, region.Length)); err != nil {
+			return "", err
+		}
+		offset = region.Offset + region.Length
+	}
+	hole(size - offset)
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Close removes the temporary file holding the data, if any.
+func (m *measuredReader) Close() error {
+	if m.spill == nil {
+		return nil
+	}
+
+	err := m.spill.Close()
+	os.Remove(m.spill.Name())
+	return err
+}
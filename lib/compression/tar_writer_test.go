@@ -0,0 +1,70 @@
+package compression
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func randomBytes(n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(data)
+	return data
+}
+
+func TestDigestedBody(t *testing.T) {
+	for _, size := range []int{0, 1000, spoolLimit, spoolLimit + 1} {
+		data := randomBytes(size)
+		sum := sha256.Sum256(data)
+
+		body, digest, err := digestedBody(bytes.NewReader(data), int64(size))
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		got, err := io.ReadAll(body)
+		body.Close()
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+
+		if digest != hex.EncodeToString(sum[:]) {
+			t.Errorf("size %d: digest %s, want %x", size, digest, sum)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("size %d: body differs from the data", size)
+		}
+	}
+}
+
+// A large file changed after it was hashed fails the copy rather than
+// being archived under a digest that doesn't match.
+func TestDigestedBodyChanged(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "big")
+	if err := os.WriteFile(path, randomBytes(spoolLimit+1), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	body, _, err := digestedBody(file, spoolLimit+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+
+	if _, err := file.WriteAt([]byte("changed"), 100); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(io.Discard, body); !errors.Is(err, ErrDigestMismatch) {
+		t.Errorf("copying a changed file = %v, want %v", err, ErrDigestMismatch)
+	}
+}
@@ -0,0 +1,35 @@
+package tar_xz
+
+import (
+	"archivist/lib/compression"
+	"archivist/lib/compression/parallel"
+	"fmt"
+	"github.com/ulikunitz/xz"
+	"io"
+)
+
+// Modify recompresses the archive in a single streaming pass.
+func (ed *EncodeDecoder) Modify(edit compression.Edit) error {
+	return compression.RewriteTar(ed.OutputPath, edit, ed.Decompress, ed.Compress, ed.Logger)
+}
+
+// Decompress wraps r in an xz reader.
+func (ed *EncodeDecoder) Decompress(r io.Reader) (io.Reader, error) {
+	xzReader, err := xz.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create xz reader: %w", err)
+	}
+	return xzReader, nil
+}
+
+// Compress wraps w in an xz writer, parallel when Threads is set.
+func (ed *EncodeDecoder) Compress(w io.Writer) (io.WriteCloser, error) {
+	if ed.Threads > 0 {
+		return parallel.NewXzWriter(w, ed.Threads)
+	}
+	xzWriter, err := xz.NewWriter(w)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create xz writer: %w", err)
+	}
+	return xzWriter, nil
+}
@@ -0,0 +1,81 @@
+package tar_xz
+
+import (
+	"archivist/lib/compression"
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSplitRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.Mkdir(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Random bytes don't compress, so the archive needs several volumes.
+	files := map[string][]byte{}
+	rng := rand.New(rand.NewSource(1))
+	for _, name := range []string{"a", "b", "c"} {
+		data := make([]byte, 100000)
+		rng.Read(data)
+		files[name] = data
+		if err := os.WriteFile(filepath.Join(src, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	archive := filepath.Join(dir, "src.tar.xz")
+	ed := New(archive)
+	ed.SplitSize = compression.MinSplitSize
+	if err := ed.Encode([]string{src}); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	first := compression.VolumeName(archive, 1)
+	file, err := compression.OpenArchive(first)
+	if err != nil {
+		t.Fatalf("OpenArchive: %v", err)
+	}
+	joined, err := io.ReadAll(io.NewSectionReader(file, 0, file.Size()))
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(joined) <= 4*compression.MinSplitSize {
+		t.Fatalf("archive is %d bytes, want more than four volumes", len(joined))
+	}
+
+	var concatenated []byte
+	for index := 1; ; index++ {
+		data, err := os.ReadFile(compression.VolumeName(archive, index))
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		concatenated = append(concatenated, data...)
+	}
+	if !bytes.Equal(joined, concatenated) {
+		t.Error("OpenArchive reads different bytes from the volumes concatenated")
+	}
+
+	out := filepath.Join(dir, "out")
+	if err := New(first).Decode(out); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	for name, want := range files {
+		got, err := os.ReadFile(filepath.Join(out, "src", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s has the wrong contents", name)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package compression
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+)
+
+// EntryError reports an archive member whose data or header is damaged.
+type EntryError struct {
+	Name string
+	Err  error
+}
+
+func (e *EntryError) Error() string {
+	return fmt.Sprintf("entry %s is damaged: %v", e.Name, e.Err)
+}
+
+func (e *EntryError) Unwrap() error {
+	return e.Err
+}
+
+// TestTar reads every header and body of a tar stream, relying on archive/tar
+// for header checksums and on the underlying decompressor for stream checks.
+// A truncated or corrupt stream is attributed to the last entry read, and
+// entries carrying a manifest digest are checked against it.
+func TestTar(tarReader *tar.Reader) error {
+	name := ""
+
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			if name == "" {
+				return fmt.Errorf("failed to read first tar header: %w", err)
+			}
+			return &EntryError{Name: name, Err: fmt.Errorf("failed to read next header: %w", err)}
+		}
+		name = header.Name
+
+		digest := NewDigest()
+		if _, err := io.Copy(digest, tarReader); err != nil {
+			return &EntryError{Name: name, Err: err}
+		}
+
+		if expected, ok := header.PAXRecords[PAXDigestKey]; ok {
+			if err := CheckDigest(digest, expected); err != nil {
+				return &EntryError{Name: name, Err: err}
+			}
+		}
+	}
+}
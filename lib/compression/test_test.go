@@ -0,0 +1,155 @@
+package compression_test
+
+import (
+	"archive/zip"
+	"archivist/lib/compression"
+	tarformat "archivist/lib/compression/tar"
+	"archivist/lib/compression/tar_gz"
+	zipformat "archivist/lib/compression/zip"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTree writes files, by slash-separated name, under dir.
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// flipByte inverts the byte at offset in the file at path.
+func flipByte(t *testing.T, path string, offset int64) {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[offset] ^= 0xff
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestTestDetectsDamage packs a tree, checks that Test passes, flips one
+// byte of the data of src/b and checks that Test fails, naming src/b.
+func TestTestDetectsDamage(t *testing.T) {
+	content := bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog\n"), 500)
+
+	formats := []struct {
+		name string
+		test func(path string) error
+		// encode packs src, and damage picks the byte to flip.
+		encode func(path, src string) error
+		damage func(t *testing.T, path string) int64
+		// digest reports the damage as a digest mismatch, and anywhere
+		// doesn't tie it to src/b.
+		digest   bool
+		anywhere bool
+	}{
+		{
+			name: "tar",
+			encode: func(path, src string) error {
+				ed := tarformat.New(path)
+				ed.Manifest = true
+				return ed.Encode([]string{src})
+			},
+			test: func(path string) error { return tarformat.New(path).Test() },
+			damage: func(t *testing.T, path string) int64 {
+				data, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// src/a comes first and holds the same text, so take the
+				// last copy.
+				return int64(bytes.LastIndex(data, content[:100]) + 50)
+			},
+			digest: true,
+		},
+		{
+			name: "tar.gz",
+			encode: func(path, src string) error {
+				return tar_gz.New(path).Encode([]string{src})
+			},
+			test: func(path string) error { return tar_gz.New(path).Test() },
+			damage: func(t *testing.T, path string) int64 {
+				info, err := os.Stat(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return info.Size() / 2
+			},
+			// A damaged deflate stream may only show where it ends, past
+			// the last entry.
+			anywhere: true,
+		},
+		{
+			name: "zip",
+			encode: func(path, src string) error {
+				return zipformat.New(path).Encode([]string{src})
+			},
+			test: func(path string) error { return zipformat.New(path).Test() },
+			damage: func(t *testing.T, path string) int64 {
+				reader, err := zip.OpenReader(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer reader.Close()
+				for _, file := range reader.File {
+					if file.Name == "src/b" {
+						offset, err := file.DataOffset()
+						if err != nil {
+							t.Fatal(err)
+						}
+						return offset + int64(file.CompressedSize64)/2
+					}
+				}
+				t.Fatal("src/b isn't in the archive")
+				return 0
+			},
+		},
+	}
+
+	for _, format := range formats {
+		t.Run(format.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTree(t, dir, map[string]string{"src/a": string(content), "src/b": string(content)})
+			archive := filepath.Join(dir, "src."+format.name)
+			if err := format.encode(archive, filepath.Join(dir, "src")); err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+
+			if err := format.test(archive); err != nil {
+				t.Fatalf("Test of an intact archive: %v", err)
+			}
+
+			flipByte(t, archive, format.damage(t, archive))
+
+			err := format.test(archive)
+			if err == nil {
+				t.Fatal("Test of a damaged archive succeeded")
+			}
+			if format.anywhere {
+				return
+			}
+			var entryErr *compression.EntryError
+			if !errors.As(err, &entryErr) || entryErr.Name != "src/b" {
+				t.Fatalf("Test of a damaged archive = %v, want src/b reported", err)
+			}
+			if format.digest && !errors.Is(err, compression.ErrDigestMismatch) {
+				t.Errorf("Test = %v, want %v", err, compression.ErrDigestMismatch)
+			}
+		})
+	}
+}
@@ -0,0 +1,403 @@
+package compression
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+)
+
+// MinSplitSize is the smallest volume size accepted, as in Info-ZIP.
+const MinSplitSize = 64 << 10
+
+// maxVolumes is the most volumes an archive is split into, so that volume
+// numbers keep three digits.
+const maxVolumes = 999
+
+var ErrSplitSize = errors.New("split size is below the 64 KiB minimum")
+
+var ErrNotFirstVolume = errors.New("not the first volume of a split archive")
+
+var ErrMissingVolume = errors.New("volume of a split archive is missing")
+
+var ErrVolumeOrder = errors.New("volumes of a split archive are out of order or damaged")
+
+var ErrSplitModify = errors.New("split archives cannot be modified")
+
+var ErrTooManyVolumes = errors.New("archive needs more than 999 volumes, use a larger split size")
+
+// volumeSuffix matches the number of a split volume after an archive
+// extension, as in x.tar.xz.001 or x.tar.gz.age.002, so that names such as
+// nightly.tar.2024 aren't taken for volumes.
+var volumeSuffix = regexp.MustCompile(`(?i)\.(?:tar(?:\.gz|\.xz|\.bz2?)?|tgz|txz|tbz2?|zip)(?:\.age)?\.(\d{3})$`)
+
+// Destination is where an archive is written: a single AtomicFile, or
+// Volumes when it is split. Nothing appears at the destination until
+// Commit; Close discards whatever wasn't committed.
+type Destination interface {
+	io.Writer
+	Commit() error
+	Close() error
+}
+
+// CreateDestination starts writing an archive at path, split into volumes
+// of at most splitSize bytes, named by VolumeName, when splitSize is set.
+func CreateDestination(path string, splitSize int64) (Destination, error) {
+	if splitSize == 0 {
+		return CreateArchive(path)
+	}
+
+	return CreateVolumes(splitSize, func(index int, _ bool) string {
+		return VolumeName(path, index)
+	})
+}
+
+// VolumeName returns the name of volume index, counted from 1, of the
+// archive split from path: path.001, path.002 and so on.
+func VolumeName(path string, index int) string {
+	return fmt.Sprintf("%s.%03d", path, index)
+}
+
+// IsSplit reports whether path names a volume of a split archive.
+func IsSplit(path string) bool {
+	return volumeSuffix.MatchString(path)
+}
+
+// HasVolumes reports whether path doesn't exist but the first volume of a
+// split archive packed under that name does.
+func HasVolumes(path string) bool {
+	if _, err := os.Lstat(path); !errors.Is(err, fs.ErrNotExist) {
+		return false
+	}
+	_, err := os.Stat(VolumeName(path, 1))
+	return err == nil
+}
+
+// TrimVolume returns path without its volume number.
+func TrimVolume(path string) string {
+	if match := volumeSuffix.FindStringSubmatchIndex(path); match != nil {
+		return path[:match[2]-1]
+	}
+	return path
+}
+
+// Volumes writes an archive across files of at most a fixed size, each
+// written atomically like CreateArchive.
+type Volumes struct {
+	size  int64
+	name  func(index int, last bool) string
+	files []*AtomicFile
+	used  int64
+}
+
+// CreateVolumes starts an archive split into volumes of at most size
+// bytes. name returns the path of volume index, counted from 1; last is
+// set for the final volume, which some formats name differently.
+func CreateVolumes(size int64, name func(index int, last bool) string) (*Volumes, error) {
+	if size < MinSplitSize {
+		return nil, ErrSplitSize
+	}
+
+	return &Volumes{size: size, name: name}, nil
+}
+
+// Write writes p, starting new volumes as the current one fills up.
+func (v *Volumes) Write(p []byte) (int, error) {
+	written := 0
+
+	for len(p) > 0 {
+		if len(v.files) == 0 || v.used == v.size {
+			if err := v.next(); err != nil {
+				return written, err
+			}
+		}
+
+		n, err := v.files[len(v.files)-1].Write(p[:min(int64(len(p)), v.size-v.used)])
+		written += n
+		v.used += int64(n)
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+
+	return written, nil
+}
+
+func (v *Volumes) next() error {
+	if len(v.files) == maxVolumes {
+		return ErrTooManyVolumes
+	}
+
+	file, err := CreateArchive(v.name(len(v.files)+1, false))
+	if err != nil {
+		return err
+	}
+
+	v.files = append(v.files, file)
+	v.used = 0
+
+	return nil
+}
+
+// Position returns the volume the next byte is written to, counted from 0,
+// and its offset there.
+func (v *Volumes) Position() (int, int64) {
+	if len(v.files) == 0 {
+		return 0, 0
+	}
+	if v.used == v.size {
+		return len(v.files), 0
+	}
+	return len(v.files) - 1, v.used
+}
+
+// Reserve starts a new volume unless n more bytes fit in the current one,
+// for records that mustn't be split.
+func (v *Volumes) Reserve(n int64) error {
+	if n > v.size {
+		return fmt.Errorf("record of %d bytes doesn't fit a %d byte volume", n, v.size)
+	}
+	if len(v.files) > 0 && v.used+n <= v.size {
+		return nil
+	}
+
+	return v.next()
+}
+
+// Count returns the number of volumes started so far.
+func (v *Volumes) Count() int {
+	return len(v.files)
+}
+
+// Volume returns volume index, counted from 0.
+func (v *Volumes) Volume(index int) *AtomicFile {
+	return v.files[index]
+}
+
+// Commit moves every volume into place and removes volumes left over from
+// an earlier, longer run, which would otherwise be read as part of this
+// archive. When a volume fails to commit, the ones already moved into place
+// are removed again, so that the new volumes are never read together with
+// what is left of an older set.
+func (v *Volumes) Commit() error {
+	if len(v.files) == 0 {
+		if err := v.next(); err != nil {
+			return err
+		}
+	}
+
+	last := len(v.files)
+	v.files[last-1].path = v.name(last, true)
+
+	for i, file := range v.files {
+		if err := file.Commit(); err != nil {
+			for _, committed := range v.files[:i] {
+				os.Remove(committed.path)
+			}
+			for _, rest := range v.files[i+1:] {
+				rest.Close()
+			}
+			return err
+		}
+	}
+
+	for index := last; ; index++ {
+		stale := v.name(index, false)
+		if stale == v.files[last-1].path {
+			continue
+		}
+		if err := os.Remove(stale); err != nil {
+			break
+		}
+	}
+
+	return nil
+}
+
+// Close discards every volume not yet committed.
+func (v *Volumes) Close() error {
+	var err error
+	for _, file := range v.files {
+		err = errors.Join(err, file.Close())
+	}
+	return err
+}
+
+// ArchiveFile is an archive opened for reading, joined from its volumes
+// when it is split.
+type ArchiveFile struct {
+	*io.SectionReader
+	files []*os.File
+	name  string
+}
+
+// OpenArchive opens the archive at path. Given the first volume of a split
+// archive, such as x.tar.xz.001, it joins the volumes after checking that
+// none is missing and that their sizes fit together.
+func OpenArchive(path string) (*ArchiveFile, error) {
+	if !IsSplit(path) {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open archive %s: %w", path, err)
+		}
+		return openFiles(path, []*os.File{file})
+	}
+
+	base := TrimVolume(path)
+	if index, _ := strconv.Atoi(volumeSuffix.FindStringSubmatch(path)[1]); index != 1 {
+		return nil, fmt.Errorf("%s: %w, open %s instead", path, ErrNotFirstVolume, VolumeName(base, 1))
+	}
+
+	var files []*os.File
+	for index := 1; index <= maxVolumes; index++ {
+		file, err := os.Open(VolumeName(base, index))
+		if errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			closeFiles(files)
+			return nil, fmt.Errorf("failed to open volume %s: %w", VolumeName(base, index), err)
+		}
+		files = append(files, file)
+	}
+
+	if err := checkVolumes(base, files); err != nil {
+		closeFiles(files)
+		return nil, err
+	}
+
+	return openFiles(path, files)
+}
+
+func openFiles(name string, files []*os.File) (*ArchiveFile, error) {
+	joined, err := JoinFiles(files)
+	if err != nil {
+		closeFiles(files)
+		return nil, err
+	}
+
+	return NewArchiveFile(name, joined, files), nil
+}
+
+// checkVolumes rejects gaps in the numbering and volumes whose size shows
+// they were cut short or swapped: all but the last match the first.
+func checkVolumes(base string, files []*os.File) error {
+	strays, _ := filepath.Glob(base + ".[0-9][0-9][0-9]")
+	for _, stray := range strays {
+		if index, err := strconv.Atoi(stray[len(base)+1:]); err == nil && index > len(files) {
+			return fmt.Errorf("%s: %w", VolumeName(base, len(files)+1), ErrMissingVolume)
+		}
+	}
+
+	var first int64
+	for i, file := range files {
+		info, err := file.Stat()
+		if err != nil {
+			return fmt.Errorf("failed to stat %s: %w", file.Name(), err)
+		}
+
+		size := info.Size()
+		if i == 0 {
+			first = size
+		}
+		if size == 0 || size > first || size < first && i < len(files)-1 {
+			return fmt.Errorf("%s is %d bytes, expected %d: %w", file.Name(), size, first, ErrVolumeOrder)
+		}
+	}
+
+	return nil
+}
+
+// JoinFiles returns a reader over the concatenation of files.
+func JoinFiles(files []*os.File) (*io.SectionReader, error) {
+	parts := make([]*io.SectionReader, 0, len(files))
+	for _, file := range files {
+		info, err := file.Stat()
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat %s: %w", file.Name(), err)
+		}
+		parts = append(parts, io.NewSectionReader(file, 0, info.Size()))
+	}
+
+	return JoinReaders(parts...), nil
+}
+
+// NewArchiveFile returns the archive called name read through r, which
+// reads files. Closing it closes the files.
+func NewArchiveFile(name string, r *io.SectionReader, files []*os.File) *ArchiveFile {
+	return &ArchiveFile{SectionReader: r, files: files, name: name}
+}
+
+// Name returns the path the archive was opened with.
+func (f *ArchiveFile) Name() string {
+	return f.name
+}
+
+// Volumes returns the number of files the archive is joined from.
+func (f *ArchiveFile) Volumes() int {
+	return len(f.files)
+}
+
+// Close closes every volume.
+func (f *ArchiveFile) Close() error {
+	return closeFiles(f.files)
+}
+
+func closeFiles(files []*os.File) error {
+	var err error
+	for _, file := range files {
+		err = errors.Join(err, file.Close())
+	}
+	return err
+}
+
+// JoinReaders returns a reader over the concatenation of parts.
+func JoinReaders(parts ...*io.SectionReader) *io.SectionReader {
+	if len(parts) == 1 {
+		return parts[0]
+	}
+
+	joined := &joinedReader{parts: parts}
+	for _, part := range parts {
+		joined.size += part.Size()
+	}
+
+	return io.NewSectionReader(joined, 0, joined.size)
+}
+
+type joinedReader struct {
+	parts []*io.SectionReader
+	size  int64
+}
+
+func (j *joinedReader) ReadAt(p []byte, off int64) (int, error) {
+	read := 0
+
+	for _, part := range j.parts {
+		if len(p) == 0 {
+			break
+		}
+		if off >= part.Size() {
+			off -= part.Size()
+			continue
+		}
+
+		n, err := part.ReadAt(p[:min(int64(len(p)), part.Size()-off)], off)
+		read += n
+		if err != nil && err != io.EOF {
+			return read, err
+		}
+		p = p[n:]
+		off = 0
+	}
+
+	if len(p) > 0 {
+		return read, io.EOF
+	}
+	return read, nil
+}
@@ -0,0 +1,203 @@
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeSplit writes data to path split into MinSplitSize volumes.
+func writeSplit(t *testing.T, path string, data []byte) {
+	t.Helper()
+
+	dst, err := CreateDestination(path, MinSplitSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dst.Write(data); err != nil {
+		dst.Close()
+		t.Fatal(err)
+	}
+	if err := dst.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readArchive(t *testing.T, path string) []byte {
+	t.Helper()
+
+	file, err := OpenArchive(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.NewSectionReader(file, 0, file.Size()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestVolumesRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "x.tar.xz")
+	data := randomBytes(3*MinSplitSize + 100)
+	writeSplit(t, path, data)
+
+	for index, size := range []int64{MinSplitSize, MinSplitSize, MinSplitSize, 100} {
+		info, err := os.Stat(VolumeName(path, index+1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != size {
+			t.Errorf("volume %d is %d bytes, want %d", index+1, info.Size(), size)
+		}
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("unsplit %s written alongside the volumes", path)
+	}
+
+	if got := readArchive(t, VolumeName(path, 1)); !bytes.Equal(got, data) {
+		t.Error("joined volumes differ from the data written")
+	}
+}
+
+func TestVolumesCommitRemovesStale(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "x.tar")
+	writeSplit(t, path, randomBytes(4*MinSplitSize))
+
+	data := randomBytes(MinSplitSize + 1)
+	writeSplit(t, path, data)
+
+	for index := 3; index <= 4; index++ {
+		if _, err := os.Stat(VolumeName(path, index)); !os.IsNotExist(err) {
+			t.Errorf("stale volume %d left behind: %v", index, err)
+		}
+	}
+	if got := readArchive(t, VolumeName(path, 1)); !bytes.Equal(got, data) {
+		t.Error("joined volumes differ from the data written last")
+	}
+}
+
+func TestOpenArchiveVolumeErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "x.tar.gz")
+	writeSplit(t, path, randomBytes(3*MinSplitSize+1))
+
+	if _, err := OpenArchive(VolumeName(path, 2)); !errors.Is(err, ErrNotFirstVolume) {
+		t.Errorf("OpenArchive(middle volume) = %v, want %v", err, ErrNotFirstVolume)
+	}
+
+	if err := os.Truncate(VolumeName(path, 2), MinSplitSize-1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenArchive(VolumeName(path, 1)); !errors.Is(err, ErrVolumeOrder) {
+		t.Errorf("OpenArchive with a short volume = %v, want %v", err, ErrVolumeOrder)
+	}
+
+	if err := os.Remove(VolumeName(path, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenArchive(VolumeName(path, 1)); !errors.Is(err, ErrMissingVolume) {
+		t.Errorf("OpenArchive with a missing volume = %v, want %v", err, ErrMissingVolume)
+	}
+}
+
+func TestIsSplit(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"x.tar.001", true},
+		{"x.tar.xz.002", true},
+		{"x.tar.bz2.010", true},
+		{"x.tgz.001", true},
+		{"x.tar.gz.age.001", true},
+		{"x.zip.001", true},
+		{"nightly.tar.2024", false},
+		{"x.tar.xz", false},
+		{"photo.001", false},
+		{"x.tar.01", false},
+	}
+
+	for _, test := range tests {
+		if got := IsSplit(test.path); got != test.want {
+			t.Errorf("IsSplit(%q) = %v, want %v", test.path, got, test.want)
+		}
+	}
+
+	if got := TrimVolume("dir/x.tar.xz.001"); got != "dir/x.tar.xz" {
+		t.Errorf("TrimVolume = %q, want %q", got, "dir/x.tar.xz")
+	}
+}
+
+// A volume that can't be moved into place takes the ones before it back
+// out, leaving no partial set behind.
+func TestVolumesCommitFailure(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "x.tar")
+
+	// A non-empty directory can't be replaced by a rename.
+	blocker := VolumeName(path, 2)
+	if err := os.MkdirAll(filepath.Join(blocker, "keep"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	dst, err := CreateDestination(path, MinSplitSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dst.Write(randomBytes(3 * MinSplitSize)); err != nil {
+		dst.Close()
+		t.Fatal(err)
+	}
+	if err := dst.Commit(); err == nil {
+		t.Fatal("Commit over a directory succeeded")
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != filepath.Base(blocker) {
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		t.Errorf("left %v after a failed commit, want only the blocking directory", names)
+	}
+}
+
+func TestCheckModifiableSplit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "x.tar")
+	writeSplit(t, path, randomBytes(2*MinSplitSize+1))
+
+	for _, name := range []string{path, VolumeName(path, 1), VolumeName(path, 2)} {
+		if err := CheckModifiable(name); !errors.Is(err, ErrSplitModify) {
+			t.Errorf("CheckModifiable(%s) = %v, want %v", filepath.Base(name), err, ErrSplitModify)
+		}
+	}
+
+	if err := CheckModifiable(filepath.Join(filepath.Dir(path), "other.tar")); errors.Is(err, ErrSplitModify) {
+		t.Errorf("CheckModifiable of a missing archive = %v", err)
+	}
+}
+
+func TestDetectFileFormatSplit(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "x.tar")
+
+	// A ustar header, so the joined volumes sniff as a tar archive.
+	data := randomBytes(2*MinSplitSize + 1)
+	copy(data[257:], "ustar\x0000")
+	writeSplit(t, src, data)
+
+	if format, err := DetectFileFormat(VolumeName(src, 1)); err != nil || format != FormatTar {
+		t.Errorf("DetectFileFormat(first volume) = %q, %v; want %q", format, err, FormatTar)
+	}
+	if _, err := DetectFileFormat(VolumeName(src, 2)); !errors.Is(err, ErrNotFirstVolume) {
+		t.Errorf("DetectFileFormat(second volume) = %v, want %v", err, ErrNotFirstVolume)
+	}
+}
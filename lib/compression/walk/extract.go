@@ -0,0 +1,114 @@
+package walk
+
+import (
+	"archive/tar"
+	"archivist/lib/compression"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ExtractOptions configures Extract.
+type ExtractOptions struct {
+	Options
+	// Extractor applies the overwrite and backup policy to existing files.
+	// Nil overwrites them.
+	Extractor *compression.Extractor
+	// VerifyManifest checks extracted files against their recorded digests.
+	VerifyManifest bool
+	// Logger receives a record per entry and warnings about skipped
+	// entries. Nil discards them.
+	Logger *slog.Logger
+}
+
+// Extract writes the entries of the archive at archivePath into outputDir.
+// A nested archive is extracted in its place, into a directory named after
+// it without its extension, so lib/core.tar.gz!/a ends up in lib/core/a.
+func Extract(archivePath, format, outputDir string, opts ExtractOptions) error {
+	logger := compression.Logger(opts.Logger)
+
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory %s: %w", outputDir, err)
+	}
+
+	// dirs maps the address of each archive to the directory it unpacks to.
+	dirs := map[string]string{"": outputDir}
+
+	return Walk(archivePath, format, opts.Options, func(entry Entry, body io.Reader) error {
+		if strings.Contains(entry.Name, "..") {
+			logger.Warn(compression.LogSkipped, compression.LogEntryKey, entry.Path)
+			if entry.Format != "" {
+				return SkipArchive
+			}
+			return nil
+		}
+		logger.Info(compression.LogExtracting, compression.LogEntryKey, entry.Path)
+
+		dir := dirs[entry.Archive()]
+
+		if entry.Format != "" {
+			dirs[entry.Path] = filepath.Join(dir, filepath.FromSlash(ArchiveDir(entry.Name)))
+			return nil
+		}
+
+		targetPath := filepath.Join(dir, filepath.FromSlash(entry.Name))
+
+		switch entry.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(targetPath, os.FileMode(entry.Mode)); err != nil {
+				return fmt.Errorf("failed to create directory %s: %w", targetPath, err)
+			}
+		case tar.TypeReg:
+			return extractFile(entry, body, targetPath, opts)
+		}
+
+		return nil
+	})
+}
+
+func extractFile(entry Entry, body io.Reader, targetPath string, opts ExtractOptions) error {
+	targetFile, err := opts.Extractor.Create(targetPath, os.FileMode(entry.Mode), entry.ModTime)
+	if err != nil {
+		return err
+	}
+	if targetFile == nil {
+		return nil
+	}
+	defer targetFile.Close()
+
+	digest := compression.NewDigest()
+	var dst io.Writer = targetFile
+	if opts.VerifyManifest {
+		dst = io.MultiWriter(targetFile, digest)
+	}
+
+	if _, err := io.Copy(dst, body); err != nil {
+		return fmt.Errorf("failed to write file %s: %w", targetPath, err)
+	}
+
+	if opts.VerifyManifest {
+		if err := compression.CheckDigest(digest, entry.PAXRecords[compression.PAXDigestKey]); err != nil {
+			return fmt.Errorf("failed to verify file %s: %w", targetPath, err)
+		}
+	}
+
+	return targetFile.Commit()
+}
+
+// ArchiveDir returns the directory a nested archive called name unpacks to:
+// its name without the archive extension, or with ".d" appended when it has
+// none.
+func ArchiveDir(name string) string {
+	base := path.Base(name)
+	dir := strings.TrimSuffix(base, path.Ext(base))
+	dir = strings.TrimSuffix(dir, ".tar")
+	if dir == "" || dir == base {
+		dir = base + ".d"
+	}
+
+	return path.Join(path.Dir(name), dir)
+}
@@ -0,0 +1,33 @@
+package walk
+
+import (
+	"archive/tar"
+	"archivist/lib/compression"
+	"io"
+)
+
+// Test reads every archive nested in the archive at archivePath, checking
+// the entries that carry a digest record against it. The outer archive's
+// own entries are left to the format's Tester.
+func Test(archivePath, format string, opts Options) error {
+	opts.Recursive = true
+
+	return Walk(archivePath, format, opts, func(entry Entry, body io.Reader) error {
+		if entry.Depth == 0 || entry.Format != "" || entry.Typeflag != tar.TypeReg {
+			return nil
+		}
+
+		digest := compression.NewDigest()
+		if _, err := io.Copy(digest, body); err != nil {
+			return &compression.EntryError{Name: entry.Path, Err: err}
+		}
+
+		if expected, ok := entry.PAXRecords[compression.PAXDigestKey]; ok {
+			if err := compression.CheckDigest(digest, expected); err != nil {
+				return &compression.EntryError{Name: entry.Path, Err: err}
+			}
+		}
+
+		return nil
+	})
+}
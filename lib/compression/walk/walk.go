@@ -0,0 +1,193 @@
+// Package walk reads the entries of an archive and, optionally, of the
+// archives nested inside it, such as a tar.gz inside a zip. Nested archives
+// are read as they stream out of their parent, within limits that guard
+// against archive bombs.
+package walk
+
+import (
+	"archive/tar"
+	"archivist/lib/compression"
+	"archivist/lib/compression/convert"
+	"bufio"
+	"errors"
+	"filippo.io/age"
+	"fmt"
+	"io"
+)
+
+// Default limits on nested archives.
+const (
+	DefaultMaxDepth   = 8
+	DefaultMaxSize    = 16 << 30
+	DefaultMaxEntries = 1 << 20
+)
+
+var ErrDepthLimit = errors.New("archives are nested too deeply")
+
+var ErrSizeLimit = errors.New("nested archives expand beyond the size limit")
+
+var ErrEntryLimit = errors.New("nested archives hold too many entries")
+
+// SkipArchive, returned by the callback for a nested archive, skips its
+// entries.
+var SkipArchive = errors.New("skip this archive")
+
+// Options configures a walk.
+type Options struct {
+	// Identities decrypt an age-encrypted outer archive.
+	Identities []age.Identity
+	// Recursive descends into zip and tar archives found among the entries.
+	Recursive bool
+	// MaxDepth limits how deep archives may be nested. Zero uses
+	// DefaultMaxDepth.
+	MaxDepth int
+	// MaxSize limits the bytes read from nested archives and their entries,
+	// once decompressed. Zero uses DefaultMaxSize.
+	MaxSize int64
+	// MaxEntries limits the entries read from nested archives. Zero uses
+	// DefaultMaxEntries.
+	MaxEntries int
+}
+
+// Entry is an entry of the archive or of an archive nested in it.
+type Entry struct {
+	*tar.Header
+	// Path addresses the entry from the outer archive, joining the names of
+	// the archives it is nested in with compression.NestedSeparator, as in
+	// lib.tar.gz!/dir/file.
+	Path string
+	// Depth counts the archives the entry is nested in; the outer archive's
+	// own entries are at depth 0.
+	Depth int
+	// Format is set when the entry is a nested archive. Its entries follow
+	// it, and its data isn't passed to the callback.
+	Format string
+}
+
+// Archive returns the address of the archive holding the entry, "" for the
+// outer archive.
+func (e Entry) Archive() string {
+	parent := e.Path[:len(e.Path)-len(e.Name)]
+	return parent[:max(len(parent)-len(compression.NestedSeparator), 0)]
+}
+
+// NestedError reports an error inside a nested archive.
+type NestedError struct {
+	Path string
+	Err  error
+}
+
+func (e *NestedError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Path, e.Err)
+}
+
+func (e *NestedError) Unwrap() error {
+	return e.Err
+}
+
+// Walk passes every entry of the archive at archivePath, in format, to fn
+// with a reader for its data. With opts.Recursive, nested archives are
+// passed to fn and then walked in turn.
+func Walk(archivePath, format string, opts Options, fn func(Entry, io.Reader) error) error {
+	w := &walker{opts: opts, fn: fn}
+	if w.opts.MaxDepth == 0 {
+		w.opts.MaxDepth = DefaultMaxDepth
+	}
+	if w.opts.MaxSize == 0 {
+		w.opts.MaxSize = DefaultMaxSize
+	}
+	if w.opts.MaxEntries == 0 {
+		w.opts.MaxEntries = DefaultMaxEntries
+	}
+
+	return convert.Read(archivePath, format, opts.Identities, w.visit("", 0))
+}
+
+type walker struct {
+	opts    Options
+	fn      func(Entry, io.Reader) error
+	size    int64
+	entries int
+}
+
+// visit returns the callback reading the entries of the archive at prefix,
+// nested depth archives deep.
+func (w *walker) visit(prefix string, depth int) func(*tar.Header, io.Reader) error {
+	return func(header *tar.Header, body io.Reader) error {
+		entry := Entry{Header: header, Path: prefix + header.Name, Depth: depth}
+
+		if depth > 0 {
+			if w.entries++; w.entries > w.opts.MaxEntries {
+				return &NestedError{Path: entry.Path, Err: ErrEntryLimit}
+			}
+			body = &limitedReader{w: w, r: body, path: entry.Path}
+		}
+
+		if w.opts.Recursive && header.Typeflag == tar.TypeReg {
+			reader := bufio.NewReaderSize(body, compression.SniffLen)
+			sniffed, _ := reader.Peek(compression.SniffLen)
+			entry.Format = compression.NestedFormat(header.Name, sniffed)
+			body = reader
+		}
+
+		if entry.Format != "" {
+			return w.descend(entry, body)
+		}
+
+		if err := w.fn(entry, body); err != nil {
+			return err
+		}
+
+		// Whatever the callback left unread still counts against the limit.
+		if depth > 0 {
+			if _, err := io.Copy(io.Discard, body); err != nil {
+				return &NestedError{Path: entry.Path, Err: err}
+			}
+		}
+
+		return nil
+	}
+}
+
+func (w *walker) descend(entry Entry, body io.Reader) error {
+	if entry.Depth+1 > w.opts.MaxDepth {
+		return &NestedError{Path: entry.Path, Err: ErrDepthLimit}
+	}
+
+	err := w.fn(entry, nil)
+	if err == SkipArchive {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if entry.Depth == 0 {
+		body = &limitedReader{w: w, r: body, path: entry.Path}
+	}
+
+	err = convert.ReadStream(body, entry.Format, nil, w.visit(entry.Path+compression.NestedSeparator, entry.Depth+1))
+	var nested *NestedError
+	if err != nil && !errors.As(err, &nested) {
+		return &NestedError{Path: entry.Path, Err: err}
+	}
+
+	return err
+}
+
+// limitedReader counts the bytes read from nested archives against
+// Options.MaxSize.
+type limitedReader struct {
+	w    *walker
+	r    io.Reader
+	path string
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.w.size += int64(n)
+	if l.w.size > l.w.opts.MaxSize {
+		return n, &NestedError{Path: l.path, Err: ErrSizeLimit}
+	}
+	return n, err
+}
@@ -0,0 +1,216 @@
+package walk
+
+import (
+	"archive/tar"
+	"archivist/lib/compression"
+	tarformat "archivist/lib/compression/tar"
+	"archivist/lib/compression/tar_gz"
+	zipformat "archivist/lib/compression/zip"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeFiles writes files, by slash-separated name, under dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// nestedArchive packs a tar.gz into a zip and the zip into a tar, and
+// returns the path of the tar:
+//
+//	top.tar
+//	  top/outer.zip
+//	    pkg/inner.tar.gz
+//	      in/x
+//	    pkg/log.gz
+//	    pkg/readme.txt
+func nestedArchive(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+
+	writeFiles(t, dir, map[string]string{"in/x": "a needle in a tar.gz", "pkg/readme.txt": "a needle in a zip"})
+	if err := tar_gz.New(filepath.Join(dir, "pkg", "inner.tar.gz")).Encode([]string{filepath.Join(dir, "in")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A gzipped file that isn't a tar archive stays a plain member.
+	log, err := os.Create(filepath.Join(dir, "pkg", "log.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(log)
+	gz.Write([]byte("a needle in a log"))
+	gz.Close()
+	log.Close()
+
+	if err := os.MkdirAll(filepath.Join(dir, "top"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := zipformat.New(filepath.Join(dir, "top", "outer.zip")).Encode([]string{filepath.Join(dir, "pkg")}); err != nil {
+		t.Fatal(err)
+	}
+
+	archive := filepath.Join(dir, "top.tar")
+	if err := tarformat.New(archive).Encode([]string{filepath.Join(dir, "top")}); err != nil {
+		t.Fatal(err)
+	}
+	return archive
+}
+
+func TestWalkRecursive(t *testing.T) {
+	archive := nestedArchive(t)
+
+	type visited struct {
+		Path, Archive, Format string
+		Depth                 int
+		Data                  string
+	}
+	var got []visited
+	err := Walk(archive, compression.FormatTar, Options{Recursive: true}, func(entry Entry, body io.Reader) error {
+		if entry.Typeflag != tar.TypeReg {
+			return nil
+		}
+		v := visited{Path: entry.Path, Archive: entry.Archive(), Format: entry.Format, Depth: entry.Depth}
+		if body != nil && entry.Format == "" && filepath.Ext(entry.Name) != ".gz" {
+			data, err := io.ReadAll(body)
+			if err != nil {
+				return err
+			}
+			v.Data = string(data)
+		}
+		got = append(got, v)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk: %v", err)
+	}
+
+	want := []visited{
+		{Path: "top/outer.zip", Format: compression.FormatZip},
+		{Path: "top/outer.zip!/pkg/inner.tar.gz", Archive: "top/outer.zip", Format: compression.FormatTarGz, Depth: 1},
+		{Path: "top/outer.zip!/pkg/inner.tar.gz!/in/x", Archive: "top/outer.zip!/pkg/inner.tar.gz", Depth: 2, Data: "a needle in a tar.gz"},
+		{Path: "top/outer.zip!/pkg/log.gz", Archive: "top/outer.zip", Depth: 1},
+		{Path: "top/outer.zip!/pkg/readme.txt", Archive: "top/outer.zip", Depth: 1, Data: "a needle in a zip"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Walk visited\n%+v\nwant\n%+v", got, want)
+	}
+
+	// Without Recursive the zip is an ordinary member.
+	var paths []string
+	err = Walk(archive, compression.FormatTar, Options{}, func(entry Entry, body io.Reader) error {
+		if entry.Format != "" {
+			t.Errorf("%s has format %s without Recursive", entry.Path, entry.Format)
+		}
+		paths = append(paths, entry.Path)
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(paths, []string{"top", "top/outer.zip"}) {
+		t.Errorf("Walk without Recursive = %q, %v", paths, err)
+	}
+}
+
+func TestWalkSkipArchive(t *testing.T) {
+	archive := nestedArchive(t)
+
+	var paths []string
+	err := Walk(archive, compression.FormatTar, Options{Recursive: true}, func(entry Entry, body io.Reader) error {
+		paths = append(paths, entry.Path)
+		if entry.Format == compression.FormatTarGz {
+			return SkipArchive
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk: %v", err)
+	}
+	for _, path := range paths {
+		if path == "top/outer.zip!/pkg/inner.tar.gz!/in/x" {
+			t.Errorf("walked into a skipped archive: %q", paths)
+		}
+	}
+}
+
+func TestWalkLimits(t *testing.T) {
+	archive := nestedArchive(t)
+
+	limits := []struct {
+		name string
+		opts Options
+		err  error
+	}{
+		{"depth", Options{MaxDepth: 1}, ErrDepthLimit},
+		{"size", Options{MaxSize: 100}, ErrSizeLimit},
+		{"entries", Options{MaxEntries: 2}, ErrEntryLimit},
+	}
+
+	for _, limit := range limits {
+		t.Run(limit.name, func(t *testing.T) {
+			opts := limit.opts
+			opts.Recursive = true
+			err := Walk(archive, compression.FormatTar, opts, func(Entry, io.Reader) error { return nil })
+
+			var nested *NestedError
+			if !errors.Is(err, limit.err) || !errors.As(err, &nested) {
+				t.Fatalf("Walk = %v, want a NestedError with %v", err, limit.err)
+			}
+			if limit.err == ErrDepthLimit && nested.Path != "top/outer.zip!/pkg/inner.tar.gz" {
+				t.Errorf("depth limit reported at %s", nested.Path)
+			}
+		})
+	}
+}
+
+// Nested archives unpack into directories named after them.
+func TestExtractRecursive(t *testing.T) {
+	archive := nestedArchive(t)
+	out := filepath.Join(t.TempDir(), "out")
+
+	if err := Extract(archive, compression.FormatTar, out, ExtractOptions{Options: Options{Recursive: true}}); err != nil {
+		t.Fatalf("Extract: %v", err)
+	}
+
+	files := map[string]string{
+		"top/outer/pkg/inner/in/x": "a needle in a tar.gz",
+		"top/outer/pkg/readme.txt": "a needle in a zip",
+	}
+	for name, want := range files {
+		data, err := os.ReadFile(filepath.Join(out, filepath.FromSlash(name)))
+		if err != nil || string(data) != want {
+			t.Errorf("%s = %q, %v; want %q", name, data, err, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(out, "top", "outer", "pkg", "log.gz")); err != nil {
+		t.Errorf("log.gz not extracted as a file: %v", err)
+	}
+}
+
+func TestArchiveDir(t *testing.T) {
+	names := map[string]string{
+		"lib/core.tar.gz": "lib/core",
+		"a.zip":           "a",
+		"b.tgz":           "b",
+		"data.tar":        "data",
+		"noext":           "noext.d",
+	}
+	for name, want := range names {
+		if got := ArchiveDir(name); got != want {
+			t.Errorf("ArchiveDir(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
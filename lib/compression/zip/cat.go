@@ -0,0 +1,58 @@
+package zip
+
+import (
+	"archive/zip"
+	"archivist/lib/compression"
+	"fmt"
+	"io"
+)
+
+// Cat streams member to w, found through the central directory without
+// reading any other entry. Its data is checked against MANIFEST.sha256 when
+// the archive has one.
+func (ed *EncodeDecoder) Cat(member string, w io.Writer) error {
+	reader, err := OpenReader(ed.OutputPath)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	name := compression.MemberName(member)
+	var file *zip.File
+	for _, f := range reader.File {
+		if compression.MemberName(f.Name) == name {
+			file = f
+		}
+	}
+	if file == nil {
+		return fmt.Errorf("%w: %s", compression.ErrMemberNotFound, member)
+	}
+	if !file.Mode().IsRegular() {
+		return fmt.Errorf("%w: %s", compression.ErrNotRegular, member)
+	}
+
+	digests, err := readManifest(reader.Reader)
+	if err != nil {
+		return err
+	}
+
+	rc, err := file.Open()
+	if err != nil {
+		return fmt.Errorf("failed to open file %s in zip: %w", file.Name, err)
+	}
+	defer rc.Close()
+
+	digest := compression.NewDigest()
+	if _, err := io.Copy(io.MultiWriter(w, digest), rc); err != nil {
+		return &compression.EntryError{Name: file.Name, Err: err}
+	}
+
+	if digests == nil || file.Name == compression.ManifestName {
+		return nil
+	}
+	if err := compression.CheckDigest(digest, digests[file.Name]); err != nil {
+		return &compression.EntryError{Name: file.Name, Err: err}
+	}
+
+	return nil
+}
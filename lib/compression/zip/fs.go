@@ -0,0 +1,218 @@
+package zip
+
+import (
+	"archive/zip"
+	"archivist/lib/compression"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+)
+
+// maxLinkSize bounds the target read from a symlink entry.
+const maxLinkSize = 4096
+
+// FS is a read-only file system over a zip archive. Members are found
+// through the central directory; directories the archive doesn't store
+// are implied by the members inside them.
+type FS struct {
+	reader *ReadCloser
+	files  map[string]*zip.File
+}
+
+// OpenFS opens the archive as a read-only file system.
+func (ed *EncodeDecoder) OpenFS() (compression.ArchiveFS, error) {
+	reader, err := OpenReader(ed.OutputPath)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]*zip.File, len(reader.File))
+	for _, file := range reader.File {
+		if name := compression.MemberName(file.Name); name != "" {
+			files[name] = file
+		}
+	}
+
+	return &FS{reader: reader, files: files}, nil
+}
+
+// Open opens the named member, following symlinks.
+func (f *FS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	resolved, err := compression.FollowLinks(name, f.linkTarget)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	file, ok := f.files[resolved]
+	if !ok || file.FileInfo().IsDir() {
+		// archive/zip lists directories, including implied ones.
+		return f.reader.Open(resolved)
+	}
+
+	return &seekFile{file: file, info: file.FileInfo()}, nil
+}
+
+// Stat returns the file info of the named member, following symlinks.
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	file, err := f.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return file.Stat()
+}
+
+// ReadDir lists the named directory, sorted by name.
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	file, err := f.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	dir, ok := file.(fs.ReadDirFile)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+
+	return dir.ReadDir(-1)
+}
+
+// ReadLink returns the target of the named symlink.
+func (f *FS) ReadLink(name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+
+	target, err := f.linkTarget(name)
+	if err == nil && target == "" {
+		err = fs.ErrInvalid
+	}
+	if err != nil {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: err}
+	}
+
+	return target, nil
+}
+
+// Lstat returns the file info of the named member without following a
+// final symlink.
+func (f *FS) Lstat(name string) (fs.FileInfo, error) {
+	if file, ok := f.files[name]; ok && fs.ValidPath(name) && !file.FileInfo().IsDir() {
+		return file.FileInfo(), nil
+	}
+	return f.Stat(name)
+}
+
+// Close closes the archive.
+func (f *FS) Close() error {
+	return f.reader.Close()
+}
+
+// linkTarget returns the target stored as the data of a symlink entry.
+func (f *FS) linkTarget(name string) (string, error) {
+	file, ok := f.files[name]
+	if !ok {
+		// Directories, including implied ones, are no links. Missing members
+		// are reported by archive/zip when opened.
+		return "", nil
+	}
+	if file.Mode()&fs.ModeSymlink == 0 {
+		return "", nil
+	}
+
+	return readLink(file)
+}
+
+// readLink returns the target stored as the data of the symlink entry
+// file, the way Info-ZIP stores it.
+func readLink(file *zip.File) (string, error) {
+	rc, err := file.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	target, err := io.ReadAll(io.LimitReader(rc, maxLinkSize))
+	if err != nil {
+		return "", err
+	}
+
+	return string(target), nil
+}
+
+// seekFile is an open zip member. Deflated data can't be seeked, so a seek
+// only moves the position; the next read reopens the member when it has to
+// go back and skips forward to the position.
+type seekFile struct {
+	file *zip.File
+	info fs.FileInfo
+	rc   io.ReadCloser
+	read int64
+	pos  int64
+}
+
+func (f *seekFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *seekFile) Read(p []byte) (int, error) {
+	if f.pos >= int64(f.file.UncompressedSize64) {
+		return 0, io.EOF
+	}
+
+	if f.rc == nil || f.pos < f.read {
+		if f.rc != nil {
+			f.rc.Close()
+		}
+		rc, err := f.file.Open()
+		if err != nil {
+			return 0, err
+		}
+		f.rc, f.read = rc, 0
+	}
+
+	if f.pos > f.read {
+		n, err := io.CopyN(io.Discard, f.rc, f.pos-f.read)
+		f.read += n
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.rc.Read(p)
+	f.read += int64(n)
+	f.pos = f.read
+	return n, err
+}
+
+func (f *seekFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += int64(f.file.UncompressedSize64)
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative position %d", offset)
+	}
+
+	f.pos = offset
+	return offset, nil
+}
+
+func (f *seekFile) Close() error {
+	if f.rc == nil {
+		return nil
+	}
+	return f.rc.Close()
+}
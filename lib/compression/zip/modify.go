@@ -0,0 +1,100 @@
+package zip
+
+import (
+	"archive/zip"
+	"archivist/lib/compression"
+	"fmt"
+)
+
+// Modify writes a new central directory over the surviving entries, which
+// are copied still compressed, followed by the added files. An existing
+// MANIFEST.sha256 is regenerated to match.
+func (ed *EncodeDecoder) Modify(edit compression.Edit) error {
+	logger := compression.Logger(ed.Logger)
+
+	if IsSplit(ed.OutputPath) {
+		return fmt.Errorf("%s: %w", ed.OutputPath, compression.ErrSplitModify)
+	}
+
+	reader, err := zip.OpenReader(ed.OutputPath)
+	if err != nil {
+		return fmt.Errorf("failed to open zip archive %s: %w", ed.OutputPath, err)
+	}
+	defer reader.Close()
+
+	digests, err := readManifest(&reader.Reader)
+	if err != nil {
+		return err
+	}
+
+	out, err := compression.CreateArchive(ed.OutputPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	archive := zip.NewWriter(out)
+	defer archive.Close()
+
+	plan := edit.Plan()
+	var manifest []compression.ManifestEntry
+
+	for _, file := range reader.File {
+		if file.Name == compression.ManifestName || !plan.Keep(file.Name, file.Modified) {
+			continue
+		}
+
+		if err := archive.Copy(file); err != nil {
+			return fmt.Errorf("failed to copy zip entry %s: %w", file.Name, err)
+		}
+
+		if digest := digests[file.Name]; digest != "" {
+			manifest = append(manifest, compression.ManifestEntry{Name: file.Name, Digest: digest})
+		}
+	}
+
+	if err := plan.Err(); err != nil {
+		return err
+	}
+
+	for _, source := range plan.Pending() {
+		// Like Encode, only files get entries.
+		if source.Info.IsDir() {
+			continue
+		}
+
+		header, err := zip.FileInfoHeader(source.Info)
+		if err != nil {
+			return fmt.Errorf("failed to create zip header for %s: %w", source.Path, err)
+		}
+		header.Name = source.Name
+		header.Method = zip.Deflate
+
+		logger.Info(compression.LogAdding, compression.LogEntryKey, header.Name)
+		digest, err := writeFile(archive, header, source, nil, digests != nil)
+		if err != nil {
+			return err
+		}
+
+		if digests != nil {
+			manifest = append(manifest, compression.ManifestEntry{Name: header.Name, Digest: digest})
+		}
+	}
+
+	if digests != nil {
+		writer, err := archive.Create(compression.ManifestName)
+		if err != nil {
+			return fmt.Errorf("failed to create zip entry for %s: %w", compression.ManifestName, err)
+		}
+
+		if err := compression.WriteManifest(writer, manifest); err != nil {
+			return fmt.Errorf("failed to write %s to zip: %w", compression.ManifestName, err)
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return fmt.Errorf("failed to write zip central directory: %w", err)
+	}
+
+	return out.Commit()
+}
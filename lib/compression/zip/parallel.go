@@ -0,0 +1,365 @@
+package zip
+
+import (
+	"archive/zip"
+	"archivist/lib/compression"
+	"archivist/lib/compression/parallel"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// DefaultMemoryBudget caps the memory held by entries in the parallel
+// encoder when no budget is set.
+const DefaultMemoryBudget = 256 << 20
+
+// spillShare is the fraction of the budget, as a divisor, above which an
+// entry spills to a temporary file, so that one entry can't hold the whole
+// budget and leave the other workers waiting.
+const spillShare = 4
+
+// flateLevel matches the level archive/zip uses for Deflate entries.
+const flateLevel = 5
+
+// compressedEntry is a file deflated ahead of time, ready for CreateRaw.
+type compressedEntry struct {
+	header   *zip.FileHeader
+	data     []byte
+	spill    *os.File
+	digest   string
+	reserved int64
+}
+
+// parallelWriter deflates entries on a worker pool and writes them to the
+// archive in walk order. Entries too large for the memory budget are
+// spilled to temporary files.
+type parallelWriter struct {
+	archive  *zip.Writer
+	pipe     *parallel.Pipeline[*compressedEntry]
+	budget   *memoryBudget
+	manifest []compression.ManifestEntry
+	digests  bool
+	tracker  *compression.Tracker
+	closed   bool
+
+	mu     sync.Mutex
+	spills map[*os.File]struct{}
+}
+
+func newParallelWriter(archive *zip.Writer, threads int, budget int64, digests bool, tracker *compression.Tracker) *parallelWriter {
+	if budget <= 0 {
+		budget = DefaultMemoryBudget
+	}
+
+	pw := &parallelWriter{
+		archive: archive,
+		budget:  newMemoryBudget(budget),
+		digests: digests,
+		tracker: tracker,
+		spills:  make(map[*os.File]struct{}),
+	}
+	pw.pipe = parallel.NewPipeline(threads, pw.write)
+	pw.pipe.Discard = pw.discard
+
+	return pw
+}
+
+// add schedules source for compression under header. An entry kept in
+// memory reserves its uncompressed size while it is deflated, which bounds
+// its output, and then only keeps what the compressed data takes.
+func (pw *parallelWriter) add(header *zip.FileHeader, source compression.Source) error {
+	size := int64(header.UncompressedSize64)
+
+	spill := size > pw.budget.total/spillShare
+	reserved := int64(0)
+	if !spill {
+		reserved = size
+		pw.budget.acquire(reserved)
+	}
+
+	return pw.pipe.Submit(func() (*compressedEntry, error) {
+		entry, err := pw.compress(header, source, spill)
+		if err != nil {
+			pw.budget.release(reserved)
+			return nil, err
+		}
+		entry.reserved = min(int64(len(entry.data)), reserved)
+		pw.budget.release(reserved - entry.reserved)
+		return entry, nil
+	})
+}
+
+// close waits for every entry to be written.
+func (pw *parallelWriter) close() error {
+	if pw.closed {
+		return nil
+	}
+	pw.closed = true
+
+	return pw.pipe.Close()
+}
+
+// cleanup stops the workers and removes spill files left by a failed run.
+func (pw *parallelWriter) cleanup() {
+	_ = pw.close()
+
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	for spill := range pw.spills {
+		spill.Close()
+		os.Remove(spill.Name())
+	}
+}
+
+func (pw *parallelWriter) compress(header *zip.FileHeader, source compression.Source, spill bool) (*compressedEntry, error) {
+	file, err := openSource(source)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	pw.tracker.Start(header.Name)
+
+	entry := &compressedEntry{header: header}
+
+	var buf bytes.Buffer
+	var dst io.Writer = &buf
+	if spill {
+		if entry.spill, err = pw.createSpill(); err != nil {
+			return nil, err
+		}
+		dst = entry.spill
+	}
+
+	counter := &countingWriter{w: dst}
+	fw, err := flate.NewWriter(counter, flateLevel)
+	if err != nil {
+		return nil, err
+	}
+
+	crc := crc32.NewIEEE()
+	digest := compression.NewDigest()
+	writers := []io.Writer{fw, crc}
+	if pw.digests {
+		writers = append(writers, digest)
+	}
+
+	size, err := io.Copy(io.MultiWriter(writers...), pw.tracker.Reader(file))
+	if err != nil {
+		return nil, fmt.Errorf("failed to compress file %s: %w", source.Path, err)
+	}
+	if err := fw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress file %s: %w", source.Path, err)
+	}
+
+	header.CRC32 = crc.Sum32()
+	header.UncompressedSize64 = uint64(size)
+	header.CompressedSize64 = uint64(counter.n)
+	prepareRawHeader(header)
+
+	entry.data = buf.Bytes()
+	if pw.digests {
+		entry.digest = hex.EncodeToString(digest.Sum(nil))
+	}
+
+	return entry, nil
+}
+
+// write runs on the pipeline goroutine, so entries land in submission order.
+func (pw *parallelWriter) write(entry *compressedEntry) error {
+	defer pw.budget.release(entry.reserved)
+
+	writer, err := pw.archive.CreateRaw(entry.header)
+	if err != nil {
+		return fmt.Errorf("failed to create zip entry for %s: %w", entry.header.Name, err)
+	}
+
+	if entry.spill != nil {
+		defer pw.removeSpill(entry.spill)
+		if _, err := entry.spill.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		_, err = io.Copy(writer, entry.spill)
+	} else {
+		_, err = writer.Write(entry.data)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s to zip: %w", entry.header.Name, err)
+	}
+
+	if pw.digests {
+		pw.manifest = append(pw.manifest, compression.ManifestEntry{
+			Name:   entry.header.Name,
+			Digest: entry.digest,
+		})
+	}
+
+	return nil
+}
+
+// discard releases an entry that won't be written because the run failed.
+func (pw *parallelWriter) discard(entry *compressedEntry) {
+	pw.budget.release(entry.reserved)
+	if entry.spill != nil {
+		pw.removeSpill(entry.spill)
+	}
+}
+
+func (pw *parallelWriter) createSpill() (*os.File, error) {
+	spill, err := os.CreateTemp("", "archivist-zip-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spill file: %w", err)
+	}
+
+	pw.mu.Lock()
+	pw.spills[spill] = struct{}{}
+	pw.mu.Unlock()
+
+	return spill, nil
+}
+
+func (pw *parallelWriter) removeSpill(spill *os.File) {
+	pw.mu.Lock()
+	delete(pw.spills, spill)
+	pw.mu.Unlock()
+
+	spill.Close()
+	os.Remove(spill.Name())
+}
+
+// prepareRawHeader fills in what CreateHeader would derive for us, since
+// CreateRaw writes the header as given.
+func prepareRawHeader(header *zip.FileHeader) {
+	header.CreatorVersion = header.CreatorVersion&0xff00 | 20
+	header.ReaderVersion = 20
+
+	if !isASCII(header.Name) && utf8.ValidString(header.Name) {
+		header.Flags |= 0x800
+	}
+
+	if !header.Modified.IsZero() {
+		header.ModifiedTime, header.ModifiedDate = msDosTime(header.Modified)
+
+		// Extended timestamp extra field, the same one CreateHeader writes.
+		extra := binary.LittleEndian.AppendUint16(nil, 0x5455)
+		extra = binary.LittleEndian.AppendUint16(extra, 5)
+		extra = append(extra, 1)
+		extra = binary.LittleEndian.AppendUint32(extra, uint32(header.Modified.Unix()))
+		header.Extra = append(header.Extra, extra...)
+	}
+}
+
+func msDosTime(t time.Time) (uint16, uint16) {
+	fTime := uint16(t.Second()/2 + t.Minute()<<5 + t.Hour()<<11)
+	fDate := uint16(t.Day() + int(t.Month())<<5 + (t.Year()-1980)<<9)
+	return fTime, fDate
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// memoryBudget bounds the bytes reserved by in-flight entries.
+type memoryBudget struct {
+	total int64
+	used  int64
+	mu    sync.Mutex
+	cond  *sync.Cond
+}
+
+func newMemoryBudget(total int64) *memoryBudget {
+	b := &memoryBudget{total: total}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+func (b *memoryBudget) acquire(n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.used+n > b.total {
+		b.cond.Wait()
+	}
+	b.used += n
+}
+
+func (b *memoryBudget) release(n int64) {
+	if n == 0 {
+		return
+	}
+
+	b.mu.Lock()
+	b.used -= n
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
+
+// extractJob is a file entry whose parent directory already exists.
+type extractJob struct {
+	file       *zip.File
+	targetPath string
+}
+
+// extractParallel fans file entries across workers. Each zip entry is read
+// through its own section of the archive, so workers never share a stream.
+func (ed *EncodeDecoder) extractParallel(jobs []extractJob, digests map[string]string, tracker *compression.Tracker, journal *compression.Journal) error {
+	work := make(chan extractJob)
+	failed := make(chan struct{})
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+
+	for i := 0; i < parallel.Threads(ed.Threads); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range work {
+				if err := ed.extractFile(job.file, job.targetPath, digests, tracker, journal); err != nil {
+					once.Do(func() {
+						firstErr = err
+						close(failed)
+					})
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, job := range jobs {
+		select {
+		case work <- job:
+		case <-failed:
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	return firstErr
+}
@@ -0,0 +1,167 @@
+package zip
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// mixedSource writes files under dir/src, some well under budget and some
+// over it, half of them compressible. It returns the directory, the
+// entry names in walk order and the contents by file name.
+func mixedSource(t *testing.T, dir string, budget int) (string, []string, map[string][]byte) {
+	t.Helper()
+
+	src := filepath.Join(dir, "src")
+	if err := os.Mkdir(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	files := map[string][]byte{}
+	rng := rand.New(rand.NewSource(1))
+	for index, size := range []int{0, 100, budget / 2, budget * 3, 10, budget * 20, budget - 1, budget + 1, budget / 3, budget * 5} {
+		name := fmt.Sprintf("f%02d", index)
+		data := make([]byte, size)
+		if index%2 == 0 {
+			rng.Read(data)
+		} else {
+			copy(data, bytes.Repeat([]byte(name), size))
+		}
+		if err := os.WriteFile(filepath.Join(src, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, "src/"+name)
+		files[name] = data
+	}
+
+	return src, names, files
+}
+
+func TestParallelSpill(t *testing.T) {
+	const budget = 4096
+
+	dir := t.TempDir()
+	src, names, files := mixedSource(t, dir, budget)
+
+	// Spill files go to TMPDIR, which must be empty again afterwards.
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+
+	archive := filepath.Join(dir, "src.zip")
+	ed := New(archive)
+	ed.Threads = 4
+	ed.MemoryBudget = budget
+	if err := ed.Encode([]string{src}); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	if left, err := os.ReadDir(tmp); err != nil || len(left) != 0 {
+		t.Errorf("spill files left behind: %v %v", left, err)
+	}
+
+	reader, err := zip.OpenReader(archive)
+	if err != nil {
+		t.Fatalf("archive/zip can't open the archive: %v", err)
+	}
+	defer reader.Close()
+
+	var got []string
+	for _, file := range reader.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		got = append(got, file.Name)
+
+		r, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// archive/zip checks the CRC once the entry is read to the end.
+		data, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Errorf("%s: %v", file.Name, err)
+			continue
+		}
+		want := files[filepath.Base(file.Name)]
+		if !bytes.Equal(data, want) {
+			t.Errorf("%s has the wrong contents", file.Name)
+		}
+		if file.CRC32 != crc32.ChecksumIEEE(want) {
+			t.Errorf("%s: CRC %08x, want %08x", file.Name, file.CRC32, crc32.ChecksumIEEE(want))
+		}
+	}
+
+	if fmt.Sprint(got) != fmt.Sprint(names) {
+		t.Errorf("entries in order %v, want %v", got, names)
+	}
+}
+
+// Entries over a quarter of the budget spill, which fails when TMPDIR
+// doesn't exist; smaller ones stay in memory.
+func TestParallelSpillShare(t *testing.T) {
+	const budget = 4096
+
+	for _, test := range []struct {
+		size  int
+		spill bool
+	}{
+		{budget / spillShare, false},
+		{budget/spillShare + 1, true},
+		{budget - 1, true},
+	} {
+		dir := t.TempDir()
+		src := filepath.Join(dir, "src")
+		if err := os.Mkdir(src, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(src, "f"), make([]byte, test.size), 0644); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv("TMPDIR", filepath.Join(dir, "missing"))
+
+		ed := New(filepath.Join(dir, "src.zip"))
+		ed.Threads = 2
+		ed.MemoryBudget = budget
+		if err := ed.Encode([]string{src}); (err != nil) != test.spill {
+			t.Errorf("%d byte entry: Encode = %v, want spilled %v", test.size, err, test.spill)
+		}
+	}
+}
+
+func TestParallelExtract(t *testing.T) {
+	dir := t.TempDir()
+	src, _, files := mixedSource(t, dir, 4096)
+
+	archive := filepath.Join(dir, "src.zip")
+	ed := New(archive)
+	ed.Manifest = true
+	if err := ed.Encode([]string{src}); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	out := filepath.Join(dir, "out")
+	ed = New(archive)
+	ed.Threads = 4
+	ed.VerifyManifest = true
+	if err := ed.Decode(out); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+
+	for name, want := range files {
+		got, err := os.ReadFile(filepath.Join(out, "src", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s has the wrong contents", name)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package zip
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"archivist/lib/compression"
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// Reader is the ArchiveReader of zip archives. MANIFEST.sha256 isn't
+// returned as an entry; its digests are attached to the files instead.
+type Reader struct {
+	reader  *ReadCloser
+	files   []*zip.File
+	digests map[string]string
+	verify  bool
+	current io.ReadCloser
+	body    io.Reader
+}
+
+// Open opens the archive for reading its entries one at a time. With
+// VerifyManifest, reading a file to the end checks its recorded digest.
+func (ed *EncodeDecoder) Open() (compression.ArchiveReader, error) {
+	reader, err := OpenReader(ed.OutputPath)
+	if err != nil {
+		return nil, err
+	}
+
+	digests, err := readManifest(reader.Reader)
+	if err == nil && digests == nil && ed.VerifyManifest {
+		err = fmt.Errorf("zip archive %s has no %s: %w", ed.OutputPath, compression.ManifestName, compression.ErrNoDigest)
+	}
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+
+	return &Reader{reader: reader, files: reader.File, digests: digests, verify: ed.VerifyManifest}, nil
+}
+
+// Next advances to the next entry, skipping the rest of the current one.
+func (r *Reader) Next() (*compression.Entry, error) {
+	r.body = nil
+	if r.current != nil {
+		r.current.Close()
+		r.current = nil
+	}
+
+	if len(r.files) > 0 && r.digests != nil && r.files[0].Name == compression.ManifestName {
+		r.files = r.files[1:]
+	}
+	if len(r.files) == 0 {
+		return nil, io.EOF
+	}
+	file := r.files[0]
+	r.files = r.files[1:]
+
+	header, err := entryHeader(file, r.digests)
+	if err != nil {
+		return nil, err
+	}
+	entry := &compression.Entry{Header: header}
+	if header.Typeflag == tar.TypeSymlink {
+		return entry, nil
+	}
+
+	rc, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file %s in zip: %w", file.Name, err)
+	}
+
+	r.current, r.body = rc, rc
+	if r.verify && header.Typeflag == tar.TypeReg {
+		r.body = compression.VerifyReader(rc, entry)
+	}
+
+	return entry, nil
+}
+
+// Read reads the body of the current entry.
+func (r *Reader) Read(p []byte) (int, error) {
+	if r.body == nil {
+		return 0, io.EOF
+	}
+	return r.body.Read(p)
+}
+
+// Close closes the archive.
+func (r *Reader) Close() error {
+	if r.current != nil {
+		r.current.Close()
+	}
+	return r.reader.Close()
+}
+
+// ReadEntries passes every entry of reader to fn as a tar header and a
+// reader for its data, as Reader returns them: symlinks come with their
+// target in Linkname and MANIFEST.sha256 turns into digest records.
+func ReadEntries(reader *zip.Reader, fn func(*tar.Header, io.Reader) error) error {
+	digests, err := readManifest(reader)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range reader.File {
+		if digests != nil && file.Name == compression.ManifestName {
+			continue
+		}
+
+		if err := readEntry(file, digests, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func readEntry(file *zip.File, digests map[string]string, fn func(*tar.Header, io.Reader) error) error {
+	header, err := entryHeader(file, digests)
+	if err != nil {
+		return err
+	}
+	if header.Typeflag == tar.TypeSymlink {
+		return fn(header, bytes.NewReader(nil))
+	}
+
+	rc, err := file.Open()
+	if err != nil {
+		return fmt.Errorf("failed to open file %s in zip: %w", file.Name, err)
+	}
+	defer rc.Close()
+
+	return fn(header, rc)
+}
+
+// entryHeader describes file as a tar header, with the digest recorded for
+// it in digests and, for a symlink, its target.
+func entryHeader(file *zip.File, digests map[string]string) (*tar.Header, error) {
+	header, err := tar.FileInfoHeader(file.FileInfo(), "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert zip header for %s: %w", file.Name, err)
+	}
+	header.Name = file.Name
+	header.ModTime = file.Modified
+
+	if digest := digests[file.Name]; digest != "" && header.Typeflag == tar.TypeReg {
+		header.PAXRecords = map[string]string{compression.PAXDigestKey: digest}
+	}
+
+	if header.Typeflag == tar.TypeSymlink {
+		target, err := readLink(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read symlink %s in zip: %w", file.Name, err)
+		}
+		header.Linkname = target
+		header.Size = 0
+	}
+
+	return header, nil
+}
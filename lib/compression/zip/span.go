@@ -0,0 +1,692 @@
+package zip
+
+import (
+	"archive/zip"
+	"archivist/lib/compression"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Spanned archives follow the split layout of the zip APPNOTE as Info-ZIP
+// writes it: segments x.z01, x.z02 and so on hold the stream in order, and
+// the last one, x.zip, ends with the central directory. Records find their
+// local header by segment number and offset within that segment.
+
+const (
+	localHeaderSig   = 0x04034b50
+	centralHeaderSig = 0x02014b50
+	endSig           = 0x06054b50
+	zip64EndSig      = 0x06064b50
+	zip64LocatorSig  = 0x07064b50
+	// spanSig opens the first segment of a spanned archive; singleSpanSig
+	// replaces it when the archive ended up fitting in one segment.
+	spanSig       = 0x08074b50
+	singleSpanSig = 0x30304b50
+
+	centralHeaderLen = 46
+	endLen           = 22
+	zip64EndLen      = 56
+	zip64LocatorLen  = 20
+	zip64ExtraID     = 0x0001
+
+	uint16max = 0xffff
+	uint32max = 0xffffffff
+)
+
+var errDirectory = errors.New("zip central directory is damaged")
+
+// segmentSuffix matches the number of a segment that isn't the last, as in
+// x.z01.
+var segmentSuffix = regexp.MustCompile(`(?i)\.z(\d{2,})$`)
+
+// segmentName returns the name of segment index, counted from 1, of the
+// spanned archive at path: x.z01, x.z02 and so on, and path itself for the
+// last one.
+func segmentName(path string, index int, last bool) string {
+	if last {
+		return path
+	}
+	return fmt.Sprintf("%s.z%02d", strings.TrimSuffix(path, ".zip"), index)
+}
+
+// spannedArchive returns the last segment of the spanned archive path
+// belongs to: path itself when a x.z01 sits next to it, or x.zip given x.z01.
+func spannedArchive(path string) (string, bool, error) {
+	if match := segmentSuffix.FindStringSubmatch(path); match != nil {
+		if index, _ := strconv.Atoi(match[1]); index != 1 {
+			return "", false, fmt.Errorf("%s: %w, open %s instead", path, compression.ErrNotFirstVolume, segmentName(segmentSuffix.ReplaceAllString(path, ""), 1, false))
+		}
+		return segmentSuffix.ReplaceAllString(path, "") + ".zip", true, nil
+	}
+
+	if strings.HasSuffix(path, ".zip") {
+		if _, err := os.Stat(segmentName(path, 1, false)); err == nil {
+			return path, true, nil
+		}
+	}
+
+	return "", false, nil
+}
+
+// IsSplit reports whether path is part of a split or spanned archive.
+func IsSplit(path string) bool {
+	_, spanned, err := spannedArchive(path)
+	return spanned || err != nil || compression.IsSplit(path)
+}
+
+// ReadCloser is a zip archive opened by OpenReader.
+type ReadCloser struct {
+	*zip.Reader
+	file *compression.ArchiveFile
+}
+
+// Close closes every file the archive is read from.
+func (r *ReadCloser) Close() error {
+	return r.file.Close()
+}
+
+// OpenReader opens the zip archive at path, joining it from its parts when
+// it is split: numbered volumes from x.zip.001, and spanned segments from
+// either x.z01 or x.zip.
+func OpenReader(path string) (*ReadCloser, error) {
+	last, spanned, err := spannedArchive(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file *compression.ArchiveFile
+	if spanned {
+		file, err = openSpanned(last)
+	} else {
+		file, err = compression.OpenArchive(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := zip.NewReader(file, file.Size())
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to open zip archive %s: %w", path, err)
+	}
+
+	return &ReadCloser{Reader: reader, file: file}, nil
+}
+
+// openSpanned joins the segments of the spanned archive ending with last.
+// archive/zip only reads single files, so the central directory is handed
+// to it rewritten with offsets into the joined segments.
+func openSpanned(last string) (*compression.ArchiveFile, error) {
+	lastFile, err := os.Open(last)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive %s: %w", last, err)
+	}
+
+	info, err := lastFile.Stat()
+	if err != nil {
+		lastFile.Close()
+		return nil, fmt.Errorf("failed to stat %s: %w", last, err)
+	}
+
+	end, err := readEnd(lastFile, info.Size())
+	if err != nil {
+		lastFile.Close()
+		return nil, err
+	}
+
+	files := make([]*os.File, 0, end.disks)
+	for index := 1; index < int(end.disks); index++ {
+		file, err := os.Open(segmentName(last, index, false))
+		if errors.Is(err, os.ErrNotExist) {
+			err = fmt.Errorf("%s: %w", segmentName(last, index, false), compression.ErrMissingVolume)
+		}
+		if err != nil {
+			closeAll(append(files, lastFile))
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	files = append(files, lastFile)
+
+	r, err := joinSegments(files, end)
+	if err != nil {
+		closeAll(files)
+		return nil, err
+	}
+
+	return compression.NewArchiveFile(last, r, files), nil
+}
+
+// joinSegments checks the segments against the central directory and
+// returns a reader over them that archive/zip reads as a single archive.
+func joinSegments(files []*os.File, end *directoryEnd) (*io.SectionReader, error) {
+	joined, err := compression.JoinFiles(files)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every segment but the last is cut at the same size.
+	starts := make([]int64, len(files))
+	for i, file := range files[:len(files)-1] {
+		info, err := file.Stat()
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat %s: %w", file.Name(), err)
+		}
+		if i > 0 && info.Size() != starts[1] {
+			return nil, fmt.Errorf("%s is %d bytes, expected %d: %w", file.Name(), info.Size(), starts[1], compression.ErrVolumeOrder)
+		}
+		starts[i+1] = starts[i] + info.Size()
+	}
+
+	if len(files) > 1 {
+		var sig [4]byte
+		if _, err := joined.ReadAt(sig[:], 0); err != nil || binary.LittleEndian.Uint32(sig[:]) != spanSig {
+			return nil, fmt.Errorf("%s doesn't start a spanned archive: %w", files[0].Name(), compression.ErrVolumeOrder)
+		}
+	}
+
+	if end.zip64Disk >= uint32(len(files)) || end.dirDisk >= uint32(len(files)) {
+		return nil, errDirectory
+	}
+	if end.zip64 {
+		if err := end.readZip64(joined, starts[end.zip64Disk]+int64(end.zip64Offset)); err != nil {
+			return nil, err
+		}
+	}
+
+	dirStart := starts[end.dirDisk] + int64(end.dirOffset)
+	if end.dirSize > uint64(joined.Size()-dirStart) {
+		return nil, errDirectory
+	}
+	directory := make([]byte, end.dirSize)
+	if _, err := joined.ReadAt(directory, dirStart); err != nil {
+		return nil, fmt.Errorf("failed to read zip central directory: %w", err)
+	}
+
+	headers, err := parseDirectory(directory)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(headers)) != end.entries {
+		return nil, errDirectory
+	}
+
+	var tail []byte
+	for _, h := range headers {
+		disk, offset, err := h.location()
+		if err != nil {
+			return nil, err
+		}
+		if disk >= uint32(len(files)) {
+			return nil, fmt.Errorf("%s is on missing segment %d: %w", h.name, disk+1, compression.ErrMissingVolume)
+		}
+
+		var sig [4]byte
+		abs := starts[disk] + int64(offset)
+		if _, err := joined.ReadAt(sig[:], abs); err != nil || binary.LittleEndian.Uint32(sig[:]) != localHeaderSig {
+			return nil, fmt.Errorf("no local header for %s: %w", h.name, compression.ErrVolumeOrder)
+		}
+
+		if err := h.setLocation(0, uint64(abs)); err != nil {
+			return nil, err
+		}
+		tail = h.append(tail)
+	}
+
+	rebuilt := directoryEnd{
+		disks:     1,
+		onDisk:    end.entries,
+		entries:   end.entries,
+		dirSize:   uint64(len(tail)),
+		dirOffset: uint64(dirStart),
+	}
+	tail = rebuilt.append(tail, uint64(dirStart)+uint64(len(tail)))
+
+	return compression.JoinReaders(io.NewSectionReader(joined, 0, dirStart), io.NewSectionReader(bytes.NewReader(tail), 0, int64(len(tail)))), nil
+}
+
+func closeAll(files []*os.File) {
+	for _, file := range files {
+		file.Close()
+	}
+}
+
+// spanWriter writes a spanned archive. archive/zip lays out the stream;
+// spanWriter holds back the central directory it writes last and rewrites
+// it with segment-relative locations on Commit.
+type spanWriter struct {
+	volumes   *compression.Volumes
+	size      int64
+	written   int64
+	directory *bytes.Buffer
+}
+
+func newSpanWriter(path string, size int64) (*spanWriter, error) {
+	volumes, err := compression.CreateVolumes(size, func(index int, last bool) string {
+		return segmentName(path, index, last)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s := &spanWriter{volumes: volumes, size: size}
+	if _, err := s.Write(binary.LittleEndian.AppendUint32(nil, spanSig)); err != nil {
+		volumes.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *spanWriter) Write(p []byte) (int, error) {
+	if s.directory != nil {
+		return s.directory.Write(p)
+	}
+
+	n, err := s.volumes.Write(p)
+	s.written += int64(n)
+	return n, err
+}
+
+// holdDirectory keeps everything written from now on, which ends with the
+// central directory, for Commit.
+func (s *spanWriter) holdDirectory() {
+	s.directory = new(bytes.Buffer)
+}
+
+// Commit writes the central directory and moves the segments into place.
+func (s *spanWriter) Commit() error {
+	if err := s.writeDirectory(); err != nil {
+		s.volumes.Close()
+		return err
+	}
+
+	if s.volumes.Count() == 1 {
+		// The archive fits in one segment, which is then a plain archive.
+		if _, err := s.volumes.Volume(0).WriteAt(binary.LittleEndian.AppendUint32(nil, singleSpanSig), 0); err != nil {
+			s.volumes.Close()
+			return fmt.Errorf("failed to write zip signature: %w", err)
+		}
+	}
+
+	return s.volumes.Commit()
+}
+
+// Close discards the segments unless they were committed.
+func (s *spanWriter) Close() error {
+	return s.volumes.Close()
+}
+
+// writeDirectory writes the central directory held back, locating each
+// local header by segment, and the end records, which are kept together on
+// the last segment.
+func (s *spanWriter) writeDirectory() error {
+	held := s.directory.Bytes()
+	s.directory = nil
+
+	// What was held back starts with the rest of the last entry, which
+	// archive/zip finishes on Close; its end records say where the central
+	// directory starts.
+	r := bytes.NewReader(held)
+	goEnd, err := readEnd(r, int64(len(held)))
+	if err != nil {
+		return err
+	}
+	if goEnd.zip64 {
+		if err := goEnd.readZip64(r, int64(goEnd.zip64Offset)-s.written); err != nil {
+			return err
+		}
+	}
+	dirStart := int64(goEnd.dirOffset) - s.written
+	if dirStart < 0 || dirStart > int64(len(held)) {
+		return errDirectory
+	}
+	if _, err := s.volumes.Write(held[:dirStart]); err != nil {
+		return err
+	}
+
+	headers, err := parseDirectory(held[dirStart:])
+	if err != nil {
+		return err
+	}
+
+	dirDisk, dirOffset := s.volumes.Position()
+	end := directoryEnd{
+		dirDisk:   uint32(dirDisk),
+		dirOffset: uint64(dirOffset),
+		entries:   uint64(len(headers)),
+	}
+
+	disks := make([]int, 0, len(headers))
+	for _, h := range headers {
+		_, offset, err := h.location()
+		if err != nil {
+			return err
+		}
+		if err := h.setLocation(uint32(offset/uint64(s.size)), offset%uint64(s.size)); err != nil {
+			return err
+		}
+
+		disk, _ := s.volumes.Position()
+		disks = append(disks, disk)
+
+		record := h.append(nil)
+		if _, err := s.volumes.Write(record); err != nil {
+			return err
+		}
+		end.dirSize += uint64(len(record))
+	}
+
+	if err := s.volumes.Reserve(zip64EndLen + zip64LocatorLen + endLen); err != nil {
+		return err
+	}
+	disk, offset := s.volumes.Position()
+	end.disk, end.disks = uint32(disk), uint32(disk)+1
+	for _, d := range disks {
+		if d == disk {
+			end.onDisk++
+		}
+	}
+
+	_, err = s.volumes.Write(end.append(nil, uint64(offset)))
+	return err
+}
+
+// directoryEnd holds the end of central directory records.
+type directoryEnd struct {
+	disk      uint32 // segment holding the end records
+	disks     uint32
+	dirDisk   uint32 // segment the central directory starts on
+	onDisk    uint64 // records on the segment holding the end records
+	entries   uint64
+	dirSize   uint64
+	dirOffset uint64
+
+	// zip64 is set when a zip64 end record was found, at zip64Offset on
+	// segment zip64Disk.
+	zip64       bool
+	zip64Disk   uint32
+	zip64Offset uint64
+}
+
+// needsZip64 reports whether a value overflows the classic end record.
+func (e *directoryEnd) needsZip64() bool {
+	return e.disk >= uint16max || e.dirDisk >= uint16max || e.onDisk >= uint16max || e.entries >= uint16max ||
+		e.dirSize >= uint32max || e.dirOffset >= uint32max
+}
+
+// len returns the length of the end records.
+func (e *directoryEnd) len() int {
+	if e.needsZip64() {
+		return zip64EndLen + zip64LocatorLen + endLen
+	}
+	return endLen
+}
+
+// append appends the end records to b, starting at offset on their segment.
+func (e *directoryEnd) append(b []byte, offset uint64) []byte {
+	le := binary.LittleEndian
+
+	if e.needsZip64() {
+		b = le.AppendUint32(b, zip64EndSig)
+		b = le.AppendUint64(b, zip64EndLen-12)
+		b = le.AppendUint16(b, 45)
+		b = le.AppendUint16(b, 45)
+		b = le.AppendUint32(b, e.disk)
+		b = le.AppendUint32(b, e.dirDisk)
+		b = le.AppendUint64(b, e.onDisk)
+		b = le.AppendUint64(b, e.entries)
+		b = le.AppendUint64(b, e.dirSize)
+		b = le.AppendUint64(b, e.dirOffset)
+
+		b = le.AppendUint32(b, zip64LocatorSig)
+		b = le.AppendUint32(b, e.disk)
+		b = le.AppendUint64(b, offset)
+		b = le.AppendUint32(b, e.disks)
+	}
+
+	b = le.AppendUint32(b, endSig)
+	b = le.AppendUint16(b, uint16(min(e.disk, uint16max)))
+	b = le.AppendUint16(b, uint16(min(e.dirDisk, uint16max)))
+	b = le.AppendUint16(b, uint16(min(e.onDisk, uint16max)))
+	b = le.AppendUint16(b, uint16(min(e.entries, uint16max)))
+	b = le.AppendUint32(b, uint32(min(e.dirSize, uint32max)))
+	b = le.AppendUint32(b, uint32(min(e.dirOffset, uint32max)))
+	b = le.AppendUint16(b, 0)
+
+	return b
+}
+
+// readEnd finds the end records at the end of r, of the given size. Where the
+// zip64 end record lives elsewhere, readZip64 reads it once the segments
+// are joined.
+func readEnd(r io.ReaderAt, size int64) (*directoryEnd, error) {
+	tail := make([]byte, min(size, endLen+uint16max))
+	if _, err := r.ReadAt(tail, size-int64(len(tail))); err != nil {
+		return nil, fmt.Errorf("failed to read zip end record: %w", err)
+	}
+
+	le := binary.LittleEndian
+	pos := len(tail) - endLen
+	for ; pos >= 0; pos-- {
+		if le.Uint32(tail[pos:]) == endSig && pos+endLen+int(le.Uint16(tail[pos+20:])) <= len(tail) {
+			break
+		}
+	}
+	if pos < 0 {
+		return nil, zip.ErrFormat
+	}
+
+	record := tail[pos:]
+	end := &directoryEnd{
+		disk:      uint32(le.Uint16(record[4:])),
+		dirDisk:   uint32(le.Uint16(record[6:])),
+		onDisk:    uint64(le.Uint16(record[8:])),
+		entries:   uint64(le.Uint16(record[10:])),
+		dirSize:   uint64(le.Uint32(record[12:])),
+		dirOffset: uint64(le.Uint32(record[16:])),
+	}
+	end.disks = end.disk + 1
+
+	if pos >= zip64LocatorLen && le.Uint32(tail[pos-zip64LocatorLen:]) == zip64LocatorSig {
+		locator := tail[pos-zip64LocatorLen:]
+		end.zip64 = true
+		end.zip64Disk = le.Uint32(locator[4:])
+		end.zip64Offset = le.Uint64(locator[8:])
+		end.disks = le.Uint32(locator[16:])
+	}
+
+	return end, nil
+}
+
+// readZip64 reads the zip64 end record at offset in r.
+func (e *directoryEnd) readZip64(r io.ReaderAt, offset int64) error {
+	record := make([]byte, zip64EndLen)
+	if _, err := r.ReadAt(record, offset); err != nil {
+		return fmt.Errorf("failed to read zip64 end record: %w", err)
+	}
+
+	le := binary.LittleEndian
+	if le.Uint32(record) != zip64EndSig {
+		return errDirectory
+	}
+	e.dirDisk = le.Uint32(record[20:])
+	e.onDisk = le.Uint64(record[24:])
+	e.entries = le.Uint64(record[32:])
+	e.dirSize = le.Uint64(record[40:])
+	e.dirOffset = le.Uint64(record[48:])
+
+	return nil
+}
+
+// centralHeader is a record of the central directory, split into its parts.
+type centralHeader struct {
+	fixed   []byte
+	name    []byte
+	extra   []byte
+	comment []byte
+}
+
+// parseDirectory splits a central directory into its records, stopping at
+// the end records that follow it.
+func parseDirectory(b []byte) ([]*centralHeader, error) {
+	le := binary.LittleEndian
+	var headers []*centralHeader
+
+	for len(b) >= 4 && le.Uint32(b) == centralHeaderSig {
+		if len(b) < centralHeaderLen {
+			return nil, errDirectory
+		}
+
+		nameEnd := centralHeaderLen + int(le.Uint16(b[28:]))
+		extraEnd := nameEnd + int(le.Uint16(b[30:]))
+		commentEnd := extraEnd + int(le.Uint16(b[32:]))
+		if len(b) < commentEnd {
+			return nil, errDirectory
+		}
+
+		headers = append(headers, &centralHeader{
+			fixed:   bytes.Clone(b[:centralHeaderLen]),
+			name:    b[centralHeaderLen:nameEnd],
+			extra:   b[nameEnd:extraEnd],
+			comment: b[extraEnd:commentEnd],
+		})
+		b = b[commentEnd:]
+	}
+
+	if len(b) > 0 && (len(b) < 4 || le.Uint32(b) != endSig && le.Uint32(b) != zip64EndSig) {
+		return nil, errDirectory
+	}
+
+	return headers, nil
+}
+
+// location returns the segment of the record's local header and its offset
+// there, reading the zip64 extra field for those that overflow.
+func (h *centralHeader) location() (uint32, uint64, error) {
+	le := binary.LittleEndian
+	disk := uint32(le.Uint16(h.fixed[34:]))
+	offset := uint64(le.Uint32(h.fixed[42:]))
+
+	fields := h.zip64Fields()
+	next := func() (uint64, bool) {
+		if len(fields) < 8 {
+			return 0, false
+		}
+		value := le.Uint64(fields)
+		fields = fields[8:]
+		return value, true
+	}
+
+	// Zip64 values appear in a fixed order, each only when its fixed field
+	// holds the maximum.
+	for _, field := range []int{24, 20} {
+		if le.Uint32(h.fixed[field:]) == uint32max {
+			if _, ok := next(); !ok {
+				return 0, 0, errDirectory
+			}
+		}
+	}
+	if offset == uint32max {
+		value, ok := next()
+		if !ok {
+			return 0, 0, errDirectory
+		}
+		offset = value
+	}
+	if disk == uint16max {
+		if len(fields) < 4 {
+			return 0, 0, errDirectory
+		}
+		disk = le.Uint32(fields)
+	}
+
+	return disk, offset, nil
+}
+
+// setLocation points the record at the local header at offset on segment
+// disk, keeping the zip64 sizes it already has.
+func (h *centralHeader) setLocation(disk uint32, offset uint64) error {
+	le := binary.LittleEndian
+
+	fields := h.zip64Fields()
+	var values []byte
+	for _, field := range []int{24, 20} {
+		if le.Uint32(h.fixed[field:]) == uint32max {
+			if len(fields) < 8 {
+				return errDirectory
+			}
+			values = append(values, fields[:8]...)
+			fields = fields[8:]
+		}
+	}
+
+	if offset >= uint32max {
+		le.PutUint32(h.fixed[42:], uint32max)
+		values = le.AppendUint64(values, offset)
+	} else {
+		le.PutUint32(h.fixed[42:], uint32(offset))
+	}
+	if disk >= uint16max {
+		le.PutUint16(h.fixed[34:], uint16max)
+		values = le.AppendUint32(values, disk)
+	} else {
+		le.PutUint16(h.fixed[34:], uint16(disk))
+	}
+
+	var extra []byte
+	if len(values) > 0 {
+		extra = le.AppendUint16(extra, zip64ExtraID)
+		extra = le.AppendUint16(extra, uint16(len(values)))
+		extra = append(extra, values...)
+	}
+	for rest := h.extra; len(rest) >= 4; {
+		size := 4 + int(le.Uint16(rest[2:]))
+		if size > len(rest) {
+			break
+		}
+		if le.Uint16(rest) != zip64ExtraID {
+			extra = append(extra, rest[:size]...)
+		}
+		rest = rest[size:]
+	}
+	if len(extra) > uint16max {
+		return errDirectory
+	}
+
+	h.extra = extra
+	le.PutUint16(h.fixed[30:], uint16(len(extra)))
+
+	return nil
+}
+
+// zip64Fields returns the data of the record's zip64 extra field.
+func (h *centralHeader) zip64Fields() []byte {
+	le := binary.LittleEndian
+	for rest := h.extra; len(rest) >= 4; {
+		size := 4 + int(le.Uint16(rest[2:]))
+		if size > len(rest) {
+			return nil
+		}
+		if le.Uint16(rest) == zip64ExtraID {
+			return rest[4:size]
+		}
+		rest = rest[size:]
+	}
+	return nil
+}
+
+// append appends the record to b.
+func (h *centralHeader) append(b []byte) []byte {
+	b = append(b, h.fixed...)
+	b = append(b, h.name...)
+	b = append(b, h.extra...)
+	return append(b, h.comment...)
+}
@@ -0,0 +1,94 @@
+package zip
+
+import (
+	"archivist/lib/compression"
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// spannedSource writes files of random bytes under dir/src, which don't
+// compress, and returns the directory and its contents by name.
+func spannedSource(t *testing.T, dir string) (string, map[string][]byte) {
+	t.Helper()
+
+	src := filepath.Join(dir, "src")
+	if err := os.Mkdir(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string][]byte{}
+	rng := rand.New(rand.NewSource(1))
+	for index := range 5 {
+		name := fmt.Sprintf("f%d", index)
+		data := make([]byte, 60000)
+		rng.Read(data)
+		files[name] = data
+		if err := os.WriteFile(filepath.Join(src, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return src, files
+}
+
+func TestSpannedRoundTrip(t *testing.T) {
+	for _, threads := range []int{0, 4} {
+		t.Run(fmt.Sprintf("threads=%d", threads), func(t *testing.T) {
+			dir := t.TempDir()
+			src, files := spannedSource(t, dir)
+
+			archive := filepath.Join(dir, "src.zip")
+			ed := New(archive)
+			ed.SplitSize = compression.MinSplitSize
+			ed.Threads = threads
+			if err := ed.Encode([]string{src}); err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+
+			segments := 0
+			for index := 1; ; index++ {
+				info, err := os.Stat(segmentName(archive, index, false))
+				if os.IsNotExist(err) {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if info.Size() > compression.MinSplitSize {
+					t.Errorf("segment %d is %d bytes, more than the split size", index, info.Size())
+				}
+				segments++
+			}
+			if segments < 4 {
+				t.Fatalf("archive spans %d segments before the last, want at least 4", segments)
+			}
+
+			// Both the first segment and the last one open the archive.
+			for _, path := range []string{segmentName(archive, 1, false), archive} {
+				out := filepath.Join(dir, "out-"+filepath.Ext(path)[1:])
+				if err := New(path).Decode(out); err != nil {
+					t.Fatalf("Decode(%s): %v", filepath.Base(path), err)
+				}
+				for name, want := range files {
+					got, err := os.ReadFile(filepath.Join(out, "src", name))
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(got, want) {
+						t.Errorf("Decode(%s): %s has the wrong contents", filepath.Base(path), name)
+					}
+				}
+			}
+
+			_, err := OpenReader(segmentName(archive, 2, false))
+			if !errors.Is(err, compression.ErrNotFirstVolume) {
+				t.Errorf("OpenReader(.z02) = %v, want %v", err, compression.ErrNotFirstVolume)
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+package zip
+
+import (
+	"archive/zip"
+	"archivist/lib/compression"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Writer is the ArchiveWriter of zip archives. Unlike Encode, which leaves
+// directories implied by their files, AddDir stores a directory entry.
+type Writer struct {
+	file         compression.Destination
+	span         *spanWriter
+	archive      *zip.Writer
+	pw           *parallelWriter
+	manifest     []compression.ManifestEntry
+	digests      bool
+	reproducible bool
+	epoch        *time.Time
+	tracker      *compression.Tracker
+	logger       *slog.Logger
+	err          error
+}
+
+// Create starts writing the archive, for adding entries one at a time.
+// Entries are deflated as they are added; Threads only applies to Encode.
+func (ed *EncodeDecoder) Create() (compression.ArchiveWriter, error) {
+	writer, err := ed.create(compression.NewTracker(ed.Progress, 0), 0)
+	if err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+// create starts the archive, deflating sources on threads goroutines when
+// threads is set.
+func (ed *EncodeDecoder) create(tracker *compression.Tracker, threads int) (*Writer, error) {
+	w := &Writer{
+		digests:      ed.Manifest,
+		reproducible: ed.Reproducible,
+		tracker:      tracker,
+		logger:       compression.Logger(ed.Logger),
+	}
+
+	if ed.Reproducible {
+		var err error
+		if w.epoch, err = compression.SourceDateEpoch(); err != nil {
+			return nil, err
+		}
+	}
+
+	if ed.SplitSize > 0 {
+		span, err := newSpanWriter(ed.OutputPath, ed.SplitSize)
+		if err != nil {
+			return nil, err
+		}
+		w.file, w.span = span, span
+		w.archive = zip.NewWriter(span)
+		w.archive.SetOffset(4)
+	} else {
+		file, err := compression.CreateArchive(ed.OutputPath)
+		if err != nil {
+			return nil, err
+		}
+		w.file = file
+		w.archive = zip.NewWriter(file)
+	}
+
+	if threads > 0 {
+		w.pw = newParallelWriter(w.archive, threads, ed.MemoryBudget, ed.Manifest, tracker)
+	}
+
+	return w, nil
+}
+
+// addSource adds a file walked by Encode. Directories are implied by the
+// files inside them.
+func (w *Writer) addSource(source compression.Source) error {
+	if source.Info.IsDir() {
+		return nil
+	}
+
+	header, err := zip.FileInfoHeader(source.Info)
+	if err != nil {
+		return w.fail(fmt.Errorf("failed to create zip header for %s: %w", source.Path, err))
+	}
+	header.Name = source.Name
+
+	if err := w.prepare(header); err != nil {
+		return err
+	}
+
+	if w.pw != nil {
+		if err := w.pw.add(header, source); err != nil {
+			return w.fail(err)
+		}
+		return nil
+	}
+
+	file, err := openSource(source)
+	if err != nil {
+		return w.fail(err)
+	}
+	defer file.Close()
+
+	return w.write(header, file, source.Path)
+}
+
+// AddFile adds a regular file with the contents of r.
+func (w *Writer) AddFile(name string, mode fs.FileMode, mtime time.Time, r io.Reader) error {
+	header := &zip.FileHeader{Name: name, Modified: mtime}
+	header.SetMode(mode.Perm())
+
+	if err := w.prepare(header); err != nil {
+		return err
+	}
+
+	return w.write(header, r, name)
+}
+
+// AddDir adds a directory entry.
+func (w *Writer) AddDir(name string, mode fs.FileMode, mtime time.Time) error {
+	header := &zip.FileHeader{Name: strings.TrimSuffix(name, "/") + "/", Modified: mtime}
+	header.SetMode(fs.ModeDir | mode.Perm())
+
+	if err := w.prepare(header); err != nil {
+		return err
+	}
+
+	if _, err := w.archive.CreateHeader(header); err != nil {
+		return w.fail(fmt.Errorf("failed to create zip entry for %s: %w", name, err))
+	}
+
+	return nil
+}
+
+// AddSymlink adds a symlink to target, stored as the entry's data the way
+// Info-ZIP does.
+func (w *Writer) AddSymlink(name, target string, mtime time.Time) error {
+	header := &zip.FileHeader{Name: name, Modified: mtime}
+	header.SetMode(fs.ModeSymlink | 0777)
+
+	if err := w.prepare(header); err != nil {
+		return err
+	}
+
+	return w.write(header, strings.NewReader(target), name)
+}
+
+// prepare fills in what every entry shares, failing once the writer has.
+func (w *Writer) prepare(header *zip.FileHeader) error {
+	if w.err != nil {
+		return w.err
+	}
+
+	header.Method = zip.Deflate
+	if w.reproducible {
+		compression.NormalizeZipHeader(header, w.epoch)
+	}
+
+	w.logger.Info(compression.LogAdding, compression.LogEntryKey, header.Name)
+
+	return nil
+}
+
+// write stores the data of r under header, recording its digest for the
+// manifest.
+func (w *Writer) write(header *zip.FileHeader, r io.Reader, path string) error {
+	w.tracker.Start(header.Name)
+
+	digest, err := writeEntry(w.archive, header, r, path, w.tracker, w.digests)
+	if err != nil {
+		return w.fail(err)
+	}
+
+	if w.digests {
+		w.manifest = append(w.manifest, compression.ManifestEntry{
+			Name:   header.Name,
+			Digest: digest,
+		})
+	}
+
+	return nil
+}
+
+// fail records err; once an entry fails, the archive can't be completed.
+func (w *Writer) fail(err error) error {
+	if w.err == nil {
+		w.err = err
+	}
+	return err
+}
+
+// Close writes the manifest and central directory and moves the archive
+// into place. After a failed Add, it discards the archive and returns that
+// error instead.
+func (w *Writer) Close() error {
+	if w.pw != nil {
+		defer w.pw.cleanup()
+	}
+
+	if w.err != nil {
+		w.file.Close()
+		return w.err
+	}
+	w.err = compression.ErrWriterClosed
+
+	if err := w.finish(); err != nil {
+		w.file.Close()
+		return err
+	}
+
+	return w.file.Commit()
+}
+
+func (w *Writer) finish() error {
+	if w.pw != nil {
+		if err := w.pw.close(); err != nil {
+			return err
+		}
+		w.manifest = w.pw.manifest
+	}
+
+	if w.digests {
+		writer, err := w.archive.Create(compression.ManifestName)
+		if err != nil {
+			return fmt.Errorf("failed to create zip entry for %s: %w", compression.ManifestName, err)
+		}
+
+		if err := compression.WriteManifest(writer, w.manifest); err != nil {
+			return fmt.Errorf("failed to write %s to zip: %w", compression.ManifestName, err)
+		}
+	}
+
+	if w.span != nil {
+		w.span.holdDirectory()
+	}
+	if err := w.archive.Close(); err != nil {
+		return fmt.Errorf("failed to write zip central directory: %w", err)
+	}
+
+	return nil
+}
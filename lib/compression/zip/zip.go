@@ -2,8 +2,13 @@ package zip
 
 import (
 	"archive/zip"
+	"archivist/lib/compression"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
@@ -11,6 +16,38 @@ import (
 
 type EncodeDecoder struct {
 	OutputPath string
+	// Manifest appends a MANIFEST.sha256 member listing every file's digest.
+	Manifest bool
+	// Reproducible sorts sources, clamps mtimes to SOURCE_DATE_EPOCH and
+	// drops ownership and permission noise so identical trees give identical bytes.
+	Reproducible bool
+	// Threads deflates entries concurrently on that many goroutines and
+	// writes them in walk order. On Decode it extracts that many entries at
+	// once. Zero handles one entry at a time.
+	Threads int
+	// MemoryBudget caps the bytes the parallel encoder holds in memory. An
+	// entry counts its uncompressed size while it is deflated and its
+	// compressed size while it waits to be written; entries larger than a
+	// quarter of the budget spill to temporary files. Zero uses
+	// DefaultMemoryBudget.
+	MemoryBudget int64
+	// SplitSize spans the archive over segments of at most that many bytes,
+	// named OutputPath.z01, OutputPath.z02 and so on with the last one at
+	// OutputPath, as Info-ZIP does. Zero writes one file.
+	SplitSize int64
+	// Resume keeps a journal of the files extracted in the output directory,
+	// so that a Decode run after an interrupted one skips them.
+	Resume bool
+	// VerifyManifest checks extracted files against MANIFEST.sha256.
+	VerifyManifest bool
+	// Progress, when set, receives the bytes packed or unpacked so far.
+	Progress compression.ProgressFunc
+	// Logger receives a record per entry and warnings about skipped
+	// entries. Nil discards them.
+	Logger *slog.Logger
+	// Extractor applies the overwrite and backup policy to existing files.
+	// Nil overwrites them.
+	Extractor *compression.Extractor
 }
 
 func New(outPaht string) *EncodeDecoder {
@@ -20,65 +57,101 @@ func New(outPaht string) *EncodeDecoder {
 }
 
 func (ed *EncodeDecoder) Encode(sourcePaths []string) error {
-	zipFile, err := os.Create(ed.OutputPath)
+	if ed.Reproducible {
+		sourcePaths = compression.SortedSources(sourcePaths)
+	}
+
+	sources, err := compression.CollectSources(sourcePaths)
 	if err != nil {
-		return fmt.Errorf("failed to create zip file %s: %w", ed.OutputPath, err)
+		return err
 	}
-	defer zipFile.Close()
 
-	archive := zip.NewWriter(zipFile)
-	defer archive.Close()
+	return ed.encode(sources)
+}
 
-	for _, source := range sourcePaths {
-		err = filepath.Walk(source, func(filePath string, info os.FileInfo, err error) error {
-			if err != nil {
-				return fmt.Errorf("error walking through %s: %w", filePath, err)
-			}
+// EncodeFS packs the trees under roots of fsys.
+func (ed *EncodeDecoder) EncodeFS(fsys fs.FS, roots []string) error {
+	if ed.Reproducible {
+		roots = compression.SortedSources(roots)
+	}
 
-			header, err := zip.FileInfoHeader(info)
-			if err != nil {
-				return fmt.Errorf("failed to create zip header for %s: %w", filePath, err)
-			}
+	sources, err := compression.CollectFS(fsys, roots)
+	if err != nil {
+		return err
+	}
 
-			relPath, err := filepath.Rel(filepath.Dir(source), filePath)
-			if err != nil {
-				return fmt.Errorf("failed to get relative path for %s: %w", filePath, err)
-			}
-			header.Name = strings.ReplaceAll(relPath, string(os.PathSeparator), "/")
-
-			header.Method = zip.Deflate
-
-			if info.IsDir() {
-				header.Name += "/"
-			} else {
-				writer, err := archive.CreateHeader(header)
-				if err != nil {
-					return fmt.Errorf("failed to create zip entry for %s: %w", filePath, err)
-				}
-
-				file, err := os.Open(filePath)
-				if err != nil {
-					return fmt.Errorf("failed to open file %s: %w", filePath, err)
-				}
-				defer file.Close()
-
-				_, err = io.Copy(writer, file)
-				if err != nil {
-					return fmt.Errorf("failed to write file %s to zip: %w", filePath, err)
-				}
-			}
-			return nil
-		})
+	return ed.encode(sources)
+}
 
-		if err != nil {
+func (ed *EncodeDecoder) encode(sources []compression.Source) error {
+	writer, err := ed.create(compression.TrackSources(ed.Progress, sources), ed.Threads)
+	if err != nil {
+		return err
+	}
+
+	for _, source := range sources {
+		if err := writer.addSource(source); err != nil {
+			writer.Close()
 			return err
 		}
 	}
 
-	return nil
+	return writer.Close()
+}
+
+// writeFile stores source under header, returning the SHA-256 digest of
+// the stored data when digest is set.
+func writeFile(archive *zip.Writer, header *zip.FileHeader, source compression.Source, tracker *compression.Tracker, digest bool) (string, error) {
+	file, err := openSource(source)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	return writeEntry(archive, header, file, source.Path, tracker, digest)
+}
+
+// writeEntry stores the data of r under header, returning its SHA-256
+// digest when digest is set.
+func writeEntry(archive *zip.Writer, header *zip.FileHeader, r io.Reader, path string, tracker *compression.Tracker, digest bool) (string, error) {
+	writer, err := archive.CreateHeader(header)
+	if err != nil {
+		return "", fmt.Errorf("failed to create zip entry for %s: %w", path, err)
+	}
+
+	hash := compression.NewDigest()
+	if digest {
+		writer = io.MultiWriter(writer, hash)
+	}
+
+	if _, err := io.Copy(writer, tracker.Reader(r)); err != nil {
+		return "", fmt.Errorf("failed to write file %s to zip: %w", path, err)
+	}
+
+	if !digest {
+		return "", nil
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// openSource opens the data stored for source: the target of a symlink,
+// as Info-ZIP stores it, or the contents of a file.
+func openSource(source compression.Source) (io.ReadCloser, error) {
+	if source.Info.Mode()&fs.ModeSymlink == 0 {
+		return source.Open()
+	}
+
+	target, err := source.Readlink()
+	if err != nil {
+		return nil, err
+	}
+
+	return io.NopCloser(strings.NewReader(target)), nil
 }
 
 func (ed *EncodeDecoder) Decode(outputDir string) error {
+	logger := compression.Logger(ed.Logger)
+
 	if outputDir == "" {
 		return fmt.Errorf("output directory path is empty")
 	}
@@ -88,61 +161,236 @@ func (ed *EncodeDecoder) Decode(outputDir string) error {
 	}
 
 	if err := os.MkdirAll(outputDir, 0755); err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to create output directory %s: %v\n", outputDir, err)
 		return fmt.Errorf("failed to create output directory %s: %w", outputDir, err)
 	}
 
-	reader, err := zip.OpenReader(ed.OutputPath)
+	reader, err := OpenReader(ed.OutputPath)
 	if err != nil {
-		return fmt.Errorf("failed to open zip archive %s: %w", ed.OutputPath, err)
+		return err
 	}
 	defer reader.Close()
 
+	// Zip entries are read at random, so progress advances by each entry's
+	// compressed size once it has been extracted.
+	var total int64
+	for _, file := range reader.File {
+		total += int64(file.CompressedSize64)
+	}
+	tracker := compression.NewTracker(ed.Progress, total)
+
+	// Entries are found through the central directory, so a resumed run
+	// goes straight to the files not extracted yet.
+	journal, err := compression.OpenJournal(outputDir, ed.OutputPath, ed.Resume)
+	if err != nil {
+		return err
+	}
+	defer journal.Close()
+
+	var digests map[string]string
+	if ed.VerifyManifest {
+		digests, err = readManifest(reader.Reader)
+		if err != nil {
+			return err
+		}
+		if digests == nil {
+			return fmt.Errorf("zip archive %s has no %s: %w", ed.OutputPath, compression.ManifestName, compression.ErrNoDigest)
+		}
+	}
+
+	var jobs []extractJob
+
 	for _, file := range reader.File {
 		targetPath := filepath.Join(outputDir, file.Name)
 		targetPath = filepath.Clean(targetPath)
 
 		if strings.Contains(file.Name, "..") {
-			fmt.Fprintf(os.Stderr, "Skipping potentially unsafe path: %s\n", file.Name)
+			logger.Warn(compression.LogSkipped, compression.LogEntryKey, file.Name)
+			continue
+		}
+
+		if !file.FileInfo().IsDir() && journal.Done(file.Name, targetPath) {
+			logger.Info(compression.LogDone, compression.LogEntryKey, file.Name)
+			tracker.Add(int64(file.CompressedSize64))
 			continue
 		}
 
+		logger.Info(compression.LogExtracting, compression.LogEntryKey, file.Name)
+
 		if file.FileInfo().IsDir() {
-			fmt.Printf("Creating directory: %s\n", targetPath)
 			if err := os.MkdirAll(targetPath, file.Mode()); err != nil {
 				return fmt.Errorf("failed to create directory %s: %w", targetPath, err)
 			}
 			continue
 		}
 
-		rc, err := file.Open()
-		if err != nil {
-			return fmt.Errorf("failed to open file %s in zip: %w", file.Name, err)
+		if file.Mode()&fs.ModeSymlink != 0 {
+			if err := ed.extractLink(file, targetPath, digests, tracker, logger); err != nil {
+				return err
+			}
+			continue
 		}
 
-		if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
-			rc.Close()
-			return fmt.Errorf("failed to create parent directory for %s: %w", targetPath, err)
+		if ed.Threads > 0 {
+			// Parents are created here so the workers only ever write files.
+			if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
+				return fmt.Errorf("failed to create parent directory for %s: %w", targetPath, err)
+			}
+			jobs = append(jobs, extractJob{file: file, targetPath: targetPath})
+			continue
 		}
 
-		targetFile, err := os.OpenFile(targetPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, file.Mode())
-		if err != nil {
-			rc.Close()
-			return fmt.Errorf("failed to create file %s: %w", targetPath, err)
+		if err := ed.extractFile(file, targetPath, digests, tracker, journal); err != nil {
+			return err
 		}
-		
-		_, err = io.Copy(targetFile, rc)
-		if err != nil {
-			rc.Close()
-			targetFile.Close()
-			return fmt.Errorf("failed to write file %s: %w", targetPath, err)
+	}
+
+	if ed.Threads > 0 {
+		if err := ed.extractParallel(jobs, digests, tracker, journal); err != nil {
+			return err
 		}
+	}
+
+	return journal.Finish()
+}
+
+// extractLink creates the symlink stored as file, skipping it when its
+// target points outside the output directory.
+func (ed *EncodeDecoder) extractLink(file *zip.File, targetPath string, digests map[string]string, tracker *compression.Tracker, logger *slog.Logger) error {
+	defer tracker.Add(int64(file.CompressedSize64))
+
+	target, err := readLink(file)
+	if err != nil {
+		return fmt.Errorf("failed to read symlink %s in zip: %w", file.Name, err)
+	}
+
+	if !compression.SafeLink(file.Name, target) {
+		logger.Warn(compression.LogSkipped, compression.LogEntryKey, file.Name)
+		return nil
+	}
+
+	if ed.VerifyManifest {
+		digest := compression.NewDigest()
+		digest.Write([]byte(target))
+		if err := compression.CheckDigest(digest, digests[file.Name]); err != nil {
+			return fmt.Errorf("failed to verify symlink %s: %w", targetPath, err)
+		}
+	}
+
+	return ed.Extractor.Symlink(targetPath, target, file.Modified)
+}
+
+func (ed *EncodeDecoder) extractFile(file *zip.File, targetPath string, digests map[string]string, tracker *compression.Tracker, journal *compression.Journal) error {
+	tracker.Start(file.Name)
+
+	if err := journal.Start(file.Name); err != nil {
+		return err
+	}
+	targetFile, err := ed.Extractor.Create(targetPath, file.Mode(), file.Modified)
+	if err != nil {
+		return err
+	}
+	if targetFile == nil {
+		tracker.Add(int64(file.CompressedSize64))
+		return nil
+	}
+
+	rc, err := file.Open()
+	if err != nil {
+		targetFile.Close()
+		return fmt.Errorf("failed to open file %s in zip: %w", file.Name, err)
+	}
+
+	digest := compression.NewDigest()
+	var dst io.Writer = targetFile
+	if ed.VerifyManifest {
+		dst = io.MultiWriter(targetFile, digest)
+	}
 
+	_, err = io.Copy(dst, rc)
+	if err != nil {
 		rc.Close()
-		if err := targetFile.Close(); err != nil {
-			return fmt.Errorf("failed to close file %s: %w", targetPath, err)
+		targetFile.Close()
+		return fmt.Errorf("failed to write file %s: %w", targetPath, err)
+	}
+
+	rc.Close()
+
+	if ed.VerifyManifest && file.Name != compression.ManifestName {
+		if err := compression.CheckDigest(digest, digests[file.Name]); err != nil {
+			targetFile.Close()
+			return fmt.Errorf("failed to verify file %s: %w", targetPath, err)
 		}
 	}
 
+	if err := targetFile.Commit(); err != nil {
+		return err
+	}
+
+	offset, err := file.DataOffset()
+	if err != nil {
+		return err
+	}
+	if err := journal.Record(file.Name, targetPath, offset+int64(file.CompressedSize64)); err != nil {
+		return err
+	}
+
+	tracker.Add(int64(file.CompressedSize64))
+
 	return nil
 }
+
+// Test reads every entry without extracting it, checking the central
+// directory, each entry's CRC-32 and, when present, MANIFEST.sha256.
+func (ed *EncodeDecoder) Test() error {
+	reader, err := OpenReader(ed.OutputPath)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	digests, err := readManifest(reader.Reader)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range reader.File {
+		if err := testFile(file, digests); err != nil {
+			return &compression.EntryError{Name: file.Name, Err: err}
+		}
+	}
+
+	return nil
+}
+
+func testFile(file *zip.File, digests map[string]string) error {
+	rc, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	digest := compression.NewDigest()
+	if _, err := io.Copy(digest, rc); err != nil {
+		return err
+	}
+
+	if digests == nil || file.Name == compression.ManifestName || file.FileInfo().IsDir() {
+		return nil
+	}
+
+	return compression.CheckDigest(digest, digests[file.Name])
+}
+
+// readManifest loads MANIFEST.sha256, returning nil if the archive has none.
+func readManifest(reader *zip.Reader) (map[string]string, error) {
+	manifest, err := reader.Open(compression.ManifestName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", compression.ManifestName, err)
+	}
+	defer manifest.Close()
+
+	return compression.ReadManifest(manifest)
+}
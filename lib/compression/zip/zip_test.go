@@ -0,0 +1,125 @@
+package zip
+
+import (
+	"archive/zip"
+	"archivist/lib/compression"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSymlinkRoundTrip(t *testing.T) {
+	for _, threads := range []int{0, 4} {
+		t.Run(fmt.Sprintf("threads=%d", threads), func(t *testing.T) {
+			dir := t.TempDir()
+			src := filepath.Join(dir, "src")
+			if err := os.MkdirAll(filepath.Join(src, "sub"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(src, "sub", "data.txt"), []byte("data"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Symlink("sub/data.txt", filepath.Join(src, "link")); err != nil {
+				t.Fatal(err)
+			}
+
+			archive := filepath.Join(dir, "src.zip")
+			ed := New(archive)
+			ed.Manifest = true
+			ed.Threads = threads
+			if err := ed.Encode([]string{src}); err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+
+			out := filepath.Join(dir, "out")
+			ed = New(archive)
+			ed.VerifyManifest = true
+			ed.Threads = threads
+			if err := ed.Decode(out); err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+
+			link := filepath.Join(out, "src", "link")
+			info, err := os.Lstat(link)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode()&fs.ModeSymlink == 0 {
+				t.Fatalf("%s extracted with mode %v, want a symlink", link, info.Mode())
+			}
+			if target, err := os.Readlink(link); err != nil || target != "sub/data.txt" {
+				t.Errorf("Readlink = %q, %v; want %q", target, err, "sub/data.txt")
+			}
+			if data, err := os.ReadFile(link); err != nil || string(data) != "data" {
+				t.Errorf("reading through the link gives %q, %v", data, err)
+			}
+		})
+	}
+}
+
+func TestUnsafeSymlinkSkipped(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "evil.zip")
+
+	file, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := zip.NewWriter(file)
+	for name, target := range map[string]string{
+		"absolute": "/etc/passwd",
+		"up":       "../outside",
+		"a/deep":   "../../outside",
+		"a/inside": "../absolute",
+	} {
+		header := &zip.FileHeader{Name: name, Modified: time.Now()}
+		header.SetMode(fs.ModeSymlink | 0777)
+		entry, err := w.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry.Write([]byte(target))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	out := filepath.Join(dir, "out")
+	if err := New(archive).Decode(out); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+
+	for _, name := range []string{"absolute", "up", "a/deep"} {
+		if _, err := os.Lstat(filepath.Join(out, name)); !os.IsNotExist(err) {
+			t.Errorf("unsafe link %s extracted: %v", name, err)
+		}
+	}
+	if _, err := os.Readlink(filepath.Join(out, "a", "inside")); err != nil {
+		t.Errorf("link inside the output directory not extracted: %v", err)
+	}
+}
+
+func TestSafeLink(t *testing.T) {
+	tests := []struct {
+		name, target string
+		want         bool
+	}{
+		{"link", "file", true},
+		{"a/link", "../file", true},
+		{"a/b/link", "../c/file", true},
+		{"link", "../file", false},
+		{"a/link", "../../file", false},
+		{"link", "/etc/passwd", false},
+		{"link", "", false},
+	}
+
+	for _, test := range tests {
+		if got := compression.SafeLink(test.name, test.target); got != test.want {
+			t.Errorf("SafeLink(%q, %q) = %v, want %v", test.name, test.target, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// header is the first line of every binary age file.
+const header = "age-encryption.org/v1\n"
+
+var ErrNoIdentity = errors.New("archive is encrypted but no identity was given")
+
+// IsEncrypted reports whether prefix starts with a binary or armored age header.
+func IsEncrypted(prefix []byte) bool {
+	return bytes.HasPrefix(prefix, []byte(header)) || bytes.HasPrefix(prefix, []byte(armor.Header))
+}
+
+// Encrypt wraps w in an age envelope for recipients. With no recipients the
+// writer is returned unchanged, so callers can wrap unconditionally.
+func Encrypt(w io.Writer, recipients []age.Recipient) (io.WriteCloser, error) {
+	if len(recipients) == 0 {
+		return nopWriteCloser{w}, nil
+	}
+
+	encWriter, err := age.Encrypt(w, recipients...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create age writer: %w", err)
+	}
+
+	return encWriter, nil
+}
+
+// Decrypt returns the plaintext of r. Unencrypted input is passed through
+// untouched, so callers can wrap unconditionally.
+func Decrypt(r io.Reader, identities []age.Identity) (io.Reader, error) {
+	buffered := bufio.NewReader(r)
+
+	prefix, err := buffered.Peek(len(armor.Header))
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read archive header: %w", err)
+	}
+
+	if !IsEncrypted(prefix) {
+		return buffered, nil
+	}
+	if len(identities) == 0 {
+		return nil, ErrNoIdentity
+	}
+
+	var src io.Reader = buffered
+	if bytes.HasPrefix(prefix, []byte(armor.Header)) {
+		src = armor.NewReader(buffered)
+	}
+
+	plain, err := age.Decrypt(src, identities...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt archive: %w", err)
+	}
+
+	return plain, nil
+}
+
+// ParseRecipients builds age recipients from public keys, recipient files
+// and an optional passphrase. A passphrase can't be mixed with public keys.
+func ParseRecipients(keys []string, files []string, passphrase string) ([]age.Recipient, error) {
+	var recipients []age.Recipient
+
+	for _, key := range keys {
+		recipient, err := age.ParseX25519Recipient(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid recipient %s: %w", key, err)
+		}
+		recipients = append(recipients, recipient)
+	}
+
+	for _, path := range files {
+		parsed, err := parseFile(path, age.ParseRecipients)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read recipients file %s: %w", path, err)
+		}
+		recipients = append(recipients, parsed...)
+	}
+
+	if passphrase != "" {
+		if len(recipients) > 0 {
+			return nil, fmt.Errorf("passphrase can't be combined with recipients")
+		}
+		recipient, err := age.NewScryptRecipient(passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("invalid passphrase: %w", err)
+		}
+		recipients = append(recipients, recipient)
+	}
+
+	return recipients, nil
+}
+
+// ParseIdentities loads age identities from identity files and an optional passphrase.
+func ParseIdentities(files []string, passphrase string) ([]age.Identity, error) {
+	var identities []age.Identity
+
+	for _, path := range files {
+		parsed, err := parseFile(path, age.ParseIdentities)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read identity file %s: %w", path, err)
+		}
+		identities = append(identities, parsed...)
+	}
+
+	if passphrase != "" {
+		identity, err := age.NewScryptIdentity(passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("invalid passphrase: %w", err)
+		}
+		identities = append(identities, identity)
+	}
+
+	return identities, nil
+}
+
+// ReadPassphrase reads a passphrase from the first line of path.
+func ReadPassphrase(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read passphrase file %s: %w", path, err)
+	}
+
+	passphrase, _, _ := strings.Cut(string(data), "\n")
+	passphrase = strings.TrimSuffix(passphrase, "\r")
+	if passphrase == "" {
+		return "", fmt.Errorf("passphrase file %s is empty", path)
+	}
+
+	return passphrase, nil
+}
+
+func parseFile[T any](path string, parse func(io.Reader) ([]T, error)) ([]T, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return parse(file)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,131 @@
+package encryption
+
+import (
+	"bytes"
+	"errors"
+	"filippo.io/age"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func encrypt(t *testing.T, plain []byte, recipients []age.Recipient) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w, err := Encrypt(&buf, recipients)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(plain); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func decrypt(data []byte, identities []age.Identity) ([]byte, error) {
+	r, err := Decrypt(bytes.NewReader(data), identities)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestRoundTrip(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plain := bytes.Repeat([]byte("archive data "), 10000)
+	data := encrypt(t, plain, []age.Recipient{identity.Recipient()})
+	if !IsEncrypted(data) {
+		t.Fatal("IsEncrypted doesn't recognize the age header")
+	}
+
+	got, err := decrypt(data, []age.Identity{other, identity})
+	if err != nil || !bytes.Equal(got, plain) {
+		t.Errorf("Decrypt = %d bytes, %v; want the plaintext", len(got), err)
+	}
+
+	if _, err := decrypt(data, []age.Identity{other}); err == nil {
+		t.Error("Decrypt with the wrong identity succeeded")
+	}
+	if _, err := decrypt(data, nil); !errors.Is(err, ErrNoIdentity) {
+		t.Errorf("Decrypt without identities = %v, want %v", err, ErrNoIdentity)
+	}
+}
+
+// Without recipients nothing is encrypted, and plaintext reads back as is.
+func TestPassThrough(t *testing.T) {
+	plain := []byte("not encrypted")
+	data := encrypt(t, plain, nil)
+	if !bytes.Equal(data, plain) {
+		t.Errorf("Encrypt without recipients wrote %q", data)
+	}
+
+	got, err := decrypt(data, nil)
+	if err != nil || !bytes.Equal(got, plain) {
+		t.Errorf("Decrypt of plaintext = %q, %v", got, err)
+	}
+}
+
+func TestPassphrase(t *testing.T) {
+	recipient, err := age.NewScryptRecipient("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The default work factor takes seconds to decrypt.
+	recipient.SetWorkFactor(10)
+	data := encrypt(t, []byte("secret"), []age.Recipient{recipient})
+
+	identities, err := ParseIdentities(nil, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := decrypt(data, identities); err != nil || string(got) != "secret" {
+		t.Errorf("Decrypt with the passphrase = %q, %v", got, err)
+	}
+
+	wrong, err := ParseIdentities(nil, "wrong")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decrypt(data, wrong); err == nil {
+		t.Error("Decrypt with the wrong passphrase succeeded")
+	}
+}
+
+func TestParseRecipients(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := identity.Recipient().String()
+
+	file := filepath.Join(t.TempDir(), "team.keys")
+	if err := os.WriteFile(file, []byte("# team\n"+key+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	recipients, err := ParseRecipients([]string{key}, []string{file}, "")
+	if err != nil || len(recipients) != 2 {
+		t.Errorf("ParseRecipients = %d recipients, %v; want 2", len(recipients), err)
+	}
+
+	if _, err := ParseRecipients([]string{key}, nil, "passphrase"); err == nil || !strings.Contains(err.Error(), "passphrase") {
+		t.Errorf("ParseRecipients with a key and a passphrase = %v, want an error", err)
+	}
+	if _, err := ParseRecipients([]string{"age1invalid"}, nil, ""); err == nil {
+		t.Error("ParseRecipients accepted an invalid key")
+	}
+}
@@ -0,0 +1,107 @@
+package signature
+
+import (
+	"aead.dev/minisign"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Extension is appended to an archive path to name its detached signature.
+const Extension = ".minisig"
+
+var ErrInvalidSignature = errors.New("signature verification failed")
+
+// Path returns the default detached signature path for archivePath.
+func Path(archivePath string) string {
+	return archivePath + Extension
+}
+
+// Sign writes a minisign-compatible, pre-hashed ed25519 signature of the
+// archive to sigPath, using the encrypted minisign secret key at keyPath.
+func Sign(archivePath, sigPath, keyPath, passphrase string) error {
+	privateKey, err := minisign.PrivateKeyFromFile(passphrase, keyPath)
+	if err != nil {
+		return fmt.Errorf("failed to load secret key %s: %w", keyPath, err)
+	}
+
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to open archive %s: %w", archivePath, err)
+	}
+	defer file.Close()
+
+	reader := minisign.NewReader(file)
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		return fmt.Errorf("failed to read archive %s: %w", archivePath, err)
+	}
+
+	// Same comment layout as the minisign tool, so its -V output stays familiar.
+	trustedComment := "timestamp:" + strconv.FormatInt(time.Now().Unix(), 10) +
+		"\tfile:" + filepath.Base(archivePath) + "\thashed"
+	untrustedComment := "signature from archivist secret key " +
+		strings.ToUpper(strconv.FormatUint(privateKey.ID(), 16))
+
+	sig := append(reader.SignWithComments(privateKey, trustedComment, untrustedComment), '\n')
+	if err := os.WriteFile(sigPath, sig, 0644); err != nil {
+		return fmt.Errorf("failed to write signature %s: %w", sigPath, err)
+	}
+
+	return nil
+}
+
+// Verify checks the detached signature at sigPath against the archive.
+func Verify(archivePath, sigPath string, publicKey minisign.PublicKey) error {
+	return VerifyCopy(io.Discard, archivePath, sigPath, publicKey)
+}
+
+// VerifyCopy copies the archive to dst while checking it against the
+// detached signature at sigPath, so that dst holds exactly the bytes that
+// were verified. dst must not be used when it returns an error.
+func VerifyCopy(dst io.Writer, archivePath, sigPath string, publicKey minisign.PublicKey) error {
+	sig, err := os.ReadFile(sigPath)
+	if err != nil {
+		return fmt.Errorf("failed to read signature %s: %w", sigPath, err)
+	}
+
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to open archive %s: %w", archivePath, err)
+	}
+	defer file.Close()
+
+	reader := minisign.NewReader(file)
+	if _, err := io.Copy(dst, reader); err != nil {
+		return fmt.Errorf("failed to read archive %s: %w", archivePath, err)
+	}
+
+	if !reader.Verify(publicKey, sig) {
+		return fmt.Errorf("%s: %w", archivePath, ErrInvalidSignature)
+	}
+
+	return nil
+}
+
+// ParsePublicKey accepts either a minisign public key file or the
+// base64-encoded key itself, as printed by minisign -G.
+func ParsePublicKey(value string) (minisign.PublicKey, error) {
+	var publicKey minisign.PublicKey
+
+	text := value
+	if data, err := os.ReadFile(value); err == nil {
+		// Key files carry an untrusted comment line before the key.
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		text = strings.TrimSpace(lines[len(lines)-1])
+	}
+
+	if err := publicKey.UnmarshalText([]byte(text)); err != nil {
+		return publicKey, fmt.Errorf("invalid public key %s: %w", value, err)
+	}
+
+	return publicKey, nil
+}